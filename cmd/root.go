@@ -21,23 +21,36 @@ package cmd
 import (
 	"fmt"
 	"os"
-	"strings"
 
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/cmd/config"
 	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/cmd/poll"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/cmd/registries"
 	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/cmd/watch"
 	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/configuration"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/election"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/queue"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/services"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/shutdown"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 )
 
 var (
-	logger         zerolog.Logger
-	debugMode      bool
-	interval       int
-	metadataKey    string
-	endpoint       string
-	configFilePath string
+	logger            zerolog.Logger
+	debugMode         bool
+	interval          int
+	endpoint          string
+	configFilePath    string
+	eventsVersion     string
+	queueOpts         queue.Options
+	drainTimeout      int
+	pendingEventsFile string
+	leaderElection    bool
+	electionOpts      election.Options
+	filters           []string
+	filterExpression  string
+	extraMetadata     services.ExtraMetadata
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -50,8 +63,8 @@ var rootCmd = &cobra.Command{
 observes changes about registered services, delivering found events to a
 a separate handler for processing.`,
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
-		if len(configFilePath) > 0 {
-			configuration.ParseConfigurationFile(cmd)
+		if err := configuration.ParseConfigurationFile(cmd); err != nil {
+			logger.Fatal().Err(err).Msg("could not parse configuration, run config validate to see all the problems")
 		}
 	},
 	Run: func(cmd *cobra.Command, args []string) {
@@ -62,20 +75,15 @@ a separate handler for processing.`,
 			return
 		}
 
-		if conf.ServiceRegistry != nil && conf.ServiceRegistry.GCPServiceDirectory != nil {
-			cmd.SetArgs([]string{"servicedirectory"})
-			cmd.Execute()
-			return
-		}
-
-		if conf.ServiceRegistry != nil && conf.ServiceRegistry.AWSCloudMap != nil {
-			cmd.SetArgs([]string{"poll", "cloudmap"})
-			cmd.Execute()
+		if conf.ServiceRegistry == nil || (conf.ServiceRegistry.GCPServiceDirectory == nil && conf.ServiceRegistry.AWSCloudMap == nil && conf.ServiceRegistry.Etcd == nil) {
+			logger.Fatal().Msg("no service registry provided")
+			cmd.Usage()
 			return
 		}
 
-		logger.Fatal().Msg("no service registry provided")
-		cmd.Usage()
+		// All the service registries in the configuration are run at the
+		// same time.
+		registries.Run(cmd, conf)
 	},
 }
 
@@ -95,10 +103,53 @@ func init() {
 	rootCmd.PersistentFlags().IntVarP(&interval, "interval", "i", 5, "number of seconds between two consecutive polls")
 	rootCmd.PersistentFlags().StringVar(&endpoint, "adaptor-api", "localhost:80/cnwan", "the api, in forrm of host:port/path, where the events will be sent to. Look at the documentation to learn more about this.")
 	rootCmd.PersistentFlags().StringVar(&configFilePath, "conf", "", "path to the configuration file, if any")
+	rootCmd.PersistentFlags().IntVar(&queueOpts.MaxEventsPerRequest, "max-events-per-request", 0, "maximum number of events sent to the adaptor in a single request, 0 means no limit")
+	rootCmd.PersistentFlags().IntVar(&queueOpts.MaxBytesPerRequest, "max-bytes-per-request", 0, "maximum size in bytes of the events sent to the adaptor in a single request, 0 means no limit")
+	rootCmd.PersistentFlags().Float64Var(&queueOpts.RateLimit, "rate-limit", 0, "maximum number of requests per second sent to the adaptor, 0 means no limit")
+	rootCmd.PersistentFlags().IntVar(&queueOpts.RateLimitBurst, "rate-limit-burst", 1, "number of requests that can be sent to the adaptor at once before --rate-limit kicks in")
+	rootCmd.PersistentFlags().IntVar(&drainTimeout, "drain-timeout", shutdown.DefaultDrainTimeout, "number of seconds to wait for the events still in the queue to be sent when exiting")
+	rootCmd.PersistentFlags().StringVar(&pendingEventsFile, "pending-events-file", "", "path of the file where events that could not be sent when exiting are saved, to be sent on next run")
+	rootCmd.PersistentFlags().BoolVar(&leaderElection, "leader-election", false, "whether to take part in the leader election among more replicas: only the leader sends events to the adaptor")
+	rootCmd.PersistentFlags().StringVar(&electionOpts.ID, "leader-election-id", "", "the id of this replica in the leader election, defaults to the hostname")
+	rootCmd.PersistentFlags().StringVar(&electionOpts.Key, "leader-election-key", election.DefaultKey, "the prefix of the etcd keys used for the leader election")
+	rootCmd.PersistentFlags().IntVar(&electionOpts.TTL, "leader-election-ttl", election.DefaultTTL, "number of seconds after which the leader is considered dead if it doesn't renew its lease")
+	rootCmd.PersistentFlags().StringSliceVar(&electionOpts.Endpoints, "leader-election-endpoints", []string{}, "endpoints of the etcd cluster used for the leader election")
+	rootCmd.PersistentFlags().StringVar(&eventsVersion, "events-version", services.DefaultEventsVersion, "the format of the events sent to the adaptor: v2, or v1 for adaptors that only support the legacy format")
+	rootCmd.PersistentFlags().StringArrayVar(&filters, "filter", []string{}, "expression on the values of the metadata that services must satisfy to be sent to the adaptor, i.e. 'traffic-profile in (video, voip)': can be repeated")
+	rootCmd.PersistentFlags().StringVar(&filterExpression, "filter-expression", "", "expression on the whole service that services must satisfy to be sent to the adaptor, i.e. 'inCIDR(address, \"10.0.0.0/8\") && port == 443'")
+	rootCmd.PersistentFlags().BoolVar(&extraMetadata.All, "all-metadata", false, "whether to include all metadata of services in events, not only the metadata keys")
+	rootCmd.PersistentFlags().StringSliceVar(&extraMetadata.Include, "include-metadata", []string{}, "other metadata keys to include in events, if services have them")
+	rootCmd.PersistentFlags().StringSliceVar(&extraMetadata.Exclude, "exclude-metadata", []string{}, "metadata keys never to include in events, unless they are among the metadata keys")
+
+	// Fields of the configuration overridden by flags
+	for flag, path := range map[string]string{
+		"debug":                     "debugMode",
+		"adaptor-api":               "adaptor",
+		"events-version":            "eventsVersion",
+		"filter":                    "filters",
+		"filter-expression":         "filterExpression",
+		"all-metadata":              "extraMetadata.all",
+		"include-metadata":          "extraMetadata.include",
+		"exclude-metadata":          "extraMetadata.exclude",
+		"max-events-per-request":    "delivery.maxEventsPerRequest",
+		"max-bytes-per-request":     "delivery.maxBytesPerRequest",
+		"rate-limit":                "delivery.rateLimit",
+		"rate-limit-burst":          "delivery.rateLimitBurst",
+		"drain-timeout":             "delivery.drainTimeout",
+		"pending-events-file":       "delivery.pendingEventsFile",
+		"leader-election":           "leaderElection.enabled",
+		"leader-election-id":        "leaderElection.id",
+		"leader-election-key":       "leaderElection.key",
+		"leader-election-ttl":       "leaderElection.ttl",
+		"leader-election-endpoints": "leaderElection.endpoints",
+	} {
+		configuration.BindFlag(rootCmd.PersistentFlags(), flag, path)
+	}
 
 	// Add the poll command
 	rootCmd.AddCommand(poll.GetPollCommand())
 	rootCmd.AddCommand(watch.GetWatchCommand())
+	rootCmd.AddCommand(config.GetConfigCommand())
 }
 
 func initConfig() {
@@ -117,17 +168,3 @@ func initConfig() {
 	}())
 	logger = log.Logger
 }
-
-// TODO: remove this and use utils.SanitizeLocalhost.
-func sanitizeAdaptorEndpoint(endp string) string {
-	endp = strings.Trim(endp, "/")
-
-	if strings.HasPrefix(endp, "localhost") {
-		// Replace localhost in case we are running insde docker
-		if mode := os.Getenv("MODE"); len(mode) > 0 && mode == "docker" {
-			return strings.Replace(endp, "localhost", "host.docker.internal", 1)
-		}
-	}
-
-	return endp
-}
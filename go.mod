@@ -6,11 +6,14 @@ require (
 	cloud.google.com/go/servicedirectory v0.1.0
 	github.com/CloudNativeSDWAN/cnwan-operator v0.6.0
 	github.com/aws/aws-sdk-go v1.38.60
+	github.com/google/cel-go v0.7.3
 	github.com/google/go-cmp v0.5.6
 	github.com/rs/zerolog v1.19.0
 	github.com/spf13/cobra v1.0.0
+	github.com/spf13/pflag v1.0.5
 	github.com/stretchr/testify v1.7.0
 	go.etcd.io/etcd/api/v3 v3.5.1
+	go.etcd.io/etcd/client/pkg/v3 v3.5.1
 	go.etcd.io/etcd/client/v3 v3.5.1
 	golang.org/x/oauth2 v0.0.0-20210805134026-6f1e6394065a
 	google.golang.org/api v0.54.0
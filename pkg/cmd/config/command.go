@@ -0,0 +1,166 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/cmd/poll/cloudmap"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/cmd/poll/servicedirectory"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/cmd/watch/etcd"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/configuration"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// sections resolve the sections of the service registries with the
+	// same functions used by their commands.
+	sections = map[string]configuration.SectionFunc{
+		"serviceRegistry.awsCloudMap":         cloudmap.ResolveConfig,
+		"serviceRegistry.gcpServiceDirectory": servicedirectory.ResolveConfig,
+		"serviceRegistry.etcd":                etcd.ResolveConfig,
+	}
+)
+
+// GetConfigCommand returns the config command and all its subcommands
+func GetConfigCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config [COMMAND] [flags]",
+		Short: "work with the configuration file",
+		Long: `config contains commands to work with the configuration file
+provided with --conf, without connecting to any service registry.`,
+		Example: `config validate --conf ./config.yaml
+config print poll cloudmap --conf ./config.yaml --region us-west-2`,
+		// The configuration file is read by the subcommands themselves,
+		// so that problems are reported instead of stopping the program.
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	}
+
+	// Subcommands
+	cmd.AddCommand(getValidateCommand())
+	cmd.AddCommand(getPrintCommand())
+
+	return cmd
+}
+
+func getValidateCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "validate --conf <path>",
+		Short: "validate the configuration file",
+		Long: `validate checks that the configuration file provided with
+--conf can be parsed, that it has no unknown fields and that all its values
+are valid, and reports all the problems found.
+
+The program exits with 1 if any problem is found.`,
+		Example: "config validate --conf ./config.yaml",
+		Run: func(cmd *cobra.Command, args []string) {
+			filePath, _ := cmd.Flags().GetString("conf")
+			if err := validate(cmd.OutOrStdout(), filePath); err != nil {
+				os.Exit(1)
+			}
+		},
+	}
+}
+
+// validate writes to out whether the configuration file is valid or all
+// the problems found in it, and returns a non nil error in the latter
+// case.
+func validate(out io.Writer, filePath string) error {
+	if len(filePath) == 0 {
+		err := errors.New("no configuration file provided with --conf")
+		fmt.Fprintln(out, err)
+		return err
+	}
+
+	_, err := configuration.Load(filePath)
+	if err == nil {
+		fmt.Fprintf(out, "%s: configuration is valid\n", filePath)
+		return nil
+	}
+
+	var valErr *configuration.ValidationError
+	if !errors.As(err, &valErr) {
+		fmt.Fprintf(out, "%s: %s\n", filePath, err)
+		return err
+	}
+
+	fmt.Fprintf(out, "%s: invalid configuration\n", filePath)
+	for _, fieldErr := range valErr.Errors {
+		fmt.Fprintf(out, "  - %s\n", fieldErr)
+	}
+	return err
+}
+
+func getPrintCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "print [COMMAND] [flags]",
+		Short: "print the effective configuration of a command",
+		Long: `print resolves the configuration that the provided command
+would use with the provided flags, configuration file and environment
+variables, and prints it as YAML with where each value comes from: flag,
+env, file or default. Passwords are redacted.
+
+Without a command, the configuration used when running all the service
+registries in the configuration file is printed.`,
+		Example: "config print poll cloudmap --conf ./config.yaml --region us-west-2",
+		// Flags belong to the command to resolve, so they are parsed
+		// later.
+		DisableFlagParsing: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			for _, arg := range args {
+				if arg == "-h" || arg == "--help" {
+					cmd.Help()
+					return
+				}
+			}
+
+			if err := printEffective(cmd.OutOrStdout(), cmd.Root(), args); err != nil {
+				fmt.Fprintln(cmd.ErrOrStderr(), err)
+				os.Exit(1)
+			}
+		},
+	}
+}
+
+// printEffective writes to out the effective configuration of the command
+// found in args, starting from root, with the flags in args.
+func printEffective(out io.Writer, root *cobra.Command, args []string) error {
+	target, flags, err := root.Traverse(args)
+	if err != nil {
+		return err
+	}
+
+	if err := target.ParseFlags(flags); err != nil {
+		return err
+	}
+
+	if err := configuration.ParseConfigurationFile(target); err != nil {
+		return err
+	}
+
+	conf, origins, err := configuration.Resolve(target, sections)
+	if err != nil {
+		return err
+	}
+
+	return configuration.WriteWithOrigins(out, conf, origins)
+}
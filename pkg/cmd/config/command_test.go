@@ -0,0 +1,153 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/cmd/poll"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/cmd/watch"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/configuration"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	a := assert.New(t)
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+	dir, err := ioutil.TempDir("", "cnwan-reader")
+	if !a.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		content string
+		expOut  string
+		expErr  bool
+	}{
+		{
+			content: "adaptor: localhost:8080\n",
+			expOut:  "%s: configuration is valid\n",
+		},
+		{
+			content: "adaptr: localhost:8080\neventsVersion: v3\n",
+			expOut:  "%s: invalid configuration\n  - adaptr: unknown field\n  - eventsVersion: unsupported value \"v3\", must be one of v1, v2\n",
+			expErr:  true,
+		},
+		{
+			content: "adaptor: [\n",
+			expOut:  "%s: yaml: line 1: did not find expected node content\n",
+			expErr:  true,
+		},
+	}
+
+	for i, currCase := range cases {
+		filePath := path.Join(dir, fmt.Sprintf("config-%d.yaml", i))
+		ioutil.WriteFile(filePath, []byte(currCase.content), 0644)
+
+		out := &bytes.Buffer{}
+		err := validate(out, filePath)
+		if !a.Equal(currCase.expErr, err != nil) || !a.Equal(fmt.Sprintf(currCase.expOut, filePath), out.String()) {
+			failed(i)
+		}
+	}
+
+	out := &bytes.Buffer{}
+	a.Error(validate(out, ""))
+	a.Equal("no configuration file provided with --conf\n", out.String())
+}
+
+func TestPrintEffective(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "cnwan-reader")
+	if !a.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	filePath := path.Join(dir, "config.yaml")
+	ioutil.WriteFile(filePath, []byte("adaptor: localhost:8080\nserviceRegistry:\n  etcd:\n    prefix: /prefix\n    credentials:\n      username: user\n  awsCloudMap:\n    region: us-west-2\n    metadataSource: attributes\n"), 0644)
+
+	root := &cobra.Command{Use: "root", TraverseChildren: true}
+	root.PersistentFlags().String("conf", "", "")
+	root.PersistentFlags().String("events-version", "v2", "")
+	configuration.BindFlag(root.PersistentFlags(), "events-version", "eventsVersion")
+	watchCmd := &cobra.Command{Use: "watch"}
+	etcd := &cobra.Command{
+		Use:         "etcd",
+		Annotations: map[string]string{configuration.SectionAnnotation: "serviceRegistry.etcd"},
+		Run:         func(*cobra.Command, []string) {},
+	}
+	etcd.Flags().String("password", "", "")
+	configuration.BindFlag(etcd.Flags(), "password", ".credentials.password")
+	watchCmd.AddCommand(etcd)
+	root.AddCommand(watchCmd)
+
+	out := &bytes.Buffer{}
+	a.NoError(printEffective(out, root, []string{"watch", "etcd", "--conf", filePath, "--password", "pass", "--events-version", "v1"}))
+	a.Equal(`adaptor: localhost:8080 # file
+eventsVersion: v1 # flag
+serviceRegistry:
+  etcd:
+    credentials:
+      username: user # file
+      password: <redacted> # flag
+    prefix: /prefix # file
+`, out.String())
+
+	a.Error(printEffective(out, root, []string{"watch", "etcd", "--unknown"}))
+
+	// The sections of the service registries are resolved by their
+	// commands.
+	root = &cobra.Command{Use: "root", TraverseChildren: true}
+	root.PersistentFlags().String("conf", "", "")
+	root.AddCommand(poll.GetPollCommand())
+	root.AddCommand(watch.GetWatchCommand())
+
+	out.Reset()
+	a.NoError(printEffective(out, root, []string{"poll", "cloudmap", "--conf", filePath, "--with-tags", "--poll-interval", "10"}))
+	a.Equal(`adaptor: localhost:8080 # file
+serviceRegistry:
+  awsCloudMap:
+    region: us-west-2 # file
+    pollInterval: 10 # flag
+    healthStatus: ignore # default
+    metadataSource: tags # flag
+    metadataPrecedence: instance # default
+`, out.String())
+
+	out.Reset()
+	a.NoError(printEffective(out, root, []string{"watch", "etcd", "--conf", filePath, "--username", "", "--password", "pass"}))
+	a.Equal(`adaptor: localhost:8080 # file
+serviceRegistry:
+  etcd:
+    endpoints: # default
+      - localhost:2379
+    credentials:
+      password: <redacted> # flag
+    prefix: /prefix # file
+`, out.String())
+}
@@ -23,9 +23,11 @@ import (
 	"fmt"
 	"path"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/internal/utils"
 	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/servicediscovery"
@@ -33,176 +35,385 @@ import (
 )
 
 const (
-	awsIPv4Attr            string        = "AWS_INSTANCE_IPV4"
+	awsIPv4Attr string = "AWS_INSTANCE_IPV4"
+	// awsReservedAttrPrefix is the prefix of the attributes reserved by
+	// Cloud Map, i.e. the address of the instance.
+	awsReservedAttrPrefix  string        = "AWS_"
 	awsIPv6Attr            string        = "AWS_INSTANCE_IPV6"
 	awsPortAttr            string        = "AWS_INSTANCE_PORT"
 	awsDefaultInstancePort int32         = 80
 	defaultTimeout         time.Duration = 30 * time.Second
+
+	// healthStatusIgnore reports all instances, regardless of their health
+	// status.
+	healthStatusIgnore string = "ignore"
+	// healthStatusExclude doesn't report unhealthy instances, so they are
+	// deleted when they become unhealthy.
+	healthStatusExclude string = "exclude"
+	// healthStatusMetadata reports all instances with their health status
+	// included in their metadata.
+	healthStatusMetadata string = "metadata"
+	// healthStatusMetadataKey is the metadata key that contains the health
+	// status of the instance when healthStatusMetadata is used.
+	healthStatusMetadataKey string = "cnwan.io/health-status"
+
+	// metadataFromAttributes reads metadata from the attributes of
+	// instances.
+	metadataFromAttributes string = "attributes"
+	// metadataFromTags reads metadata from the tags of services.
+	metadataFromTags string = "tags"
+	// metadataFromBoth reads metadata from both the tags of services and
+	// the attributes of instances.
+	metadataFromBoth string = "both"
+	// precedenceInstance makes attributes of instances override tags of
+	// their service, when reading metadata from both.
+	precedenceInstance string = "instance"
+	// precedenceService makes tags of services override attributes of
+	// their instances, when reading metadata from both.
+	precedenceService string = "service"
+
+	// maxConcurrentServices is the maximum number of services whose
+	// instances are fetched at the same time.
+	maxConcurrentServices int = 10
+
+	// regionMetadataKey is the metadata key that contains the region of
+	// the instance, when more regions or accounts are polled.
+	regionMetadataKey string = "cnwan.io/region"
+	// accountMetadataKey is the metadata key that contains the account of
+	// the instance, when more accounts are polled.
+	accountMetadataKey string = "cnwan.io/account"
 )
 
 type awsCloudMap struct {
 	opts *options
 	sd   servicediscoveryiface.ServiceDiscoveryAPI
+	// region and account are only set when more regions or accounts are
+	// polled: in that case, they are included in the keys and metadata
+	// of the instances, so that instances from different ones don't
+	// collide.
+	region  string
+	account string
+	// last contains the instances read in the last poll for each service,
+	// so that a service whose instances cannot be read keeps them.
+	last map[string]map[string]*openapi.Service
 }
 
-func (a *awsCloudMap) getServiceTags(ctx context.Context) (map[string]*openapi.Service, error) {
-	out, err := a.sd.ListServicesWithContext(ctx, &servicediscovery.ListServicesInput{})
-	if err != nil {
-		return nil, err
-	}
-
-	keysMap := map[string]bool{}
-	for _, key := range a.opts.keys {
-		keysMap[key] = true
-	}
-
-	servTags := map[string]*openapi.Service{}
-	for _, srv := range out.Services {
-		l := log.With().Str("service-name", aws.StringValue(srv.Name)).Logger()
+// cloudMaps polls more regions or accounts of Cloud Map at the same time.
+type cloudMaps []*awsCloudMap
+
+// getCurrentState returns the instances of all regions and accounts. An
+// error is returned if any of them could not be read, so that a partial
+// state is never mistaken for the current one.
+func (c cloudMaps) getCurrentState(ctx context.Context) (map[string]*openapi.Service, error) {
+	var (
+		wg       sync.WaitGroup
+		locker   sync.Mutex
+		firstErr error
+		oaSrvs   = map[string]*openapi.Service{}
+	)
+
+	for _, cm := range c {
+		wg.Add(1)
+		go func(cm *awsCloudMap) {
+			defer wg.Done()
 
-		metadata := func() map[string]string {
-			tagsCtx, tagsCanc := context.WithTimeout(ctx, 30*time.Second)
-			defer tagsCanc()
+			srvs, err := cm.getCurrentState(ctx)
 
-			out, err := a.sd.ListTagsForResourceWithContext(tagsCtx, &servicediscovery.ListTagsForResourceInput{
-				ResourceARN: srv.Arn,
-			})
+			locker.Lock()
+			defer locker.Unlock()
 			if err != nil {
-				l.Warn().Err(err).Msg("could not get tags for service: skipping...")
-				return map[string]string{}
-			}
-
-			tags := map[string]string{}
-			for _, tag := range out.Tags {
-				if _, exists := keysMap[aws.StringValue(tag.Key)]; exists {
-					tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+				if firstErr == nil {
+					firstErr = err
+					if prefix, _ := cm.scope(); len(prefix) > 0 {
+						firstErr = fmt.Errorf("error while reading %s: %w", prefix, err)
+					}
 				}
+				return
 			}
-			return tags
-		}()
-
-		if len(metadata) == 0 {
-			continue
-		}
-
-		endps, err := func() ([]*openapi.Service, error) {
-			instCtx, instCanc := context.WithTimeout(ctx, 30*time.Second)
-			defer instCanc()
 
-			insts, err := a.sd.ListInstancesWithContext(instCtx, &servicediscovery.ListInstancesInput{
-				ServiceId: srv.Id,
-			})
-			if err != nil {
-				return nil, err
+			for key, srv := range srvs {
+				oaSrvs[key] = srv
 			}
+		}(cm)
+	}
+	wg.Wait()
 
-			srvEp := []*openapi.Service{}
-			for _, inst := range insts.Instances {
-				srvEp = append(srvEp, &openapi.Service{
-					Name:    aws.StringValue(inst.Id),
-					Address: aws.StringValue(inst.Attributes["AWS_INSTANCE_IPV4"]),
-					Port: func() int32 {
-						val, _ := strconv.ParseInt(aws.StringValue(inst.Attributes["AWS_INSTANCE_PORT"]), 10, 32)
-						return int32(val)
-					}(),
-				})
-			}
-			return srvEp, nil
-		}()
-		if err != nil {
-			l.Err(err).Msg("error while getting instances for service: skipping...")
-			continue
-		}
+	if firstErr != nil {
+		return nil, firstErr
+	}
 
-		for _, endp := range endps {
-			name := path.Join(aws.StringValue(srv.Name), endp.Name)
-			servTags[name] = &openapi.Service{
-				Name:    name,
-				Address: endp.Address,
-				Port:    endp.Port,
-				Metadata: func() (met []openapi.Metadata) {
-					for k, v := range metadata {
-						met = append(met, openapi.Metadata{Key: k, Value: v})
-					}
-					return
-				}(),
-			}
-		}
+	return oaSrvs, nil
+}
+
+// scope returns the prefix of the keys of the instances and the metadata
+// that identify the region and account they belong to, if any.
+func (a *awsCloudMap) scope() (string, []openapi.Metadata) {
+	prefix, metadata := "", []openapi.Metadata{}
+	if len(a.account) > 0 {
+		prefix = path.Join("accounts", a.account)
+		metadata = append(metadata, openapi.Metadata{Key: accountMetadataKey, Value: a.account})
+	}
+	if len(a.region) > 0 {
+		prefix = path.Join(prefix, "regions", a.region)
+		metadata = append(metadata, openapi.Metadata{Key: regionMetadataKey, Value: a.region})
 	}
 
-	return servTags, nil
+	return prefix, metadata
 }
 
 func (a *awsCloudMap) getCurrentState(ctx context.Context) (map[string]*openapi.Service, error) {
 	srvCtx, srvCanc := context.WithTimeout(ctx, defaultTimeout)
-	srvIDs, err := a.getServicesIDs(srvCtx)
+	servs, err := a.listServices(srvCtx)
+	srvCanc()
 	if err != nil {
-		srvCanc()
 		return nil, err
 	}
-	srvCanc()
-
-	if len(srvIDs) == 0 {
-		return map[string]*openapi.Service{}, nil
-	}
 
 	var wg sync.WaitGroup
-	wg.Add(len(srvIDs))
 	var locker sync.Mutex
 	oaSrvs := map[string]*openapi.Service{}
+	last := map[string]map[string]*openapi.Service{}
+	prefix, scopeMetadata := a.scope()
+
+	// Limit the number of services that are fetched at the same time
+	sem := make(chan struct{}, maxConcurrentServices)
+
+	for _, srv := range servs {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return nil, ctx.Err()
+		}
 
-	for _, srvID := range srvIDs {
-		go func(id string) {
-			defer wg.Done()
-			instCtx, instCanc := context.WithTimeout(ctx, defaultTimeout)
-			defer instCanc()
+		wg.Add(1)
+		go func(srv *servicediscovery.ServiceSummary) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			id := aws.StringValue(srv.Id)
+			insts, err := a.getServiceInstances(ctx, srv)
+
+			locker.Lock()
+			defer locker.Unlock()
 
-			insts, err := a.getInstances(instCtx, id)
 			if err != nil {
-				log.Err(err).Str("serv-id", id).Msg("could not get instances for this service, skipping...")
+				// Reporting its instances as deleted would be wrong
+				log.Warn().Err(err).Str("serv-id", id).Msg("could not get instances for this service, keeping the previous ones...")
+				last[id] = a.last[id]
+				for oaID, inst := range a.last[id] {
+					oaSrvs[oaID] = inst
+				}
 				return
 			}
 
-			locker.Lock()
-			defer locker.Unlock()
+			last[id] = map[string]*openapi.Service{}
 			for i := 0; i < len(insts); i++ {
-				oaID := fmt.Sprintf("services/%s/endpoints/%s", id, insts[i].Name)
+				oaID := path.Join(prefix, "services", id, "endpoints", insts[i].Name)
+				insts[i].Metadata = append(insts[i].Metadata, scopeMetadata...)
 				oaSrvs[oaID] = insts[i]
+				last[id][oaID] = insts[i]
 			}
-		}(srvID)
+		}(srv)
 	}
 	wg.Wait()
 
+	a.last = last
 	return oaSrvs, nil
 }
 
-func (a *awsCloudMap) getServicesIDs(ctx context.Context) ([]string, error) {
-	out, err := a.sd.ListServicesWithContext(ctx, &servicediscovery.ListServicesInput{})
+// getServiceInstances returns the instances of the provided service that
+// have the target metadata keys, either in their attributes or in the tags
+// of the service, according to the options.
+func (a *awsCloudMap) getServiceInstances(ctx context.Context, srv *servicediscovery.ServiceSummary) ([]*openapi.Service, error) {
+	id := aws.StringValue(srv.Id)
+
+	var tags map[string]string
+	if a.opts.metadataSource == metadataFromTags || a.opts.metadataSource == metadataFromBoth {
+		tagsCtx, tagsCanc := context.WithTimeout(ctx, defaultTimeout)
+		defer tagsCanc()
+
+		_tags, err := a.getServiceTags(tagsCtx, aws.StringValue(srv.Arn))
+		if err != nil {
+			return nil, fmt.Errorf("could not get tags: %w", err)
+		}
+
+		if a.opts.metadataSource == metadataFromTags && !utils.MapContainsKeys(_tags, a.opts.keys) {
+			log.Debug().Str("serv-id", id).Msg("service doesn't have required tags: skipping...")
+			return []*openapi.Service{}, nil
+		}
+		tags = _tags
+	}
+
+	instCtx, instCanc := context.WithTimeout(ctx, defaultTimeout)
+	defer instCanc()
+	return a.getInstances(instCtx, id, tags)
+}
+
+// getServiceTags returns the tags of the service with the provided ARN,
+// but only the ones whose key is among the target metadata keys or must
+// be included anyway.
+func (a *awsCloudMap) getServiceTags(ctx context.Context, arn string) (map[string]string, error) {
+	out, err := a.sd.ListTagsForResourceWithContext(ctx, &servicediscovery.ListTagsForResourceInput{
+		ResourceARN: &arn,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	servIDs := []string{}
-	for _, service := range out.Services {
-		if service.Id != nil && len(*service.Id) > 0 {
-			servIDs = append(servIDs, *service.Id)
-		} else {
-			log.Debug().Msg("found service with no/empty ID has been found: skipping...")
+	keysMap := map[string]bool{}
+	for _, key := range a.opts.keys {
+		keysMap[key] = true
+	}
+
+	tags := map[string]string{}
+	for _, tag := range out.Tags {
+		if key := aws.StringValue(tag.Key); keysMap[key] || a.opts.extraMetadata.Includes(key) {
+			tags[key] = aws.StringValue(tag.Value)
 		}
 	}
 
-	return servIDs, nil
+	return tags, nil
 }
 
-func (a *awsCloudMap) getInstances(ctx context.Context, servID string) ([]*openapi.Service, error) {
-	out, err := a.sd.ListInstancesWithContext(ctx, &servicediscovery.ListInstancesInput{ServiceId: &servID})
+// listServices returns all the services in the namespaces that must be
+// watched, going through all the pages.
+func (a *awsCloudMap) listServices(ctx context.Context) ([]*servicediscovery.ServiceSummary, error) {
+	if len(a.opts.namespaces) == 0 && len(a.opts.excludeNamespaces) == 0 {
+		return a.listServicesPages(ctx, &servicediscovery.ListServicesInput{})
+	}
+
+	nsIDs, err := a.getNamespacesIDs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get namespaces: %w", err)
+	}
+
+	if len(nsIDs) == 0 {
+		log.Debug().Msg("no namespaces to watch have been found")
+	}
+
+	// Services can only be filtered by one namespace at a time
+	servs := []*servicediscovery.ServiceSummary{}
+	for _, nsID := range nsIDs {
+		nsServs, err := a.listServicesPages(ctx, &servicediscovery.ListServicesInput{
+			Filters: []*servicediscovery.ServiceFilter{
+				{
+					Name:      aws.String(servicediscovery.ServiceFilterNameNamespaceId),
+					Condition: aws.String(servicediscovery.FilterConditionEq),
+					Values:    aws.StringSlice([]string{nsID}),
+				},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		servs = append(servs, nsServs...)
+	}
+
+	return servs, nil
+}
+
+// listServicesPages returns the services listed with the provided input,
+// going through all the pages.
+func (a *awsCloudMap) listServicesPages(ctx context.Context, input *servicediscovery.ListServicesInput) ([]*servicediscovery.ServiceSummary, error) {
+	servs := []*servicediscovery.ServiceSummary{}
+	for {
+		out, err := a.sd.ListServicesWithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, serv := range out.Services {
+			if len(aws.StringValue(serv.Id)) == 0 {
+				log.Debug().Msg("found service with no/empty ID: skipping...")
+				continue
+			}
+			servs = append(servs, serv)
+		}
+
+		if aws.StringValue(out.NextToken) == "" {
+			return servs, nil
+		}
+		input.NextToken = out.NextToken
+	}
+}
+
+// getNamespacesIDs returns the IDs of the namespaces that must be watched,
+// according to the --namespaces and --exclude-namespaces options. Both
+// namespaces IDs and names are accepted there.
+func (a *awsCloudMap) getNamespacesIDs(ctx context.Context) ([]string, error) {
+	included := map[string]bool{}
+	for _, ns := range a.opts.namespaces {
+		included[ns] = true
+	}
+	excluded := map[string]bool{}
+	for _, ns := range a.opts.excludeNamespaces {
+		excluded[ns] = true
+	}
+
+	input := &servicediscovery.ListNamespacesInput{}
+	nsIDs := []string{}
+	for {
+		out, err := a.sd.ListNamespacesWithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, ns := range out.Namespaces {
+			id, name := aws.StringValue(ns.Id), aws.StringValue(ns.Name)
+			if len(id) == 0 {
+				continue
+			}
+
+			if len(included) > 0 && !included[id] && !included[name] {
+				continue
+			}
+			if excluded[id] || excluded[name] {
+				continue
+			}
+
+			nsIDs = append(nsIDs, id)
+		}
+
+		if aws.StringValue(out.NextToken) == "" {
+			return nsIDs, nil
+		}
+		input.NextToken = out.NextToken
+	}
+}
+
+// listInstances returns all the instances of the provided service, going
+// through all the pages.
+func (a *awsCloudMap) listInstances(ctx context.Context, servID string) ([]*servicediscovery.InstanceSummary, error) {
+	input := &servicediscovery.ListInstancesInput{ServiceId: &servID}
+	insts := []*servicediscovery.InstanceSummary{}
+	for {
+		out, err := a.sd.ListInstancesWithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		insts = append(insts, out.Instances...)
+
+		if aws.StringValue(out.NextToken) == "" {
+			return insts, nil
+		}
+		input.NextToken = out.NextToken
+	}
+}
+
+// getInstances returns the instances of the provided service that have the
+// target metadata keys. tags contains the target metadata of the service,
+// if tags must be read.
+func (a *awsCloudMap) getInstances(ctx context.Context, servID string, tags map[string]string) ([]*openapi.Service, error) {
+	insts, err := a.listInstances(ctx, servID)
 	if err != nil {
 		return nil, err
 	}
 
 	oaSrvs := []*openapi.Service{}
-	for _, inst := range out.Instances {
-		oaSrv, err := a.parseInstance(servID, inst)
+	for _, inst := range insts {
+		oaSrv, err := a.parseInstance(servID, inst, tags)
 		if err != nil {
 			log.Debug().Err(err).Str("service-id", servID).Msg("invalid instance: skipping...")
 			continue
@@ -211,10 +422,142 @@ func (a *awsCloudMap) getInstances(ctx context.Context, servID string) ([]*opena
 		oaSrvs = append(oaSrvs, oaSrv)
 	}
 
-	return oaSrvs, nil
+	return a.applyHealthStatus(ctx, servID, oaSrvs)
+}
+
+// resolveMetadata returns the value of each target metadata key, and of the
+// other keys that must be included, taken from the attributes of the
+// instance or the tags of its service according to the metadata source and
+// precedence. Keys that are found in neither of them are not included, as
+// well as attributes reserved by Cloud Map, unless explicitly requested.
+func (a *awsCloudMap) resolveMetadata(attrs map[string]*string, tags map[string]string) []openapi.Metadata {
+	fromAttrs := map[string]string{}
+	if a.opts.metadataSource != metadataFromTags {
+		explicit := a.opts.extraMetadata.TrackedKeys(a.opts.keys)
+		for key, val := range attrs {
+			if strings.HasPrefix(key, awsReservedAttrPrefix) && !contains(explicit, key) {
+				continue
+			}
+			if attrVal := aws.StringValue(val); len(attrVal) > 0 {
+				fromAttrs[key] = attrVal
+			}
+		}
+	}
+
+	fromTags := map[string]string{}
+	if a.opts.metadataSource != metadataFromAttributes {
+		fromTags = tags
+	}
+
+	// The values of the second ones win over the first ones.
+	first, second := fromTags, fromAttrs
+	if a.opts.metadataPrecedence == precedenceService {
+		first, second = second, first
+	}
+
+	merged := map[string]string{}
+	for key, val := range first {
+		merged[key] = val
+	}
+	for key, val := range second {
+		merged[key] = val
+	}
+
+	return a.opts.extraMetadata.Select(merged, a.opts.keys)
 }
 
-func (a *awsCloudMap) parseInstance(servID string, inst *servicediscovery.InstanceSummary) (*openapi.Service, error) {
+// hasKeys returns true if the metadata contain all the provided keys.
+func hasKeys(metadata []openapi.Metadata, keys []string) bool {
+	found := map[string]bool{}
+	for _, md := range metadata {
+		found[md.Key] = true
+	}
+
+	for _, key := range keys {
+		if !found[key] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getHealthStatus returns the health status of all the instances of the
+// provided service, going through all the pages.
+func (a *awsCloudMap) getHealthStatus(ctx context.Context, servID string) (map[string]string, error) {
+	input := &servicediscovery.GetInstancesHealthStatusInput{ServiceId: &servID}
+	statuses := map[string]string{}
+	for {
+		out, err := a.sd.GetInstancesHealthStatusWithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+
+		for instID, status := range out.Status {
+			statuses[instID] = aws.StringValue(status)
+		}
+
+		if aws.StringValue(out.NextToken) == "" {
+			return statuses, nil
+		}
+		input.NextToken = out.NextToken
+	}
+}
+
+// applyHealthStatus removes unhealthy instances or adds their health status
+// to their metadata, according to the health status mode. Instances are
+// identified by their name, which must be their ID.
+//
+// Instances whose health status is not known, i.e. because their service
+// has no health check, are treated as healthy. An error is returned if the
+// health status could not be read at all.
+func (a *awsCloudMap) applyHealthStatus(ctx context.Context, servID string, insts []*openapi.Service) ([]*openapi.Service, error) {
+	if a.opts.healthStatus == "" || a.opts.healthStatus == healthStatusIgnore || len(insts) == 0 {
+		return insts, nil
+	}
+
+	statuses, err := a.getHealthStatus(ctx, servID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get health status of instances: %w", err)
+	}
+
+	res := []*openapi.Service{}
+	for _, inst := range insts {
+		status, exists := statuses[inst.Name]
+		if !exists {
+			status = servicediscovery.HealthStatusUnknown
+		}
+
+		switch a.opts.healthStatus {
+		case healthStatusExclude:
+			if status == servicediscovery.HealthStatusUnhealthy {
+				log.Debug().Str("service-id", servID).Str("instance-id", inst.Name).Msg("instance is unhealthy: skipping...")
+				continue
+			}
+		case healthStatusMetadata:
+			inst.Metadata = append(inst.Metadata, openapi.Metadata{Key: healthStatusMetadataKey, Value: status})
+		}
+
+		res = append(res, inst)
+	}
+
+	return res, nil
+}
+
+// parseInstance converts the instance to an openapi service. tags contains
+// the target metadata of its service and is used according to the metadata
+// source.
+func (a *awsCloudMap) parseInstance(servID string, inst *servicediscovery.InstanceSummary, tags map[string]string) (*openapi.Service, error) {
 	if inst.Id == nil || (inst.Id != nil && len(*inst.Id) == 0) {
 		return nil, fmt.Errorf("found instance with no/empty ID")
 	}
@@ -224,15 +567,8 @@ func (a *awsCloudMap) parseInstance(servID string, inst *servicediscovery.Instan
 		return nil, fmt.Errorf("instance doesn't have any attribute")
 	}
 
-	found := 0
-	metadata := map[string]string{}
-	for _, key := range a.opts.keys {
-		if val, exists := inst.Attributes[key]; exists && val != nil && len(*val) > 0 {
-			found++
-			metadata[key] = *val
-		}
-	}
-	if found != len(a.opts.keys) {
+	metadata := a.resolveMetadata(inst.Attributes, tags)
+	if !hasKeys(metadata, a.opts.keys) {
 		return nil, fmt.Errorf("instance doesn't have required metadata keys")
 	}
 
@@ -257,16 +593,10 @@ func (a *awsCloudMap) parseInstance(servID string, inst *servicediscovery.Instan
 	}
 
 	srv := &openapi.Service{
-		Name:    *inst.Id,
-		Address: address,
-		Port:    port,
-		Metadata: func() []openapi.Metadata {
-			met := []openapi.Metadata{}
-			for key, val := range metadata {
-				met = append(met, openapi.Metadata{Key: key, Value: val})
-			}
-			return met
-		}(),
+		Name:     *inst.Id,
+		Address:  address,
+		Port:     port,
+		Metadata: metadata,
 	}
 
 	return srv, nil
@@ -24,13 +24,14 @@ import (
 	"testing"
 
 	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/services"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/servicediscovery"
 	"github.com/stretchr/testify/assert"
 )
 
-func TestGetServicesIDs(t *testing.T) {
+func TestListServices(t *testing.T) {
 	a := assert.New(t)
 
 	cases := []struct {
@@ -79,6 +80,21 @@ func TestGetServicesIDs(t *testing.T) {
 			},
 			expRes: []string{"whatever", "whatever1"},
 		},
+		{
+			listServs: func(ctx aws.Context, input *servicediscovery.ListServicesInput, opts ...request.Option) (*servicediscovery.ListServicesOutput, error) {
+				if aws.StringValue(input.NextToken) == "" {
+					return &servicediscovery.ListServicesOutput{
+						Services:  []*servicediscovery.ServiceSummary{{Id: aws.String("first-page")}},
+						NextToken: aws.String("next"),
+					}, nil
+				}
+
+				return &servicediscovery.ListServicesOutput{
+					Services: []*servicediscovery.ServiceSummary{{Id: aws.String("second-page")}},
+				}, nil
+			},
+			expRes: []string{"first-page", "second-page"},
+		},
 	}
 
 	failed := func(i int) {
@@ -89,8 +105,16 @@ func TestGetServicesIDs(t *testing.T) {
 			sd: &fakeSD{
 				_listServices: currCase.listServs,
 			},
+			opts: &options{},
+		}
+		servs, err := cm.listServices(context.Background())
+		var res []string
+		if servs != nil {
+			res = []string{}
+			for _, serv := range servs {
+				res = append(res, aws.StringValue(serv.Id))
+			}
 		}
-		res, err := cm.getServicesIDs(context.Background())
 		if !a.Equal(currCase.expRes, res) || !a.Equal(currCase.expErr, err) {
 			failed(i)
 		}
@@ -170,6 +194,38 @@ func TestGetInstances(t *testing.T) {
 				},
 			},
 		},
+		{
+			listInst: func(ctx aws.Context, input *servicediscovery.ListInstancesInput, opts ...request.Option) (*servicediscovery.ListInstancesOutput, error) {
+				if aws.StringValue(input.NextToken) == "" {
+					return &servicediscovery.ListInstancesOutput{
+						Instances: []*servicediscovery.InstanceSummary{
+							{Id: &instID1, Attributes: map[string]*string{"yes": &instID1, awsIPv4Attr: &ip4}},
+						},
+						NextToken: aws.String("next"),
+					}, nil
+				}
+
+				return &servicediscovery.ListInstancesOutput{
+					Instances: []*servicediscovery.InstanceSummary{
+						{Id: &instID2, Attributes: map[string]*string{"yes": &instID2, awsIPv4Attr: &ip4}},
+					},
+				}, nil
+			},
+			expRes: []*openapi.Service{
+				{
+					Name:     instID1,
+					Address:  ip4,
+					Port:     int32(80),
+					Metadata: []openapi.Metadata{{Key: "yes", Value: instID1}},
+				},
+				{
+					Name:     instID2,
+					Address:  ip4,
+					Port:     int32(80),
+					Metadata: []openapi.Metadata{{Key: "yes", Value: instID2}},
+				},
+			},
+		},
 	}
 
 	failed := func(i int) {
@@ -184,13 +240,123 @@ func TestGetInstances(t *testing.T) {
 				keys: []string{"yes"},
 			},
 		}
-		res, err := cm.getInstances(context.Background(), "whatever")
+		res, err := cm.getInstances(context.Background(), "whatever", nil)
 		if !a.Equal(currCase.expRes, res) || !a.Equal(currCase.expErr, err) {
 			failed(i)
 		}
 	}
 }
 
+func TestListServicesWithNamespaces(t *testing.T) {
+	a := assert.New(t)
+	listNs := func(ctx aws.Context, input *servicediscovery.ListNamespacesInput, opts ...request.Option) (*servicediscovery.ListNamespacesOutput, error) {
+		if aws.StringValue(input.NextToken) == "" {
+			return &servicediscovery.ListNamespacesOutput{
+				Namespaces: []*servicediscovery.NamespaceSummary{
+					{Id: aws.String("ns-1"), Name: aws.String("one")},
+					{Id: aws.String("ns-2"), Name: aws.String("two")},
+				},
+				NextToken: aws.String("next"),
+			}, nil
+		}
+
+		return &servicediscovery.ListNamespacesOutput{
+			Namespaces: []*servicediscovery.NamespaceSummary{
+				{Id: aws.String("ns-3"), Name: aws.String("three")},
+			},
+		}, nil
+	}
+
+	cases := []struct {
+		namespaces        []string
+		excludeNamespaces []string
+		listNs            func(ctx aws.Context, input *servicediscovery.ListNamespacesInput, opts ...request.Option) (*servicediscovery.ListNamespacesOutput, error)
+
+		expFilter []string
+		expErr    bool
+	}{
+		{},
+		{
+			namespaces: []string{"one", "ns-3"},
+			listNs:     listNs,
+			expFilter:  []string{"ns-1", "ns-3"},
+		},
+		{
+			excludeNamespaces: []string{"ns-1", "three"},
+			listNs:            listNs,
+			expFilter:         []string{"ns-2"},
+		},
+		{
+			namespaces:        []string{"one", "two"},
+			excludeNamespaces: []string{"two"},
+			listNs:            listNs,
+			expFilter:         []string{"ns-1"},
+		},
+		{
+			namespaces: []string{"not-existing"},
+			listNs:     listNs,
+			expFilter:  []string{},
+		},
+		{
+			namespaces: []string{"one"},
+			listNs: func(ctx aws.Context, input *servicediscovery.ListNamespacesInput, opts ...request.Option) (*servicediscovery.ListNamespacesOutput, error) {
+				return nil, fmt.Errorf("any error")
+			},
+			expErr: true,
+		},
+	}
+
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+	for i, currCase := range cases {
+		var filters [][]*servicediscovery.ServiceFilter
+		cm := &awsCloudMap{
+			sd: &fakeSD{
+				_listNamespaces: currCase.listNs,
+				_listServices: func(ctx aws.Context, input *servicediscovery.ListServicesInput, opts ...request.Option) (*servicediscovery.ListServicesOutput, error) {
+					filters = append(filters, input.Filters)
+					return &servicediscovery.ListServicesOutput{
+						Services: []*servicediscovery.ServiceSummary{{Id: aws.String("srv-" + fmt.Sprint(len(filters)))}},
+					}, nil
+				},
+			},
+			opts: &options{
+				namespaces:        currCase.namespaces,
+				excludeNamespaces: currCase.excludeNamespaces,
+			},
+		}
+
+		servs, err := cm.listServices(context.Background())
+		if !a.Equal(currCase.expErr, err != nil) {
+			failed(i)
+		}
+
+		switch {
+		case currCase.expErr:
+		case currCase.expFilter == nil:
+			if !a.Len(filters, 1) || !a.Empty(filters[0]) || !a.Len(servs, 1) {
+				failed(i)
+			}
+		default:
+			// Services are listed once for each namespace, as only one
+			// namespace ID at a time is supported.
+			nsIDs := []string{}
+			for _, f := range filters {
+				if !a.Len(f, 1) ||
+					!a.Equal(servicediscovery.ServiceFilterNameNamespaceId, aws.StringValue(f[0].Name)) ||
+					!a.Equal(servicediscovery.FilterConditionEq, aws.StringValue(f[0].Condition)) {
+					failed(i)
+				}
+				nsIDs = append(nsIDs, aws.StringValueSlice(f[0].Values)...)
+			}
+			if !a.Equal(currCase.expFilter, nsIDs) || !a.Len(servs, len(currCase.expFilter)) {
+				failed(i)
+			}
+		}
+	}
+}
+
 func TestParseInstance(t *testing.T) {
 	cm := &awsCloudMap{
 		opts: &options{
@@ -333,9 +499,414 @@ func TestParseInstance(t *testing.T) {
 		a.FailNow("case failed", fmt.Sprintf("case %d", i))
 	}
 	for i, currCase := range cases {
-		res, err := cm.parseInstance(srvID, currCase.inst)
+		res, err := cm.parseInstance(srvID, currCase.inst, nil)
 		if !a.Equal(currCase.expRes, res) || !a.Equal(currCase.expErr, err) {
 			failed(i)
 		}
 	}
 }
+
+func TestApplyHealthStatus(t *testing.T) {
+	a := assert.New(t)
+	insts := func() []*openapi.Service {
+		return []*openapi.Service{
+			{Name: "healthy", Metadata: []openapi.Metadata{{Key: "yes", Value: "1"}}},
+			{Name: "unhealthy", Metadata: []openapi.Metadata{{Key: "yes", Value: "2"}}},
+			{Name: "unknown", Metadata: []openapi.Metadata{{Key: "yes", Value: "3"}}},
+		}
+	}
+	getHealth := func(ctx aws.Context, input *servicediscovery.GetInstancesHealthStatusInput, opts ...request.Option) (*servicediscovery.GetInstancesHealthStatusOutput, error) {
+		if aws.StringValue(input.NextToken) == "" {
+			return &servicediscovery.GetInstancesHealthStatusOutput{
+				Status:    map[string]*string{"healthy": aws.String(servicediscovery.HealthStatusHealthy)},
+				NextToken: aws.String("next"),
+			}, nil
+		}
+
+		return &servicediscovery.GetInstancesHealthStatusOutput{
+			Status: map[string]*string{"unhealthy": aws.String(servicediscovery.HealthStatusUnhealthy)},
+		}, nil
+	}
+	names := func(servs []*openapi.Service) (res []string) {
+		for _, serv := range servs {
+			res = append(res, serv.Name)
+		}
+		return
+	}
+
+	cases := []struct {
+		mode      string
+		getHealth func(ctx aws.Context, input *servicediscovery.GetInstancesHealthStatusInput, opts ...request.Option) (*servicediscovery.GetInstancesHealthStatusOutput, error)
+
+		expNames  []string
+		expHealth []string
+		expErr    bool
+	}{
+		{
+			mode:     healthStatusIgnore,
+			expNames: []string{"healthy", "unhealthy", "unknown"},
+		},
+		{
+			mode:      healthStatusExclude,
+			getHealth: getHealth,
+			expNames:  []string{"healthy", "unknown"},
+		},
+		{
+			mode:      healthStatusMetadata,
+			getHealth: getHealth,
+			expNames:  []string{"healthy", "unhealthy", "unknown"},
+			expHealth: []string{"HEALTHY", "UNHEALTHY", "UNKNOWN"},
+		},
+		{
+			mode: healthStatusExclude,
+			getHealth: func(ctx aws.Context, input *servicediscovery.GetInstancesHealthStatusInput, opts ...request.Option) (*servicediscovery.GetInstancesHealthStatusOutput, error) {
+				return nil, fmt.Errorf("any error")
+			},
+			expErr: true,
+		},
+	}
+
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+	for i, currCase := range cases {
+		cm := &awsCloudMap{
+			sd:   &fakeSD{_getHealth: currCase.getHealth},
+			opts: &options{healthStatus: currCase.mode},
+		}
+
+		res, err := cm.applyHealthStatus(context.Background(), "whatever", insts())
+		if !a.Equal(currCase.expErr, err != nil) || !a.Equal(currCase.expNames, names(res)) {
+			failed(i)
+		}
+
+		for j, serv := range res {
+			expLen := 1
+			if currCase.expHealth != nil {
+				expLen = 2
+			}
+			if !a.Len(serv.Metadata, expLen) {
+				failed(i)
+			}
+			if currCase.expHealth != nil && !a.Equal(openapi.Metadata{Key: healthStatusMetadataKey, Value: currCase.expHealth[j]}, serv.Metadata[1]) {
+				failed(i)
+			}
+		}
+	}
+}
+
+func TestGetCurrentState(t *testing.T) {
+	a := assert.New(t)
+	ip4 := "10.10.10.10"
+	ip6 := "2001:db8:a0b:12f0::1"
+	listServs := func(ctx aws.Context, input *servicediscovery.ListServicesInput, opts ...request.Option) (*servicediscovery.ListServicesOutput, error) {
+		return &servicediscovery.ListServicesOutput{
+			Services: []*servicediscovery.ServiceSummary{
+				{Id: aws.String("srv-1"), Arn: aws.String("arn-1")},
+				{Id: aws.String("srv-2"), Arn: aws.String("arn-2")},
+			},
+		}, nil
+	}
+	listInst := func(ctx aws.Context, input *servicediscovery.ListInstancesInput, opts ...request.Option) (*servicediscovery.ListInstancesOutput, error) {
+		switch aws.StringValue(input.ServiceId) {
+		case "srv-1":
+			return &servicediscovery.ListInstancesOutput{
+				Instances: []*servicediscovery.InstanceSummary{
+					{Id: aws.String("inst-1"), Attributes: map[string]*string{"yes": aws.String("attr"), awsIPv6Attr: &ip6}},
+				},
+			}, nil
+		default:
+			return &servicediscovery.ListInstancesOutput{
+				Instances: []*servicediscovery.InstanceSummary{
+					{Id: aws.String("inst-2"), Attributes: map[string]*string{awsIPv4Attr: &ip4, awsPortAttr: aws.String("8080")}},
+				},
+			}, nil
+		}
+	}
+	listTags := func(ctx aws.Context, input *servicediscovery.ListTagsForResourceInput, opts ...request.Option) (*servicediscovery.ListTagsForResourceOutput, error) {
+		if aws.StringValue(input.ResourceARN) == "arn-1" {
+			return &servicediscovery.ListTagsForResourceOutput{}, nil
+		}
+
+		return &servicediscovery.ListTagsForResourceOutput{
+			Tags: []*servicediscovery.Tag{
+				{Key: aws.String("yes"), Value: aws.String("tag")},
+				{Key: aws.String("no"), Value: aws.String("ignored")},
+			},
+		}, nil
+	}
+
+	cases := []struct {
+		metadataSource string
+		expRes         map[string]*openapi.Service
+	}{
+		{
+			metadataSource: metadataFromAttributes,
+			expRes: map[string]*openapi.Service{
+				"services/srv-1/endpoints/inst-1": {
+					Name:     "inst-1",
+					Address:  ip6,
+					Port:     awsDefaultInstancePort,
+					Metadata: []openapi.Metadata{{Key: "yes", Value: "attr"}},
+				},
+			},
+		},
+		{
+			metadataSource: metadataFromTags,
+			expRes: map[string]*openapi.Service{
+				"services/srv-2/endpoints/inst-2": {
+					Name:     "inst-2",
+					Address:  ip4,
+					Port:     8080,
+					Metadata: []openapi.Metadata{{Key: "yes", Value: "tag"}},
+				},
+			},
+		},
+		{
+			metadataSource: metadataFromBoth,
+			expRes: map[string]*openapi.Service{
+				"services/srv-1/endpoints/inst-1": {
+					Name:     "inst-1",
+					Address:  ip6,
+					Port:     awsDefaultInstancePort,
+					Metadata: []openapi.Metadata{{Key: "yes", Value: "attr"}},
+				},
+				"services/srv-2/endpoints/inst-2": {
+					Name:     "inst-2",
+					Address:  ip4,
+					Port:     8080,
+					Metadata: []openapi.Metadata{{Key: "yes", Value: "tag"}},
+				},
+			},
+		},
+	}
+
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+	for i, currCase := range cases {
+		cm := &awsCloudMap{
+			sd: &fakeSD{
+				_listServices:  listServs,
+				_listInstances: listInst,
+				_listTags:      listTags,
+			},
+			opts: &options{
+				keys:           []string{"yes"},
+				metadataSource: currCase.metadataSource,
+			},
+		}
+
+		res, err := cm.getCurrentState(context.Background())
+		if !a.NoError(err) || !a.Equal(currCase.expRes, res) {
+			failed(i)
+		}
+	}
+
+	// A service whose health status cannot be read keeps its previous
+	// instances, instead of having them reported as healthy or deleted.
+	healthErr := false
+	cm := &awsCloudMap{
+		sd: &fakeSD{
+			_listServices:  listServs,
+			_listInstances: listInst,
+			_getHealth: func(ctx aws.Context, input *servicediscovery.GetInstancesHealthStatusInput, opts ...request.Option) (*servicediscovery.GetInstancesHealthStatusOutput, error) {
+				if healthErr {
+					return nil, fmt.Errorf("throttled")
+				}
+				return &servicediscovery.GetInstancesHealthStatusOutput{}, nil
+			},
+		},
+		opts: &options{keys: []string{"yes"}, metadataSource: metadataFromAttributes, healthStatus: healthStatusExclude},
+	}
+	prev, err := cm.getCurrentState(context.Background())
+	a.NoError(err)
+	a.Len(prev, 1)
+
+	healthErr = true
+	res, err := cm.getCurrentState(context.Background())
+	a.NoError(err)
+	a.Equal(prev, res)
+}
+
+func TestCloudMapsGetCurrentState(t *testing.T) {
+	a := assert.New(t)
+	ip4 := "10.10.10.10"
+	opts := &options{keys: []string{"yes"}, metadataSource: metadataFromAttributes}
+	newSD := func(err error) *fakeSD {
+		return &fakeSD{
+			_listServices: func(ctx aws.Context, input *servicediscovery.ListServicesInput, opts ...request.Option) (*servicediscovery.ListServicesOutput, error) {
+				if err != nil {
+					return nil, err
+				}
+
+				return &servicediscovery.ListServicesOutput{
+					Services: []*servicediscovery.ServiceSummary{{Id: aws.String("srv-1")}},
+				}, nil
+			},
+			_listInstances: func(ctx aws.Context, input *servicediscovery.ListInstancesInput, opts ...request.Option) (*servicediscovery.ListInstancesOutput, error) {
+				return &servicediscovery.ListInstancesOutput{
+					Instances: []*servicediscovery.InstanceSummary{
+						{Id: aws.String("inst-1"), Attributes: map[string]*string{"yes": aws.String("attr"), awsIPv4Attr: &ip4}},
+					},
+				}, nil
+			},
+		}
+	}
+
+	// A single region is not included in keys and metadata
+	cms := cloudMaps{{opts: opts, sd: newSD(nil)}}
+	res, err := cms.getCurrentState(context.Background())
+	a.NoError(err)
+	a.Equal(map[string]*openapi.Service{
+		"services/srv-1/endpoints/inst-1": {
+			Name:     "inst-1",
+			Address:  ip4,
+			Port:     awsDefaultInstancePort,
+			Metadata: []openapi.Metadata{{Key: "yes", Value: "attr"}},
+		},
+	}, res)
+
+	// Same service and instance in different regions and accounts
+	cms = cloudMaps{
+		{opts: opts, sd: newSD(nil), region: "us-west-2"},
+		{opts: opts, sd: newSD(nil), region: "eu-west-1"},
+		{opts: opts, sd: newSD(nil), region: "eu-west-1", account: "123456789012"},
+	}
+	res, err = cms.getCurrentState(context.Background())
+	a.NoError(err)
+	a.Equal(map[string]*openapi.Service{
+		"regions/us-west-2/services/srv-1/endpoints/inst-1": {
+			Name:     "inst-1",
+			Address:  ip4,
+			Port:     awsDefaultInstancePort,
+			Metadata: []openapi.Metadata{{Key: "yes", Value: "attr"}, {Key: regionMetadataKey, Value: "us-west-2"}},
+		},
+		"regions/eu-west-1/services/srv-1/endpoints/inst-1": {
+			Name:     "inst-1",
+			Address:  ip4,
+			Port:     awsDefaultInstancePort,
+			Metadata: []openapi.Metadata{{Key: "yes", Value: "attr"}, {Key: regionMetadataKey, Value: "eu-west-1"}},
+		},
+		"accounts/123456789012/regions/eu-west-1/services/srv-1/endpoints/inst-1": {
+			Name:    "inst-1",
+			Address: ip4,
+			Port:    awsDefaultInstancePort,
+			Metadata: []openapi.Metadata{
+				{Key: "yes", Value: "attr"},
+				{Key: accountMetadataKey, Value: "123456789012"},
+				{Key: regionMetadataKey, Value: "eu-west-1"},
+			},
+		},
+	}, res)
+
+	// A region that cannot be read makes the whole state invalid
+	cms = cloudMaps{
+		{opts: opts, sd: newSD(nil), region: "us-west-2"},
+		{opts: opts, sd: newSD(fmt.Errorf("error")), region: "eu-west-1"},
+	}
+	res, err = cms.getCurrentState(context.Background())
+	a.Nil(res)
+	a.Equal(fmt.Errorf("error while reading regions/eu-west-1: %w", fmt.Errorf("error")), err)
+}
+
+func TestResolveMetadata(t *testing.T) {
+	a := assert.New(t)
+	attrs := map[string]*string{
+		"one":               aws.String("attr-one"),
+		"two":               aws.String("attr-two"),
+		"empty":             aws.String(""),
+		"site":              aws.String("eu-west"),
+		awsIPv4Attr:         aws.String("10.10.10.10"),
+		"AWS_INSTANCE_PORT": aws.String("80"),
+	}
+	tags := map[string]string{
+		"two":   "tag-two",
+		"three": "tag-three",
+		"empty": "tag-empty",
+		"env":   "prod",
+	}
+	keys := []string{"one", "two", "three", "empty", "four"}
+
+	cases := []struct {
+		source     string
+		precedence string
+		extra      *services.ExtraMetadata
+		expRes     []openapi.Metadata
+	}{
+		{
+			source: metadataFromAttributes,
+			expRes: []openapi.Metadata{
+				{Key: "one", Value: "attr-one"},
+				{Key: "two", Value: "attr-two"},
+			},
+		},
+		{
+			source: metadataFromTags,
+			expRes: []openapi.Metadata{
+				{Key: "two", Value: "tag-two"},
+				{Key: "three", Value: "tag-three"},
+				{Key: "empty", Value: "tag-empty"},
+			},
+		},
+		{
+			source:     metadataFromBoth,
+			precedence: precedenceInstance,
+			expRes: []openapi.Metadata{
+				{Key: "one", Value: "attr-one"},
+				{Key: "two", Value: "attr-two"},
+				{Key: "three", Value: "tag-three"},
+				{Key: "empty", Value: "tag-empty"},
+			},
+		},
+		{
+			source:     metadataFromBoth,
+			precedence: precedenceService,
+			expRes: []openapi.Metadata{
+				{Key: "one", Value: "attr-one"},
+				{Key: "two", Value: "tag-two"},
+				{Key: "three", Value: "tag-three"},
+				{Key: "empty", Value: "tag-empty"},
+			},
+		},
+		{
+			source: metadataFromBoth,
+			extra:  &services.ExtraMetadata{All: true, Include: []string{awsIPv4Attr}, Exclude: []string{"site"}},
+			expRes: []openapi.Metadata{
+				{Key: "one", Value: "attr-one"},
+				{Key: "two", Value: "attr-two"},
+				{Key: "three", Value: "tag-three"},
+				{Key: "empty", Value: "tag-empty"},
+				{Key: awsIPv4Attr, Value: "10.10.10.10"},
+				{Key: "env", Value: "prod"},
+			},
+		},
+		{
+			source: metadataFromAttributes,
+			extra:  &services.ExtraMetadata{Include: []string{"site", "env"}},
+			expRes: []openapi.Metadata{
+				{Key: "one", Value: "attr-one"},
+				{Key: "two", Value: "attr-two"},
+				{Key: "site", Value: "eu-west"},
+			},
+		},
+	}
+
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+	for i, currCase := range cases {
+		cm := &awsCloudMap{
+			opts: &options{
+				keys:               keys,
+				metadataSource:     currCase.source,
+				metadataPrecedence: currCase.precedence,
+				extraMetadata:      currCase.extra,
+			},
+		}
+
+		if !a.Equal(currCase.expRes, cm.resolveMetadata(attrs, tags)) {
+			failed(i)
+		}
+	}
+}
@@ -22,16 +22,13 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/signal"
+	"sync"
 
 	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/configuration"
-	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/pipeline"
 	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/poller"
 	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/queue"
 	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/services"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/servicediscovery"
 	"github.com/rs/zerolog"
 	"github.com/spf13/cobra"
 )
@@ -50,133 +47,254 @@ func init() {
 // TODO: on next version this will probably be changed and adopt some
 // other programming pattern, maybe with a factory.
 func GetCloudMapCommand() *cobra.Command {
-	var cm *awsCloudMap
-	var withTags bool
+	var (
+		opts *options
+		src  *source
+	)
 
 	cmd := &cobra.Command{
 		Use:     cmdUse,
 		Short:   cmdShort,
 		Long:    cmdLong,
 		Example: cmdExample,
+		Annotations: map[string]string{
+			configuration.SectionAnnotation: sectionPath,
+		},
 		PreRun: func(cmd *cobra.Command, _ []string) {
-			opts, err := parseFlags(cmd, configuration.GetConfigFile())
+			_opts, err := parseFlags(cmd, configuration.GetConfigFile())
 			if err != nil {
 				log.Fatal().Err(err).Msg("fatal error encountered")
 				return
 			}
 
-			if len(opts.credsPath) > 0 {
-				os.Setenv("AWS_SHARED_CREDENTIALS_FILE", opts.credsPath)
-			}
-
-			if opts.debug {
-				log = log.Level(zerolog.DebugLevel)
-			}
-
-			sess, err := session.NewSession()
+			setup(_opts)
+			_src, err := newSource(cmd, _opts)
 			if err != nil {
-				log.Fatal().Err(err).Msg("could not start AWS session")
+				log.Fatal().Err(err).Msg("fatal error encountered")
 				return
 			}
-			sd := servicediscovery.New(sess, aws.NewConfig().WithRegion(opts.region))
 
-			cm = &awsCloudMap{
-				opts: opts,
-				sd:   sd,
-			}
+			opts, src = _opts, _src
 		},
 		Run: func(cmd *cobra.Command, args []string) {
-			run(cm, withTags)
+			run(cmd, opts, src)
 		},
 	}
 
 	// Flags
 	cmd.Flags().String("region", "", "region to use")
+	cmd.Flags().StringSlice("regions", []string{}, "regions to use, if more than one, instead of --region")
 	cmd.Flags().String("credentials-path", "", "the path to the credentials file")
+	cmd.Flags().String("profile", "", "the name of the profile to use from the credentials and config files")
+	cmd.Flags().String("role-arn", "", "the ARN of the role to assume")
+	cmd.Flags().String("external-id", "", "the external id to use when assuming the role, if required")
+	cmd.Flags().String("role-session-name", "", "the session name to use when assuming the role")
+	cmd.Flags().String("web-identity-token-file", "", "the path of the web identity token file to use to assume the role, i.e. on EKS")
+	cmd.Flags().String("endpoint-url", "", "the URL of Cloud Map, to use a different endpoint than the default one")
 	cmd.Flags().StringSlice("metadata-keys", []string{}, "the metadata keys to watch for")
-	cmd.Flags().BoolVar(&withTags, "with-tags", false, "whether to look for AWS tags rather than attributes")
+	cmd.Flags().StringSlice("namespaces", []string{}, "IDs or names of the only namespaces to watch")
+	cmd.Flags().StringSlice("exclude-namespaces", []string{}, "IDs or names of the namespaces to ignore")
+	cmd.Flags().String("health-status", healthStatusIgnore, "how to handle the health status of instances: ignore, exclude unhealthy instances or add it to their metadata")
+	cmd.Flags().String("metadata-source", metadataFromAttributes, "where to read metadata from: attributes of instances, tags of services or both")
+	cmd.Flags().String("metadata-precedence", precedenceInstance, "which one wins when reading metadata from both and a key is in both: instance or service")
+	cmd.Flags().Bool("with-tags", false, "whether to look for AWS tags rather than attributes")
+	cmd.Flags().MarkDeprecated("with-tags", "please use --metadata-source=tags instead")
+
+	// Fields of the configuration overridden by flags
+	for flag, path := range map[string]string{
+		"region":                  ".region",
+		"regions":                 ".regions",
+		"credentials-path":        ".credentialsPath",
+		"profile":                 ".profile",
+		"role-arn":                ".roleARN",
+		"external-id":             ".externalID",
+		"role-session-name":       ".roleSessionName",
+		"web-identity-token-file": ".webIdentityTokenFile",
+		"endpoint-url":            ".endpointURL",
+		"metadata-keys":           "metadataKeys",
+		"namespaces":              ".namespaces",
+		"exclude-namespaces":      ".excludeNamespaces",
+		"health-status":           ".healthStatus",
+		"metadata-source":         ".metadataSource",
+		"metadata-precedence":     ".metadataPrecedence",
+	} {
+		configuration.BindFlag(cmd.Flags(), flag, path)
+	}
 
 	return cmd
 }
 
-func run(cm *awsCloudMap, withTags bool) {
-	log.Info().Str("service-registry", "Cloud Map").Str("adaptor", cm.opts.adaptor).Msg("starting...")
-	if withTags {
-		log.Info().Msg("switching to tag parsing...")
+// NewSource returns a source that polls Cloud Map with the settings from
+// the provided command and configuration, to be run along with other
+// sources.
+func NewSource(cmd *cobra.Command, conf *configuration.Config) (pipeline.Source, error) {
+	opts, err := parseFlags(cmd, conf)
+	if err != nil {
+		return nil, err
 	}
 
-	ctx, canc := context.WithCancel(context.Background())
+	setup(opts)
+	return newSource(cmd, opts)
+}
 
-	datastore := services.NewDatastore()
-	servsHandler, err := services.NewHandler(ctx, cm.opts.adaptor)
-	if err != nil {
-		log.Fatal().Err(err).Msg("error while trying to connect to aws cloud map")
+// setup applies the settings that affect the whole process, i.e. the
+// credentials file and the log level. It is only called on startup, as
+// sources are already running when the configuration is reloaded.
+func setup(opts *options) {
+	if len(opts.credsPath) > 0 {
+		os.Setenv("AWS_SHARED_CREDENTIALS_FILE", opts.credsPath)
 	}
-	sendQueue := queue.New(ctx, servsHandler)
-
-	go func() {
-		log.Info().Msg("getting initial state...")
-		var (
-			oaSrvs map[string]*openapi.Service
-			err    error
-		)
-
-		if !withTags {
-			oaSrvs, err = cm.getCurrentState(ctx)
-		} else {
-			oaSrvs, err = cm.getServiceTags(ctx)
-		}
 
+	if opts.debug {
+		log = log.Level(zerolog.DebugLevel)
+	}
+}
+
+func newSource(cmd *cobra.Command, opts *options) (*source, error) {
+	src := &source{cmd: cmd, opts: opts}
+	targets := opts.getTargets()
+	for _, t := range targets {
+		sd, err := newServiceDiscovery(t.region, t.auth)
 		if err != nil {
-			log.Fatal().Err(err).Msg("error while getting initial state of cloud map")
-			return
+			return nil, fmt.Errorf("could not start AWS session for region %s: %w", t.region, err)
 		}
 
-		log.Info().Msg("done")
-		if filtered := datastore.GetEvents(oaSrvs); len(filtered) > 0 {
-			go sendQueue.Enqueue(filtered)
+		cm := &awsCloudMap{
+			opts: opts,
+			sd:   sd,
+		}
+		if len(targets) > 1 {
+			cm.region, cm.account = t.region, t.account
 		}
+		src.cms = append(src.cms, cm)
+	}
 
-		// Get the poller
-		log.Info().Msg("observing changes...")
-		poll := poller.New(ctx, cm.opts.interval)
-		poll.SetPollFunction(func() {
-			var (
-				oaSrvs map[string]*openapi.Service
-				err    error
-			)
-
-			if !withTags {
-				oaSrvs, err = cm.getCurrentState(ctx)
-			} else {
-				oaSrvs, err = cm.getServiceTags(ctx)
-			}
+	return src, nil
+}
 
-			if err != nil {
+// source polls Cloud Map and enqueues the changes it detects.
+type source struct {
+	cmd  *cobra.Command
+	opts *options
+	cms  cloudMaps
+
+	lock sync.Mutex
+	// next is the source with the new configuration, which replaces this
+	// one on next poll.
+	next *source
+}
+
+// Name returns the name of the source.
+func (s *source) Name() string {
+	return sourceName
+}
+
+// Reload parses the new configuration, which is applied on next poll.
+func (s *source) Reload(conf *configuration.Config) error {
+	opts, err := parseFlags(s.cmd, conf)
+	if err != nil {
+		return err
+	}
+
+	next, err := newSource(s.cmd, opts)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	s.next = next
+	s.lock.Unlock()
+	return nil
+}
+
+// applyNext replaces the current configuration with the new one, if any,
+// and returns true in that case.
+func (s *source) applyNext() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.next == nil {
+		return false
+	}
+
+	s.opts, s.cms, s.next = s.next.opts, s.next.cms, nil
+	return true
+}
+
+// Run polls Cloud Map until ctx is done.
+func (s *source) Run(ctx context.Context, q queue.Queue, resync <-chan struct{}) error {
+	log.Info().Str("service-registry", "Cloud Map").Strs("regions", s.opts.regions).Msg("starting...")
+	if s.opts.metadataSource != metadataFromAttributes {
+		log.Info().Str("metadata-source", s.opts.metadataSource).Msg("switching metadata source...")
+	}
+	datastore := services.NewDatastore()
+	datastore.SetTrackedKeys(s.opts.trackedKeys())
+
+	log.Info().Msg("getting initial state...")
+	oaSrvs, err := s.cms.getCurrentState(ctx)
+	if err != nil {
+		return fmt.Errorf("error while getting initial state of cloud map: %w", err)
+	}
+
+	log.Info().Msg("done")
+	if filtered := datastore.GetEvents(oaSrvs); len(filtered) > 0 {
+		services.StampEvents(filtered, sourceName)
+		q.Enqueue(filtered)
+	}
+
+	// Get the poller
+	log.Info().Msg("observing changes...")
+	poll := poller.New(ctx, s.opts.interval)
+	poll.SetPollFunction(func() {
+		if s.applyNext() {
+			// Services that enter or leave the filters are detected as
+			// any other change.
+			log.Info().Msg("applying new configuration...")
+			poll.SetInterval(s.opts.interval)
+			datastore.SetTrackedKeys(s.opts.trackedKeys())
+		}
+
+		oaSrvs, err := s.cms.getCurrentState(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
 				log.Err(err).Msg("error while polling, skipping...")
-				return
 			}
+			return
+		}
 
-			if filtered := datastore.GetEvents(oaSrvs); len(filtered) > 0 {
-				log.Info().Msg("changes detected")
-				go sendQueue.Enqueue(filtered)
-			}
-		})
+		filtered := datastore.GetEvents(oaSrvs)
+		select {
+		case <-resync:
+			// Events may have been missed while this was a follower
+			filtered = services.ResyncEvents(oaSrvs, filtered)
+		default:
+		}
+
+		if len(filtered) > 0 {
+			log.Info().Msg("changes detected")
+			services.StampEvents(filtered, sourceName)
+			q.Enqueue(filtered)
+		}
+	})
 
-		poll.Start()
-	}()
+	poll.Start()
+	<-poll.Done()
+	return nil
+}
 
-	// Graceful shutdown
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, os.Interrupt)
+func run(cmd *cobra.Command, opts *options, src *source) {
+	log.Info().Str("adaptor", opts.adaptor).Msg("starting...")
 
-	<-sig
-	fmt.Println()
-	log.Info().Msg("exit requested")
+	ctx, canc := context.WithCancel(context.Background())
+	pipelineOpts := opts.pipelineOptions()
+	pipelineOpts.Reload = pipeline.WatchConfigFile(ctx, cmd)
 
-	// Cancel the context and wait for objects that use it to receive
-	// the stop command
+	exitCode, err := pipeline.Run([]pipeline.Source{src}, pipelineOpts)
 	canc()
+	if err != nil {
+		log.Fatal().Err(err).Msg("error while trying to connect to aws cloud map")
+	}
 
 	log.Info().Msg("good bye!")
+	os.Exit(exitCode)
 }
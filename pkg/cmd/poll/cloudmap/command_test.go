@@ -0,0 +1,56 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package cloudmap
+
+import (
+	"testing"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/configuration"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSourceReload(t *testing.T) {
+	a := assert.New(t)
+	cmd := GetCloudMapCommand()
+	cmd.SetArgs([]string{"--metadata-keys=this"})
+	cmd.PreRun = func(*cobra.Command, []string) {}
+	cmd.Run = func(*cobra.Command, []string) {}
+	cmd.Execute()
+
+	s := &source{cmd: cmd, opts: &options{regions: []string{"us-west-2"}, interval: 5}}
+	a.False(s.applyNext())
+
+	// Invalid configurations are not applied
+	err := s.Reload(&configuration.Config{ServiceRegistry: &configuration.ServiceRegistrySettings{
+		AWSCloudMap: &configuration.CloudMapConfig{},
+	}})
+	a.Error(err)
+	a.False(s.applyNext())
+
+	err = s.Reload(&configuration.Config{ServiceRegistry: &configuration.ServiceRegistrySettings{
+		AWSCloudMap: &configuration.CloudMapConfig{Regions: []string{"us-east-1", "eu-west-1"}, PollInterval: 10},
+	}})
+	a.NoError(err)
+	a.True(s.applyNext())
+	a.Equal([]string{"us-east-1", "eu-west-1"}, s.opts.regions)
+	a.Equal(10, s.opts.interval)
+	a.Len(s.cms, 2)
+	a.False(s.applyNext())
+}
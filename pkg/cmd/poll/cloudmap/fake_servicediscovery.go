@@ -19,6 +19,8 @@
 package cloudmap
 
 import (
+	"fmt"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/servicediscovery"
@@ -28,14 +30,36 @@ import (
 type fakeSD struct {
 	servicediscoveryiface.ServiceDiscoveryAPI
 
-	_listServices  func(ctx aws.Context, input *servicediscovery.ListServicesInput, opts ...request.Option) (*servicediscovery.ListServicesOutput, error)
-	_listInstances func(aws.Context, *servicediscovery.ListInstancesInput, ...request.Option) (*servicediscovery.ListInstancesOutput, error)
+	_listServices   func(ctx aws.Context, input *servicediscovery.ListServicesInput, opts ...request.Option) (*servicediscovery.ListServicesOutput, error)
+	_listInstances  func(aws.Context, *servicediscovery.ListInstancesInput, ...request.Option) (*servicediscovery.ListInstancesOutput, error)
+	_listNamespaces func(aws.Context, *servicediscovery.ListNamespacesInput, ...request.Option) (*servicediscovery.ListNamespacesOutput, error)
+	_listTags       func(aws.Context, *servicediscovery.ListTagsForResourceInput, ...request.Option) (*servicediscovery.ListTagsForResourceOutput, error)
+	_getHealth      func(aws.Context, *servicediscovery.GetInstancesHealthStatusInput, ...request.Option) (*servicediscovery.GetInstancesHealthStatusOutput, error)
 }
 
 func (f *fakeSD) ListServicesWithContext(ctx aws.Context, input *servicediscovery.ListServicesInput, opts ...request.Option) (*servicediscovery.ListServicesOutput, error) {
+	// Like Cloud Map, only accept one namespace ID with EQ
+	for _, filter := range input.Filters {
+		if aws.StringValue(filter.Condition) != servicediscovery.FilterConditionEq || len(filter.Values) != 1 {
+			return nil, fmt.Errorf("InvalidInput: unsupported filter condition or values for %s", aws.StringValue(filter.Name))
+		}
+	}
+
 	return f._listServices(ctx, input, opts...)
 }
 
 func (f *fakeSD) ListInstancesWithContext(ctx aws.Context, input *servicediscovery.ListInstancesInput, opts ...request.Option) (*servicediscovery.ListInstancesOutput, error) {
 	return f._listInstances(ctx, input, opts...)
 }
+
+func (f *fakeSD) ListNamespacesWithContext(ctx aws.Context, input *servicediscovery.ListNamespacesInput, opts ...request.Option) (*servicediscovery.ListNamespacesOutput, error) {
+	return f._listNamespaces(ctx, input, opts...)
+}
+
+func (f *fakeSD) GetInstancesHealthStatusWithContext(ctx aws.Context, input *servicediscovery.GetInstancesHealthStatusInput, opts ...request.Option) (*servicediscovery.GetInstancesHealthStatusOutput, error) {
+	return f._getHealth(ctx, input, opts...)
+}
+
+func (f *fakeSD) ListTagsForResourceWithContext(ctx aws.Context, input *servicediscovery.ListTagsForResourceInput, opts ...request.Option) (*servicediscovery.ListTagsForResourceOutput, error) {
+	return f._listTags(ctx, input, opts...)
+}
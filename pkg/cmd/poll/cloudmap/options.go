@@ -18,11 +18,137 @@
 
 package cloudmap
 
+import (
+	"strings"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/election"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/filter"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/pipeline"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/queue"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/services"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/shutdown"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/transform"
+)
+
 type options struct {
-	region    string
-	credsPath string
-	interval  int
-	adaptor   string
-	debug     bool
-	keys      []string
+	// regions are the regions to poll.
+	regions []string
+	// accounts are the other accounts to poll, by assuming a role in
+	// each of them. If empty, only the account of the credentials is
+	// polled.
+	accounts      []*account
+	credsPath     string
+	auth          *awsAuth
+	interval      int
+	adaptor       string
+	eventsVersion string
+	filter        *filter.Filter
+	transformer   *transform.Transformer
+	queueOpts     *queue.Options
+	shutdownOpts  *shutdown.Options
+	electionOpts  *election.Options
+	debug         bool
+	keys          []string
+	// extraMetadata contains which metadata are included in events in
+	// addition to keys.
+	extraMetadata *services.ExtraMetadata
+	// namespaces and excludeNamespaces contain IDs or names of the
+	// namespaces to watch and to ignore, respectively.
+	namespaces        []string
+	excludeNamespaces []string
+	// healthStatus is how the health status of instances is handled, i.e.
+	// ignore, exclude or metadata.
+	healthStatus string
+	// metadataSource is where metadata is read from, i.e. attributes,
+	// tags or both.
+	metadataSource string
+	// metadataPrecedence is which of instance or service wins when
+	// metadata is read from both and a key is found in both.
+	metadataPrecedence string
+}
+
+// pipelineOptions returns the settings about how events are sent to the
+// adaptor.
+func (o *options) pipelineOptions() pipeline.Options {
+	return pipeline.Options{
+		Adaptor:       o.adaptor,
+		EventsVersion: o.eventsVersion,
+		Filter:        o.filter,
+		Transformer:   o.transformer,
+		Queue:         o.queueOpts,
+		Shutdown:      o.shutdownOpts,
+		Election:      o.electionOpts,
+	}
+}
+
+// trackedKeys returns the metadata keys whose changes are reported.
+func (o *options) trackedKeys() []string {
+	tracked := o.extraMetadata.TrackedKeys(o.keys)
+	if o.healthStatus == healthStatusMetadata {
+		tracked = append(tracked, healthStatusMetadataKey)
+	}
+
+	return tracked
+}
+
+// account is another account to poll, by assuming a role in it.
+type account struct {
+	roleARN    string
+	externalID string
+	// regions are the regions to poll in this account. If empty, the
+	// same regions of the options are polled.
+	regions []string
+}
+
+// target is a region of an account to poll.
+type target struct {
+	region string
+	// account is the ID of the account, if a role is assumed in it.
+	account string
+	auth    *awsAuth
+}
+
+// getTargets returns all the regions of all the accounts to poll.
+func (o *options) getTargets() []*target {
+	if len(o.accounts) == 0 {
+		targets := make([]*target, len(o.regions))
+		for i, region := range o.regions {
+			targets[i] = &target{region: region, auth: o.auth}
+		}
+
+		return targets
+	}
+
+	targets := []*target{}
+	for _, acc := range o.accounts {
+		auth := *o.auth
+		auth.roleARN, auth.externalID = acc.roleARN, acc.externalID
+
+		regions := acc.regions
+		if len(regions) == 0 {
+			regions = o.regions
+		}
+
+		for _, region := range regions {
+			targets = append(targets, &target{
+				region:  region,
+				account: accountID(acc.roleARN),
+				auth:    &auth,
+			})
+		}
+	}
+
+	return targets
+}
+
+// accountID returns the ID of the account of the provided role ARN, i.e.
+// 123456789012 for arn:aws:iam::123456789012:role/my-role, or the ARN
+// itself if it is not valid.
+func accountID(roleARN string) string {
+	parts := strings.Split(roleARN, ":")
+	if len(parts) < 6 || len(parts[4]) == 0 {
+		return roleARN
+	}
+
+	return parts[4]
 }
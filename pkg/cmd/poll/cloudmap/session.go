@@ -0,0 +1,106 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package cloudmap
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/servicediscovery"
+	"github.com/aws/aws-sdk-go/service/servicediscovery/servicediscoveryiface"
+)
+
+// awsAuth contains settings about how to authenticate to AWS. A zero value
+// uses the default credentials chain.
+type awsAuth struct {
+	// profile is the name of the profile to use from the shared
+	// configuration and credentials files.
+	profile string
+	// roleARN is the ARN of the role to assume.
+	roleARN string
+	// externalID is passed to STS when assuming roleARN, if the role
+	// requires it.
+	externalID string
+	// roleSessionName is the name of the session when assuming roleARN.
+	roleSessionName string
+	// webIdentityTokenFile is the path of the file containing the token
+	// used to assume roleARN with web identity, i.e. on EKS.
+	webIdentityTokenFile string
+	// endpointURL overrides the endpoint of Cloud Map.
+	endpointURL string
+}
+
+func (a *awsAuth) validate() error {
+	if len(a.roleARN) == 0 {
+		if len(a.externalID) > 0 {
+			return fmt.Errorf("external id provided but no role arn")
+		}
+		if len(a.roleSessionName) > 0 {
+			return fmt.Errorf("role session name provided but no role arn")
+		}
+		if len(a.webIdentityTokenFile) > 0 {
+			return fmt.Errorf("web identity token file provided but no role arn")
+		}
+	}
+
+	if len(a.webIdentityTokenFile) > 0 && len(a.externalID) > 0 {
+		return fmt.Errorf("external id cannot be used with web identity")
+	}
+
+	return nil
+}
+
+// newServiceDiscovery returns a Cloud Map client for the provided region
+// that authenticates according to auth.
+func newServiceDiscovery(region string, auth *awsAuth) (servicediscoveryiface.ServiceDiscoveryAPI, error) {
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Profile:           auth.profile,
+		SharedConfigState: session.SharedConfigEnable,
+		Config:            aws.Config{Region: aws.String(region)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	conf := aws.NewConfig().WithRegion(region)
+	if len(auth.endpointURL) > 0 {
+		conf = conf.WithEndpoint(auth.endpointURL)
+	}
+
+	sessionName := auth.roleSessionName
+	if len(sessionName) == 0 {
+		sessionName = defaultRoleSessionName
+	}
+
+	switch {
+	case len(auth.webIdentityTokenFile) > 0:
+		conf = conf.WithCredentials(stscreds.NewWebIdentityCredentials(sess, auth.roleARN, sessionName, auth.webIdentityTokenFile))
+	case len(auth.roleARN) > 0:
+		conf = conf.WithCredentials(stscreds.NewCredentials(sess, auth.roleARN, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = sessionName
+			if len(auth.externalID) > 0 {
+				p.ExternalID = aws.String(auth.externalID)
+			}
+		}))
+	}
+
+	return servicediscovery.New(sess, conf), nil
+}
@@ -0,0 +1,84 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package cloudmap
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/servicediscovery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateAWSAuth(t *testing.T) {
+	a := assert.New(t)
+	cases := []struct {
+		auth   *awsAuth
+		expErr error
+	}{
+		{
+			auth: &awsAuth{},
+		},
+		{
+			auth: &awsAuth{roleARN: "arn", externalID: "ext", roleSessionName: "name"},
+		},
+		{
+			auth: &awsAuth{roleARN: "arn", webIdentityTokenFile: "/path/to/token"},
+		},
+		{
+			auth:   &awsAuth{externalID: "ext"},
+			expErr: fmt.Errorf("external id provided but no role arn"),
+		},
+		{
+			auth:   &awsAuth{roleSessionName: "name"},
+			expErr: fmt.Errorf("role session name provided but no role arn"),
+		},
+		{
+			auth:   &awsAuth{webIdentityTokenFile: "/path/to/token"},
+			expErr: fmt.Errorf("web identity token file provided but no role arn"),
+		},
+		{
+			auth:   &awsAuth{roleARN: "arn", externalID: "ext", webIdentityTokenFile: "/path/to/token"},
+			expErr: fmt.Errorf("external id cannot be used with web identity"),
+		},
+	}
+
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+	for i, currCase := range cases {
+		err := currCase.auth.validate()
+		if !a.Equal(currCase.expErr, err) {
+			failed(i)
+		}
+	}
+}
+
+func TestNewServiceDiscovery(t *testing.T) {
+	a := assert.New(t)
+
+	sd, err := newServiceDiscovery("us-east-1", &awsAuth{endpointURL: "http://localhost:4566"})
+	a.NoError(err)
+	a.Equal("http://localhost:4566", sd.(*servicediscovery.ServiceDiscovery).Endpoint)
+	a.Equal("us-east-1", sd.(*servicediscovery.ServiceDiscovery).SigningRegion)
+
+	sd, err = newServiceDiscovery("us-east-1", &awsAuth{})
+	a.NoError(err)
+	a.Equal("https://servicediscovery.us-east-1.amazonaws.com", sd.(*servicediscovery.ServiceDiscovery).Endpoint)
+}
@@ -23,64 +23,268 @@ import (
 
 	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/configuration"
 	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/internal/utils"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/pipeline"
 	"github.com/spf13/cobra"
 )
 
 func parseFlags(cmd *cobra.Command, conf *configuration.Config) (*options, error) {
 	opts := &options{}
 
-	if conf == nil {
-		conf = &configuration.Config{
-			ServiceRegistry: &configuration.ServiceRegistrySettings{
-				AWSCloudMap: &configuration.CloudMapConfig{},
-			},
-		}
+	resolved, _ := ResolveConfig(cmd, conf)
+	cmConf := resolved.ServiceRegistry.AWSCloudMap
+
+	switch {
+	case len(cmConf.Regions) > 0:
+		opts.regions = cmConf.Regions
+	case len(cmConf.Region) > 0:
+		opts.regions = []string{cmConf.Region}
 	}
-	cmConf := conf.ServiceRegistry.AWSCloudMap
 
-	awsRegion, _ := cmd.Flags().GetString("region")
-	if len(awsRegion) == 0 {
-		if len(cmConf.Region) == 0 {
-			return nil, fmt.Errorf("region not provided")
+	for _, acc := range cmConf.Accounts {
+		if len(acc.RoleARN) == 0 {
+			return nil, fmt.Errorf("account with no role arn provided")
+		}
+		if len(acc.Regions) == 0 && len(opts.regions) == 0 {
+			return nil, fmt.Errorf("region not provided for account %s", acc.RoleARN)
 		}
 
-		awsRegion = cmConf.Region
+		opts.accounts = append(opts.accounts, &account{
+			roleARN:    acc.RoleARN,
+			externalID: acc.ExternalID,
+			regions:    acc.Regions,
+		})
 	}
-	opts.region = awsRegion
 
-	credsPath, _ := cmd.Flags().GetString("credentials-path")
-	if len(credsPath) == 0 {
-		if len(cmConf.CredentialsPath) > 0 {
-			credsPath = cmConf.CredentialsPath
-		}
+	if len(opts.regions) == 0 && len(opts.accounts) == 0 {
+		return nil, fmt.Errorf("region not provided")
 	}
-	opts.credsPath = credsPath
 
-	pollInterval := 5
-	if cmd.Flags().Changed("poll-interval") {
-		_pollInterval, _ := cmd.Flags().GetInt("poll-interval")
-		if _pollInterval > 0 {
-			pollInterval = _pollInterval
-		}
-	} else {
-		if cmConf.PollInterval > 0 {
-			pollInterval = cmConf.PollInterval
-		}
+	opts.credsPath = cmConf.CredentialsPath
+	opts.auth = &awsAuth{
+		profile:              cmConf.Profile,
+		roleARN:              cmConf.RoleARN,
+		externalID:           cmConf.ExternalID,
+		roleSessionName:      cmConf.RoleSessionName,
+		webIdentityTokenFile: cmConf.WebIdentityTokenFile,
+		endpointURL:          cmConf.EndpointURL,
+	}
+	if err := opts.auth.validate(); err != nil {
+		return nil, err
+	}
+
+	opts.interval = cmConf.PollInterval
+	opts.namespaces = cmConf.Namespaces
+	opts.excludeNamespaces = cmConf.ExcludeNamespaces
+
+	switch cmConf.MetadataSource {
+	case metadataFromAttributes, metadataFromTags, metadataFromBoth:
+		opts.metadataSource = cmConf.MetadataSource
+	default:
+		return nil, fmt.Errorf("invalid metadata source: %s", cmConf.MetadataSource)
+	}
+
+	switch cmConf.MetadataPrecedence {
+	case precedenceInstance, precedenceService:
+		opts.metadataPrecedence = cmConf.MetadataPrecedence
+	default:
+		return nil, fmt.Errorf("invalid metadata precedence: %s", cmConf.MetadataPrecedence)
+	}
+
+	switch cmConf.HealthStatus {
+	case healthStatusIgnore, healthStatusExclude, healthStatusMetadata:
+		opts.healthStatus = cmConf.HealthStatus
+	default:
+		return nil, fmt.Errorf("invalid health status mode: %s", cmConf.HealthStatus)
 	}
-	opts.interval = pollInterval
 
 	keys, err := utils.GetMetadataKeysFromCmdFlags(cmd)
 	if err != nil {
 		return nil, err
 	}
-	opts.keys = keys
+
+	// The keys are the ones services have after being transformed, while
+	// the service registry must look for their original names.
+	transformer, err := pipeline.GetTransformerFromConfig()
+	if err != nil {
+		return nil, err
+	}
+	opts.keys = transformer.SourceKeys(sourceName, keys)
+	opts.transformer = transformer
+
+	extraMetadata := utils.GetExtraMetadataFromFlags(cmd)
+	if extraMetadata != nil {
+		extraMetadata.Include = transformer.SourceKeys(sourceName, extraMetadata.Include)
+		extraMetadata.Exclude = transformer.SourceKeys(sourceName, extraMetadata.Exclude)
+	}
+	opts.extraMetadata = extraMetadata
 
 	adaptor, err := utils.GetAdaptorEndpointFromFlags(cmd)
 	if err != nil {
 		return nil, err
 	}
 	opts.adaptor = adaptor
+
+	eventsVersion, err := utils.GetEventsVersionFromFlags(cmd)
+	if err != nil {
+		return nil, err
+	}
+	opts.eventsVersion = eventsVersion
+
+	flt, err := pipeline.GetFilterFromFlags(cmd)
+	if err != nil {
+		return nil, err
+	}
+	opts.filter = flt
+
+	queueOpts, err := pipeline.GetQueueOptionsFromFlags(cmd)
+	if err != nil {
+		return nil, err
+	}
+	opts.queueOpts = queueOpts
+
+	shutdownOpts, err := pipeline.GetShutdownOptionsFromFlags(cmd)
+	if err != nil {
+		return nil, err
+	}
+	opts.shutdownOpts = shutdownOpts
+
+	electionOpts, err := pipeline.GetElectionOptionsFromFlags(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if electionOpts != nil && len(electionOpts.Endpoints) == 0 {
+		return nil, fmt.Errorf("no leader election endpoints provided")
+	}
+	opts.electionOpts = electionOpts
 	opts.debug = utils.GetDebugModeFromFlags(cmd)
 
 	return opts, nil
 }
+
+// ResolveConfig returns the configuration with only the Cloud Map section
+// used by the command, resolved from its flags and from conf, and where
+// the value of each of its fields comes from.
+func ResolveConfig(cmd *cobra.Command, conf *configuration.Config) (*configuration.Config, map[string]configuration.Origin) {
+	cmConf := &configuration.CloudMapConfig{}
+	if conf != nil && conf.ServiceRegistry != nil && conf.ServiceRegistry.AWSCloudMap != nil {
+		cmConf = conf.ServiceRegistry.AWSCloudMap
+	}
+	res := &configuration.CloudMapConfig{}
+	origins := configuration.NewSectionOrigins(sectionPath)
+
+	awsRegion, _ := cmd.Flags().GetString("region")
+	switch {
+	case cmd.Flags().Changed("regions"):
+		res.Regions, _ = cmd.Flags().GetStringSlice("regions")
+		origins.Flag("regions")
+	case len(awsRegion) > 0:
+		res.Region = awsRegion
+		origins.Flag("region")
+	case len(cmConf.Regions) > 0:
+		res.Regions = cmConf.Regions
+		origins.Conf("regions")
+	case len(cmConf.Region) > 0:
+		res.Region = cmConf.Region
+		origins.Conf("region")
+	}
+
+	res.Accounts = cmConf.Accounts
+	if len(res.Accounts) > 0 {
+		origins.Conf("accounts")
+	}
+
+	stringFlagOrConf := func(flag, path, fromConf string) string {
+		if cmd.Flags().Changed(flag) {
+			origins.Flag(path)
+			val, _ := cmd.Flags().GetString(flag)
+			return val
+		}
+
+		if len(fromConf) > 0 {
+			origins.Conf(path)
+		}
+		return fromConf
+	}
+	res.CredentialsPath = stringFlagOrConf("credentials-path", "credentialsPath", cmConf.CredentialsPath)
+	res.Profile = stringFlagOrConf("profile", "profile", cmConf.Profile)
+	res.RoleARN = stringFlagOrConf("role-arn", "roleARN", cmConf.RoleARN)
+	res.ExternalID = stringFlagOrConf("external-id", "externalID", cmConf.ExternalID)
+	res.RoleSessionName = stringFlagOrConf("role-session-name", "roleSessionName", cmConf.RoleSessionName)
+	res.WebIdentityTokenFile = stringFlagOrConf("web-identity-token-file", "webIdentityTokenFile", cmConf.WebIdentityTokenFile)
+	res.EndpointURL = stringFlagOrConf("endpoint-url", "endpointURL", cmConf.EndpointURL)
+
+	pollInterval, _ := cmd.Flags().GetInt("poll-interval")
+	switch {
+	case cmd.Flags().Changed("poll-interval") && pollInterval > 0:
+		res.PollInterval = pollInterval
+		origins.Flag("pollInterval")
+	case !cmd.Flags().Changed("poll-interval") && cmConf.PollInterval > 0:
+		res.PollInterval = cmConf.PollInterval
+		origins.Conf("pollInterval")
+	default:
+		res.PollInterval = 5
+		origins.Default("pollInterval")
+	}
+
+	res.Namespaces = cmConf.Namespaces
+	if cmd.Flags().Changed("namespaces") {
+		res.Namespaces, _ = cmd.Flags().GetStringSlice("namespaces")
+		origins.Flag("namespaces")
+	} else if len(res.Namespaces) > 0 {
+		origins.Conf("namespaces")
+	}
+
+	res.ExcludeNamespaces = cmConf.ExcludeNamespaces
+	if cmd.Flags().Changed("exclude-namespaces") {
+		res.ExcludeNamespaces, _ = cmd.Flags().GetStringSlice("exclude-namespaces")
+		origins.Flag("excludeNamespaces")
+	} else if len(res.ExcludeNamespaces) > 0 {
+		origins.Conf("excludeNamespaces")
+	}
+
+	switch {
+	case cmd.Flags().Changed("metadata-source"):
+		res.MetadataSource, _ = cmd.Flags().GetString("metadata-source")
+		origins.Flag("metadataSource")
+	case cmd.Flags().Changed("with-tags"):
+		res.MetadataSource = metadataFromAttributes
+		if withTags, _ := cmd.Flags().GetBool("with-tags"); withTags {
+			res.MetadataSource = metadataFromTags
+		}
+		origins.Flag("metadataSource")
+	case len(cmConf.MetadataSource) > 0:
+		res.MetadataSource = cmConf.MetadataSource
+		origins.Conf("metadataSource")
+	default:
+		res.MetadataSource = metadataFromAttributes
+		origins.Default("metadataSource")
+	}
+
+	switch {
+	case cmd.Flags().Changed("metadata-precedence"):
+		res.MetadataPrecedence, _ = cmd.Flags().GetString("metadata-precedence")
+		origins.Flag("metadataPrecedence")
+	case len(cmConf.MetadataPrecedence) > 0:
+		res.MetadataPrecedence = cmConf.MetadataPrecedence
+		origins.Conf("metadataPrecedence")
+	default:
+		res.MetadataPrecedence = precedenceInstance
+		origins.Default("metadataPrecedence")
+	}
+
+	switch {
+	case cmd.Flags().Changed("health-status"):
+		res.HealthStatus, _ = cmd.Flags().GetString("health-status")
+		origins.Flag("healthStatus")
+	case len(cmConf.HealthStatus) > 0:
+		res.HealthStatus = cmConf.HealthStatus
+		origins.Conf("healthStatus")
+	default:
+		res.HealthStatus = healthStatusIgnore
+		origins.Default("healthStatus")
+	}
+
+	return &configuration.Config{
+		ServiceRegistry: &configuration.ServiceRegistrySettings{AWSCloudMap: res},
+	}, origins.Origins()
+}
@@ -21,8 +21,11 @@ package cloudmap
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/configuration"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/queue"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/shutdown"
 	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/assert"
 )
@@ -71,11 +74,18 @@ func TestParseFlags(t *testing.T) {
 				return c
 			}(),
 			expRes: &options{
-				region:   "whatever",
-				keys:     []string{"this"},
-				interval: 5,
-				adaptor:  "localhost:80/cnwan",
-				debug:    false,
+				regions:            []string{"whatever"},
+				keys:               []string{"this"},
+				interval:           5,
+				adaptor:            "localhost:80/cnwan",
+				eventsVersion:      "v2",
+				auth:               &awsAuth{},
+				queueOpts:          &queue.Options{},
+				shutdownOpts:       &shutdown.Options{DrainTimeout: 20 * time.Second},
+				healthStatus:       "ignore",
+				metadataSource:     "attributes",
+				metadataPrecedence: "instance",
+				debug:              false,
 			},
 		},
 		{
@@ -98,11 +108,18 @@ func TestParseFlags(t *testing.T) {
 				DebugMode: true,
 			},
 			expRes: &options{
-				region:   "whatever",
-				keys:     []string{"this"},
-				interval: 5,
-				adaptor:  "localhost:80/cnwan",
-				debug:    false,
+				regions:            []string{"whatever"},
+				keys:               []string{"this"},
+				interval:           5,
+				adaptor:            "localhost:80/cnwan",
+				eventsVersion:      "v2",
+				auth:               &awsAuth{},
+				queueOpts:          &queue.Options{},
+				shutdownOpts:       &shutdown.Options{DrainTimeout: 20 * time.Second},
+				healthStatus:       "ignore",
+				metadataSource:     "attributes",
+				metadataPrecedence: "instance",
+				debug:              false,
 			},
 		},
 		{
@@ -126,14 +143,245 @@ func TestParseFlags(t *testing.T) {
 				},
 			},
 			expRes: &options{
-				region:    "from-conf",
-				keys:      []string{"that"},
-				credsPath: "path/to/file",
-				interval:  14,
-				adaptor:   "localhost:80/cnwan",
-				debug:     false,
+				regions:            []string{"from-conf"},
+				keys:               []string{"that"},
+				credsPath:          "path/to/file",
+				interval:           14,
+				adaptor:            "localhost:80/cnwan",
+				eventsVersion:      "v2",
+				auth:               &awsAuth{},
+				queueOpts:          &queue.Options{},
+				shutdownOpts:       &shutdown.Options{DrainTimeout: 20 * time.Second},
+				healthStatus:       "ignore",
+				metadataSource:     "attributes",
+				metadataPrecedence: "instance",
+				debug:              false,
 			},
 		},
+		{
+			cmd: func() *cobra.Command {
+				c := GetCloudMapCommand()
+				c.SetArgs([]string{"--metadata-keys=that", "--namespaces=ns-1,two"})
+				c.PreRun = func(*cobra.Command, []string) {}
+				c.Run = func(*cobra.Command, []string) {}
+				c.Execute()
+				return c
+			}(),
+			conf: &configuration.Config{
+				ServiceRegistry: &configuration.ServiceRegistrySettings{
+					AWSCloudMap: &configuration.CloudMapConfig{
+						Region:            "from-conf",
+						Namespaces:        []string{"overridden"},
+						ExcludeNamespaces: []string{"three"},
+						HealthStatus:      "exclude",
+					},
+				},
+			},
+			expRes: &options{
+				regions:            []string{"from-conf"},
+				keys:               []string{"that"},
+				interval:           5,
+				adaptor:            "localhost:80/cnwan",
+				eventsVersion:      "v2",
+				auth:               &awsAuth{},
+				queueOpts:          &queue.Options{},
+				shutdownOpts:       &shutdown.Options{DrainTimeout: 20 * time.Second},
+				healthStatus:       "exclude",
+				metadataSource:     "attributes",
+				metadataPrecedence: "instance",
+				namespaces:         []string{"ns-1", "two"},
+				excludeNamespaces:  []string{"three"},
+			},
+		},
+		{
+			cmd: func() *cobra.Command {
+				c := GetCloudMapCommand()
+				c.SetArgs([]string{"--region=whatever", "--metadata-keys=this", "--health-status=invalid"})
+				c.PreRun = func(*cobra.Command, []string) {}
+				c.Run = func(*cobra.Command, []string) {}
+				c.Execute()
+				return c
+			}(),
+			expErr: fmt.Errorf("invalid health status mode: invalid"),
+		},
+		{
+			cmd: func() *cobra.Command {
+				c := GetCloudMapCommand()
+				c.SetArgs([]string{"--region=whatever", "--metadata-keys=this", "--with-tags"})
+				c.PreRun = func(*cobra.Command, []string) {}
+				c.Run = func(*cobra.Command, []string) {}
+				c.Execute()
+				return c
+			}(),
+			conf: &configuration.Config{
+				ServiceRegistry: &configuration.ServiceRegistrySettings{
+					AWSCloudMap: &configuration.CloudMapConfig{
+						MetadataSource:     "both",
+						MetadataPrecedence: "service",
+					},
+				},
+			},
+			expRes: &options{
+				regions:            []string{"whatever"},
+				keys:               []string{"this"},
+				interval:           5,
+				adaptor:            "localhost:80/cnwan",
+				eventsVersion:      "v2",
+				auth:               &awsAuth{},
+				queueOpts:          &queue.Options{},
+				shutdownOpts:       &shutdown.Options{DrainTimeout: 20 * time.Second},
+				healthStatus:       "ignore",
+				metadataSource:     "tags",
+				metadataPrecedence: "service",
+			},
+		},
+		{
+			cmd: func() *cobra.Command {
+				c := GetCloudMapCommand()
+				c.SetArgs([]string{"--region=whatever", "--metadata-keys=this", "--metadata-source=invalid"})
+				c.PreRun = func(*cobra.Command, []string) {}
+				c.Run = func(*cobra.Command, []string) {}
+				c.Execute()
+				return c
+			}(),
+			expErr: fmt.Errorf("invalid metadata source: invalid"),
+		},
+		{
+			cmd: func() *cobra.Command {
+				c := GetCloudMapCommand()
+				c.SetArgs([]string{"--region=whatever", "--metadata-keys=this", "--role-arn=arn:aws:iam::123456789012:role/from-flag", "--endpoint-url=http://localhost:4566", "--external-id="})
+				c.PreRun = func(*cobra.Command, []string) {}
+				c.Run = func(*cobra.Command, []string) {}
+				c.Execute()
+				return c
+			}(),
+			conf: &configuration.Config{
+				ServiceRegistry: &configuration.ServiceRegistrySettings{
+					AWSCloudMap: &configuration.CloudMapConfig{
+						Profile:         "from-conf",
+						RoleARN:         "arn:aws:iam::123456789012:role/from-conf",
+						ExternalID:      "external",
+						RoleSessionName: "session",
+					},
+				},
+			},
+			expRes: &options{
+				regions:       []string{"whatever"},
+				keys:          []string{"this"},
+				interval:      5,
+				adaptor:       "localhost:80/cnwan",
+				eventsVersion: "v2",
+				auth: &awsAuth{
+					profile:         "from-conf",
+					roleARN:         "arn:aws:iam::123456789012:role/from-flag",
+					roleSessionName: "session",
+					endpointURL:     "http://localhost:4566",
+				},
+				queueOpts:          &queue.Options{},
+				shutdownOpts:       &shutdown.Options{DrainTimeout: 20 * time.Second},
+				healthStatus:       "ignore",
+				metadataSource:     "attributes",
+				metadataPrecedence: "instance",
+			},
+		},
+		{
+			cmd: func() *cobra.Command {
+				c := GetCloudMapCommand()
+				c.SetArgs([]string{"--region=whatever", "--metadata-keys=this", "--external-id=external"})
+				c.PreRun = func(*cobra.Command, []string) {}
+				c.Run = func(*cobra.Command, []string) {}
+				c.Execute()
+				return c
+			}(),
+			expErr: fmt.Errorf("external id provided but no role arn"),
+		},
+		{
+			cmd: func() *cobra.Command {
+				c := GetCloudMapCommand()
+				c.SetArgs([]string{"--region=whatever", "--metadata-keys=this", "--role-arn=arn", "--external-id=external", "--web-identity-token-file=/path/to/token"})
+				c.PreRun = func(*cobra.Command, []string) {}
+				c.Run = func(*cobra.Command, []string) {}
+				c.Execute()
+				return c
+			}(),
+			expErr: fmt.Errorf("external id cannot be used with web identity"),
+		},
+		{
+			cmd: func() *cobra.Command {
+				c := GetCloudMapCommand()
+				c.SetArgs([]string{"--region=overridden", "--regions=us-west-2,eu-west-1", "--metadata-keys=this"})
+				c.PreRun = func(*cobra.Command, []string) {}
+				c.Run = func(*cobra.Command, []string) {}
+				c.Execute()
+				return c
+			}(),
+			conf: &configuration.Config{
+				ServiceRegistry: &configuration.ServiceRegistrySettings{
+					AWSCloudMap: &configuration.CloudMapConfig{
+						Regions: []string{"overridden-too"},
+						Accounts: []configuration.CloudMapAccount{
+							{RoleARN: "arn:aws:iam::123456789012:role/one", ExternalID: "ext"},
+							{RoleARN: "arn:aws:iam::210987654321:role/two", Regions: []string{"us-east-1"}},
+						},
+					},
+				},
+			},
+			expRes: &options{
+				regions: []string{"us-west-2", "eu-west-1"},
+				accounts: []*account{
+					{roleARN: "arn:aws:iam::123456789012:role/one", externalID: "ext"},
+					{roleARN: "arn:aws:iam::210987654321:role/two", regions: []string{"us-east-1"}},
+				},
+				keys:               []string{"this"},
+				interval:           5,
+				adaptor:            "localhost:80/cnwan",
+				eventsVersion:      "v2",
+				auth:               &awsAuth{},
+				queueOpts:          &queue.Options{},
+				shutdownOpts:       &shutdown.Options{DrainTimeout: 20 * time.Second},
+				healthStatus:       "ignore",
+				metadataSource:     "attributes",
+				metadataPrecedence: "instance",
+			},
+		},
+		{
+			cmd: func() *cobra.Command {
+				c := GetCloudMapCommand()
+				c.SetArgs([]string{"--metadata-keys=this"})
+				c.PreRun = func(*cobra.Command, []string) {}
+				c.Run = func(*cobra.Command, []string) {}
+				c.Execute()
+				return c
+			}(),
+			conf: &configuration.Config{
+				ServiceRegistry: &configuration.ServiceRegistrySettings{
+					AWSCloudMap: &configuration.CloudMapConfig{
+						Accounts: []configuration.CloudMapAccount{
+							{RoleARN: "arn:aws:iam::123456789012:role/one"},
+						},
+					},
+				},
+			},
+			expErr: fmt.Errorf("region not provided for account arn:aws:iam::123456789012:role/one"),
+		},
+		{
+			cmd: func() *cobra.Command {
+				c := GetCloudMapCommand()
+				c.SetArgs([]string{"--metadata-keys=this", "--region=us-west-2"})
+				c.PreRun = func(*cobra.Command, []string) {}
+				c.Run = func(*cobra.Command, []string) {}
+				c.Execute()
+				return c
+			}(),
+			conf: &configuration.Config{
+				ServiceRegistry: &configuration.ServiceRegistrySettings{
+					AWSCloudMap: &configuration.CloudMapConfig{
+						Accounts: []configuration.CloudMapAccount{{ExternalID: "ext"}},
+					},
+				},
+			},
+			expErr: fmt.Errorf("account with no role arn provided"),
+		},
 		// {
 		// 	cmd: func() *cobra.Command {
 		// 		c := GetCloudMapCommand()
@@ -178,3 +426,65 @@ func TestParseFlags(t *testing.T) {
 	}
 
 }
+
+func TestGetTargets(t *testing.T) {
+	a := assert.New(t)
+	auth := &awsAuth{profile: "profile", roleSessionName: "session"}
+	cases := []struct {
+		opts   *options
+		expRes []*target
+	}{
+		{
+			opts: &options{regions: []string{"us-west-2"}, auth: auth},
+			expRes: []*target{
+				{region: "us-west-2", auth: auth},
+			},
+		},
+		{
+			opts: &options{regions: []string{"us-west-2", "eu-west-1"}, auth: auth},
+			expRes: []*target{
+				{region: "us-west-2", auth: auth},
+				{region: "eu-west-1", auth: auth},
+			},
+		},
+		{
+			opts: &options{
+				regions: []string{"us-west-2", "eu-west-1"},
+				accounts: []*account{
+					{roleARN: "arn:aws:iam::123456789012:role/one", externalID: "ext"},
+					{roleARN: "invalid", regions: []string{"us-east-1"}},
+				},
+				auth: auth,
+			},
+			expRes: []*target{
+				{
+					region:  "us-west-2",
+					account: "123456789012",
+					auth:    &awsAuth{profile: "profile", roleSessionName: "session", roleARN: "arn:aws:iam::123456789012:role/one", externalID: "ext"},
+				},
+				{
+					region:  "eu-west-1",
+					account: "123456789012",
+					auth:    &awsAuth{profile: "profile", roleSessionName: "session", roleARN: "arn:aws:iam::123456789012:role/one", externalID: "ext"},
+				},
+				{
+					region:  "us-east-1",
+					account: "invalid",
+					auth:    &awsAuth{profile: "profile", roleSessionName: "session", roleARN: "invalid"},
+				},
+			},
+		},
+	}
+
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+	for i, currCase := range cases {
+		if !a.Equal(currCase.expRes, currCase.opts.getTargets()) {
+			failed(i)
+		}
+	}
+
+	// The options must not be modified
+	a.Equal(&awsAuth{profile: "profile", roleSessionName: "session"}, auth)
+}
@@ -25,14 +25,45 @@ const (
 observes changes to services published in it, i.e. metadata, addresses and
 ports.
 	
-For this to work, a valid region must be provided with --region and the
-aws credentials must be properly set.
+For this to work, a valid region must be provided with --region, or more
+with --regions, and the aws credentials must be properly set.
 
 Unless a different credentials path is defined with --credentials-path,
 $HOME/.aws/credentials on Linux/Unix and %USERPROFILE%\.aws\credentials on
 Windows will be used instead. Alternatively, credentials path can be set
 with environment variables. For a complete list of alternatives, please
 refer to AWS Session documentation, but, to keep things simple, we suggest you
-use the default one.`
+use the default one.
+
+A different profile can be used with --profile. To read services from a
+different account, a role can be assumed with --role-arn, optionally with
+--external-id and --role-session-name, or with --web-identity-token-file
+when running on EKS with IAM roles for service accounts. --endpoint-url
+lets you connect to a different endpoint than Cloud Map's default one, i.e.
+a local stand-in for testing.
+
+Services from all namespaces are read, unless --namespaces and/or
+--exclude-namespaces are provided: both accept namespace IDs or names.
+
+Instances are reported regardless of their health status, unless
+--health-status is set to exclude, to ignore unhealthy instances, or to
+metadata, to include their health status in their metadata.
+
+Metadata is read from attributes of instances, unless --metadata-source is
+set to tags, to read it from tags of services, or to both. In the latter
+case, --metadata-precedence tells whether instance or service wins when a
+key is in both.`
 	cmdExample string = "cloudmap --region us-west-2 --credentials path/to/credentials/file"
+
+	// defaultRoleSessionName is the session name used when assuming a
+	// role, unless a different one is provided.
+	defaultRoleSessionName string = "cnwan-reader"
+
+	// sourceName is the name of the service registry included in the
+	// events detected by this command.
+	sourceName string = "cloudmap"
+
+	// sectionPath is the path of the section of the configuration read by
+	// this command.
+	sectionPath string = "serviceRegistry.awsCloudMap"
 )
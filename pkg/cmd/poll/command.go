@@ -20,6 +20,8 @@ package poll
 
 import (
 	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/cmd/poll/cloudmap"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/cmd/poll/servicedirectory"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/configuration"
 	"github.com/spf13/cobra"
 )
 
@@ -37,11 +39,12 @@ func GetPollCommand() *cobra.Command {
 	}
 
 	// Flags
-	cmd.Flags().Int("poll-interval", 5, "interval between two consecutive polls")
+	cmd.PersistentFlags().Int("poll-interval", 5, "interval between two consecutive polls")
+	configuration.BindFlag(cmd.PersistentFlags(), "poll-interval", ".pollInterval")
 
 	// Subcommands
-	// TODO: service directory will be inserted here as well
 	cmd.AddCommand(cloudmap.GetCloudMapCommand())
+	cmd.AddCommand(servicedirectory.GetServiceDirectoryCommand())
 
 	return cmd
 }
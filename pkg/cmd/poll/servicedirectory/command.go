@@ -0,0 +1,239 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package servicedirectory
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/configuration"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/pipeline"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/poller"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/queue"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/sdhandler"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/services"
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+)
+
+var (
+	log zerolog.Logger
+)
+
+func init() {
+	output := zerolog.ConsoleWriter{Out: os.Stdout}
+	log = zerolog.New(output).With().Timestamp().Logger().Level(zerolog.InfoLevel)
+}
+
+// GetServiceDirectoryCommand returns the servicedirectory command
+func GetServiceDirectoryCommand() *cobra.Command {
+	var opts *options
+
+	cmd := &cobra.Command{
+		Use:     cmdUse,
+		Short:   cmdShort,
+		Long:    cmdLong,
+		Example: cmdExample,
+		Aliases: []string{"sd", "gcloud", "gcsd"},
+		Annotations: map[string]string{
+			configuration.SectionAnnotation: sectionPath,
+		},
+		PreRun: func(cmd *cobra.Command, _ []string) {
+			_opts, err := parseFlags(cmd, configuration.GetConfigFile())
+			if err != nil {
+				log.Fatal().Err(err).Msg("fatal error encountered")
+				return
+			}
+
+			if _opts.debug {
+				log = log.Level(zerolog.DebugLevel)
+			}
+
+			opts = _opts
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			run(cmd, opts)
+		},
+	}
+
+	// Flags
+	cmd.Flags().String("project", "", "the ID of the Google Cloud project")
+	cmd.Flags().String("region", "", "the region where services are registered, i.e. us-west2")
+	cmd.Flags().StringSlice("locations", []string{}, "projects and regions to scan, in the form of project/region, or just project to scan all its regions, instead of --project and --region")
+	cmd.Flags().String("service-account", "", "the path to the service account JSON file, Application Default Credentials are used if not provided")
+	cmd.Flags().StringSlice("metadata-keys", []string{}, "the metadata keys to watch for")
+	cmd.Flags().String("metadata-precedence", sdhandler.PrecedenceEndpoint, "which one wins when a key is in the annotations of both an endpoint and its service: endpoint or service")
+
+	// Fields of the configuration overridden by flags
+	for flag, path := range map[string]string{
+		"project":             ".projectID",
+		"region":              ".region",
+		"locations":           ".locations",
+		"service-account":     ".serviceAccountPath",
+		"metadata-keys":       "metadataKeys",
+		"metadata-precedence": ".metadataPrecedence",
+	} {
+		configuration.BindFlag(cmd.Flags(), flag, path)
+	}
+
+	return cmd
+}
+
+// NewSource returns a source that polls Service Directory with the
+// settings from the provided command and configuration, to be run along
+// with other sources.
+func NewSource(cmd *cobra.Command, conf *configuration.Config) (pipeline.Source, error) {
+	opts, err := parseFlags(cmd, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.debug {
+		log = log.Level(zerolog.DebugLevel)
+	}
+
+	return &source{cmd: cmd, opts: opts}, nil
+}
+
+// source polls Service Directory and enqueues the changes it detects.
+type source struct {
+	cmd  *cobra.Command
+	opts *options
+
+	lock sync.Mutex
+	// next contains the new options, which replace the current ones on
+	// next poll.
+	next *options
+}
+
+// Name returns the name of the source.
+func (s *source) Name() string {
+	return sourceName
+}
+
+// Reload parses the new configuration, which is applied on next poll.
+func (s *source) Reload(conf *configuration.Config) error {
+	opts, err := parseFlags(s.cmd, conf)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	s.next = opts
+	s.lock.Unlock()
+	return nil
+}
+
+// takeNext returns the new options, if any.
+func (s *source) takeNext() *options {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	next := s.next
+	s.next = nil
+	return next
+}
+
+func newHandler(ctx context.Context, opts *options) (sdhandler.Handler, error) {
+	return sdhandler.New(ctx, &sdhandler.Options{
+		Locations:          opts.locations,
+		MetadataKey:        opts.keys[0],
+		CredentialsPath:    opts.credsPath,
+		MetadataPrecedence: opts.metadataPrecedence,
+		ExtraMetadata:      opts.extraMetadata,
+	})
+}
+
+// Run polls Service Directory until ctx is done.
+func (s *source) Run(ctx context.Context, q queue.Queue, resync <-chan struct{}) error {
+	log.Info().Str("service-registry", "Service Directory").Msg("starting...")
+
+	sdHandler, err := newHandler(ctx, s.opts)
+	if err != nil {
+		return fmt.Errorf("error while trying to connect to service directory: %w", err)
+	}
+	datastore := services.NewDatastore()
+	datastore.SetTrackedKeys(s.opts.extraMetadata.TrackedKeys(s.opts.keys[:1]))
+
+	log.Info().Msg("observing changes...")
+	poll := poller.New(ctx, s.opts.interval)
+	poll.SetPollFunction(func() {
+		if next := s.takeNext(); next != nil {
+			// Services that enter or leave the filters are detected as
+			// any other change.
+			log.Info().Msg("applying new configuration...")
+			if _sdHandler, err := newHandler(ctx, next); err != nil {
+				log.Err(err).Msg("error while trying to connect to service directory, keeping the current configuration")
+			} else {
+				if err := sdHandler.Close(); err != nil {
+					log.Debug().Err(err).Msg("error while closing the previous connection to service directory")
+				}
+				sdHandler, s.opts = _sdHandler, next
+				poll.SetInterval(s.opts.interval)
+				datastore.SetTrackedKeys(s.opts.extraMetadata.TrackedKeys(s.opts.keys[:1]))
+			}
+		}
+
+		oaSrvs, err := sdHandler.GetServices()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Err(err).Msg("error while polling, skipping...")
+			}
+			return
+		}
+
+		filtered := datastore.GetEvents(oaSrvs)
+		select {
+		case <-resync:
+			// Events may have been missed while this was a follower
+			filtered = services.ResyncEvents(oaSrvs, filtered)
+		default:
+		}
+
+		if len(filtered) > 0 {
+			log.Info().Msg("changes detected")
+			services.StampEvents(filtered, sourceName)
+			q.Enqueue(filtered)
+		}
+	})
+
+	poll.Start()
+	<-poll.Done()
+	sdHandler.Close()
+	return nil
+}
+
+func run(cmd *cobra.Command, opts *options) {
+	log.Info().Str("adaptor", opts.adaptor).Msg("starting...")
+
+	ctx, canc := context.WithCancel(context.Background())
+	pipelineOpts := opts.pipelineOptions()
+	pipelineOpts.Reload = pipeline.WatchConfigFile(ctx, cmd)
+
+	exitCode, err := pipeline.Run([]pipeline.Source{&source{cmd: cmd, opts: opts}}, pipelineOpts)
+	canc()
+	if err != nil {
+		log.Fatal().Err(err).Msg("fatal error encountered")
+	}
+
+	log.Info().Msg("good bye!")
+	os.Exit(exitCode)
+}
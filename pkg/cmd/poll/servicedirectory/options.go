@@ -0,0 +1,68 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package servicedirectory
+
+import (
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/election"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/filter"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/pipeline"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/queue"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/sdhandler"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/services"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/shutdown"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/transform"
+)
+
+type options struct {
+	// locations are the projects and regions to scan.
+	locations []sdhandler.Location
+	// credsPath is the path of the service account JSON file. If empty,
+	// Application Default Credentials are used.
+	credsPath     string
+	interval      int
+	adaptor       string
+	eventsVersion string
+	filter        *filter.Filter
+	transformer   *transform.Transformer
+	queueOpts     *queue.Options
+	shutdownOpts  *shutdown.Options
+	electionOpts  *election.Options
+	debug         bool
+	keys          []string
+	// extraMetadata contains which annotations are included in events in
+	// addition to keys.
+	extraMetadata *services.ExtraMetadata
+	// metadataPrecedence is which of endpoint or service wins when a key
+	// is in the annotations of both.
+	metadataPrecedence string
+}
+
+// pipelineOptions returns the settings about how events are sent to the
+// adaptor.
+func (o *options) pipelineOptions() pipeline.Options {
+	return pipeline.Options{
+		Adaptor:       o.adaptor,
+		EventsVersion: o.eventsVersion,
+		Filter:        o.filter,
+		Transformer:   o.transformer,
+		Queue:         o.queueOpts,
+		Shutdown:      o.shutdownOpts,
+		Election:      o.electionOpts,
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package servicedirectory
+
+import (
+	"fmt"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/configuration"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/internal/utils"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/pipeline"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/sdhandler"
+	"github.com/spf13/cobra"
+)
+
+func parseFlags(cmd *cobra.Command, conf *configuration.Config) (*options, error) {
+	opts := &options{}
+
+	resolved, _ := ResolveConfig(cmd, conf)
+	sdConf := resolved.ServiceRegistry.GCPServiceDirectory
+
+	locations, err := getLocations(sdConf)
+	if err != nil {
+		return nil, err
+	}
+	opts.locations = locations
+
+	opts.credsPath = sdConf.ServiceAccountPath
+	opts.interval = sdConf.PollingInterval
+
+	switch sdConf.MetadataPrecedence {
+	case sdhandler.PrecedenceEndpoint, sdhandler.PrecedenceService:
+		opts.metadataPrecedence = sdConf.MetadataPrecedence
+	default:
+		return nil, fmt.Errorf("invalid metadata precedence: %s", sdConf.MetadataPrecedence)
+	}
+
+	keys, err := utils.GetMetadataKeysFromCmdFlags(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	// The keys are the ones services have after being transformed, while
+	// the service registry must look for their original names.
+	transformer, err := pipeline.GetTransformerFromConfig()
+	if err != nil {
+		return nil, err
+	}
+	opts.keys = transformer.SourceKeys(sourceName, keys)
+	opts.transformer = transformer
+
+	extraMetadata := utils.GetExtraMetadataFromFlags(cmd)
+	if extraMetadata != nil {
+		extraMetadata.Include = transformer.SourceKeys(sourceName, extraMetadata.Include)
+		extraMetadata.Exclude = transformer.SourceKeys(sourceName, extraMetadata.Exclude)
+	}
+	opts.extraMetadata = extraMetadata
+
+	adaptor, err := utils.GetAdaptorEndpointFromFlags(cmd)
+	if err != nil {
+		return nil, err
+	}
+	opts.adaptor = adaptor
+
+	eventsVersion, err := utils.GetEventsVersionFromFlags(cmd)
+	if err != nil {
+		return nil, err
+	}
+	opts.eventsVersion = eventsVersion
+
+	flt, err := pipeline.GetFilterFromFlags(cmd)
+	if err != nil {
+		return nil, err
+	}
+	opts.filter = flt
+
+	queueOpts, err := pipeline.GetQueueOptionsFromFlags(cmd)
+	if err != nil {
+		return nil, err
+	}
+	opts.queueOpts = queueOpts
+
+	shutdownOpts, err := pipeline.GetShutdownOptionsFromFlags(cmd)
+	if err != nil {
+		return nil, err
+	}
+	opts.shutdownOpts = shutdownOpts
+
+	electionOpts, err := pipeline.GetElectionOptionsFromFlags(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if electionOpts != nil && len(electionOpts.Endpoints) == 0 {
+		return nil, fmt.Errorf("no leader election endpoints provided")
+	}
+	opts.electionOpts = electionOpts
+	opts.debug = utils.GetDebugModeFromFlags(cmd)
+
+	return opts, nil
+}
+
+// ResolveConfig returns the configuration with only the Service Directory
+// section used by the command, resolved from its flags and from conf, and
+// where the value of each of its fields comes from.
+//
+// --locations takes precedence over --project and --region, and flags take
+// precedence over the configuration file.
+func ResolveConfig(cmd *cobra.Command, conf *configuration.Config) (*configuration.Config, map[string]configuration.Origin) {
+	sdConf := &configuration.ServiceDirectoryConfig{}
+	if conf != nil && conf.ServiceRegistry != nil && conf.ServiceRegistry.GCPServiceDirectory != nil {
+		sdConf = conf.ServiceRegistry.GCPServiceDirectory
+	}
+	res := &configuration.ServiceDirectoryConfig{}
+	origins := configuration.NewSectionOrigins(sectionPath)
+
+	project, _ := cmd.Flags().GetString("project")
+	region, _ := cmd.Flags().GetString("region")
+	switch {
+	case cmd.Flags().Changed("locations"):
+		res.Locations, _ = cmd.Flags().GetStringSlice("locations")
+		origins.Flag("locations")
+	case len(project) == 0 && len(region) == 0 && len(sdConf.Locations) > 0:
+		res.Locations = sdConf.Locations
+		origins.Conf("locations")
+	}
+
+	if len(res.Locations) == 0 {
+		res.ProjectID, res.Region = sdConf.ProjectID, sdConf.Region
+		if len(project) > 0 {
+			res.ProjectID = project
+			origins.Flag("projectID")
+		} else if len(res.ProjectID) > 0 {
+			origins.Conf("projectID")
+		}
+
+		if len(region) > 0 {
+			res.Region = region
+			origins.Flag("region")
+		} else if len(res.Region) > 0 {
+			origins.Conf("region")
+		}
+	}
+
+	res.ServiceAccountPath = sdConf.ServiceAccountPath
+	if cmd.Flags().Changed("service-account") {
+		res.ServiceAccountPath, _ = cmd.Flags().GetString("service-account")
+		origins.Flag("serviceAccountPath")
+	} else if len(res.ServiceAccountPath) > 0 {
+		origins.Conf("serviceAccountPath")
+	}
+
+	pollInterval, _ := cmd.Flags().GetInt("poll-interval")
+	switch {
+	case cmd.Flags().Changed("poll-interval") && pollInterval > 0:
+		res.PollingInterval = pollInterval
+		origins.Flag("pollInterval")
+	case !cmd.Flags().Changed("poll-interval") && sdConf.PollingInterval > 0:
+		res.PollingInterval = sdConf.PollingInterval
+		origins.Conf("pollInterval")
+	default:
+		res.PollingInterval = 5
+		origins.Default("pollInterval")
+	}
+
+	switch {
+	case cmd.Flags().Changed("metadata-precedence"):
+		res.MetadataPrecedence, _ = cmd.Flags().GetString("metadata-precedence")
+		origins.Flag("metadataPrecedence")
+	case len(sdConf.MetadataPrecedence) > 0:
+		res.MetadataPrecedence = sdConf.MetadataPrecedence
+		origins.Conf("metadataPrecedence")
+	default:
+		res.MetadataPrecedence = sdhandler.PrecedenceEndpoint
+		origins.Default("metadataPrecedence")
+	}
+
+	return &configuration.Config{
+		ServiceRegistry: &configuration.ServiceRegistrySettings{GCPServiceDirectory: res},
+	}, origins.Origins()
+}
+
+// getLocations returns the locations to scan: the provided ones or, if
+// none, the one of the provided project and region.
+func getLocations(sdConf *configuration.ServiceDirectoryConfig) ([]sdhandler.Location, error) {
+	if len(sdConf.Locations) > 0 {
+		locations := make([]sdhandler.Location, len(sdConf.Locations))
+		for i, loc := range sdConf.Locations {
+			_loc, err := sdhandler.ParseLocation(loc)
+			if err != nil {
+				return nil, err
+			}
+			locations[i] = _loc
+		}
+
+		return locations, nil
+	}
+
+	if len(sdConf.ProjectID) == 0 {
+		return nil, fmt.Errorf("project not provided")
+	}
+
+	if len(sdConf.Region) == 0 {
+		return nil, fmt.Errorf("region not provided")
+	}
+
+	return []sdhandler.Location{{Project: sdConf.ProjectID, Region: sdConf.Region}}, nil
+}
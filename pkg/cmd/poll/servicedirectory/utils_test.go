@@ -0,0 +1,227 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package servicedirectory
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/configuration"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/queue"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/sdhandler"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/shutdown"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFlags(t *testing.T) {
+	a := assert.New(t)
+	newCmd := func(args ...string) *cobra.Command {
+		c := GetServiceDirectoryCommand()
+		c.SetArgs(args)
+		c.PreRun = func(*cobra.Command, []string) {}
+		c.Run = func(*cobra.Command, []string) {}
+		c.Execute()
+		return c
+	}
+	cases := []struct {
+		cmd *cobra.Command
+
+		conf   *configuration.Config
+		expRes *options
+		expErr error
+	}{
+		{
+			cmd:    newCmd(),
+			expErr: fmt.Errorf("project not provided"),
+		},
+		{
+			cmd:    newCmd("--project=my-project"),
+			expErr: fmt.Errorf("region not provided"),
+		},
+		{
+			cmd:    newCmd("--project=my-project", "--region=us-west2"),
+			expErr: fmt.Errorf("no metadata keys provided"),
+		},
+		{
+			cmd: newCmd("--project=my-project", "--region=us-west2", "--metadata-keys=this"),
+			expRes: &options{
+				locations:          []sdhandler.Location{{Project: "my-project", Region: "us-west2"}},
+				keys:               []string{"this"},
+				interval:           5,
+				adaptor:            "localhost:80/cnwan",
+				eventsVersion:      "v2",
+				queueOpts:          &queue.Options{},
+				shutdownOpts:       &shutdown.Options{DrainTimeout: 20 * time.Second},
+				metadataPrecedence: "endpoint",
+			},
+		},
+		{
+			cmd: newCmd("--region=us-west2", "--metadata-keys=this"),
+			conf: &configuration.Config{
+				ServiceRegistry: &configuration.ServiceRegistrySettings{
+					GCPServiceDirectory: &configuration.ServiceDirectoryConfig{
+						PollingInterval:    14,
+						ProjectID:          "from-conf",
+						Region:             "overridden",
+						ServiceAccountPath: "path/to/file.json",
+					},
+				},
+			},
+			expRes: &options{
+				locations:          []sdhandler.Location{{Project: "from-conf", Region: "us-west2"}},
+				credsPath:          "path/to/file.json",
+				keys:               []string{"this"},
+				interval:           14,
+				adaptor:            "localhost:80/cnwan",
+				eventsVersion:      "v2",
+				queueOpts:          &queue.Options{},
+				shutdownOpts:       &shutdown.Options{DrainTimeout: 20 * time.Second},
+				metadataPrecedence: "endpoint",
+			},
+		},
+		{
+			cmd: newCmd("--metadata-keys=this", "--service-account=path/from/flag.json"),
+			conf: &configuration.Config{
+				ServiceRegistry: &configuration.ServiceRegistrySettings{
+					GCPServiceDirectory: &configuration.ServiceDirectoryConfig{
+						ProjectID:          "from-conf",
+						Region:             "from-conf",
+						ServiceAccountPath: "path/to/file.json",
+					},
+				},
+			},
+			expRes: &options{
+				locations:          []sdhandler.Location{{Project: "from-conf", Region: "from-conf"}},
+				credsPath:          "path/from/flag.json",
+				keys:               []string{"this"},
+				interval:           5,
+				adaptor:            "localhost:80/cnwan",
+				eventsVersion:      "v2",
+				queueOpts:          &queue.Options{},
+				shutdownOpts:       &shutdown.Options{DrainTimeout: 20 * time.Second},
+				metadataPrecedence: "endpoint",
+			},
+		},
+		{
+			cmd: newCmd("--metadata-keys=this"),
+			conf: &configuration.Config{
+				ServiceRegistry: &configuration.ServiceRegistrySettings{
+					GCPServiceDirectory: &configuration.ServiceDirectoryConfig{
+						ProjectID:          "from-conf",
+						Region:             "from-conf",
+						MetadataPrecedence: "service",
+					},
+				},
+			},
+			expRes: &options{
+				locations:          []sdhandler.Location{{Project: "from-conf", Region: "from-conf"}},
+				keys:               []string{"this"},
+				interval:           5,
+				adaptor:            "localhost:80/cnwan",
+				eventsVersion:      "v2",
+				queueOpts:          &queue.Options{},
+				shutdownOpts:       &shutdown.Options{DrainTimeout: 20 * time.Second},
+				metadataPrecedence: "service",
+			},
+		},
+		{
+			cmd:    newCmd("--project=my-project", "--region=us-west2", "--metadata-keys=this", "--metadata-precedence=invalid"),
+			expErr: fmt.Errorf("invalid metadata precedence: invalid"),
+		},
+		{
+			cmd: newCmd("--metadata-keys=this", "--locations=one/us-west2,two"),
+			conf: &configuration.Config{
+				ServiceRegistry: &configuration.ServiceRegistrySettings{
+					GCPServiceDirectory: &configuration.ServiceDirectoryConfig{
+						ProjectID: "from-conf",
+						Region:    "from-conf",
+						Locations: []string{"overridden/us-east1"},
+					},
+				},
+			},
+			expRes: &options{
+				locations:          []sdhandler.Location{{Project: "one", Region: "us-west2"}, {Project: "two"}},
+				keys:               []string{"this"},
+				interval:           5,
+				adaptor:            "localhost:80/cnwan",
+				eventsVersion:      "v2",
+				queueOpts:          &queue.Options{},
+				shutdownOpts:       &shutdown.Options{DrainTimeout: 20 * time.Second},
+				metadataPrecedence: "endpoint",
+			},
+		},
+		{
+			cmd: newCmd("--metadata-keys=this"),
+			conf: &configuration.Config{
+				ServiceRegistry: &configuration.ServiceRegistrySettings{
+					GCPServiceDirectory: &configuration.ServiceDirectoryConfig{
+						ProjectID: "ignored",
+						Locations: []string{"one/us-east1", "two/europe-west1"},
+					},
+				},
+			},
+			expRes: &options{
+				locations:          []sdhandler.Location{{Project: "one", Region: "us-east1"}, {Project: "two", Region: "europe-west1"}},
+				keys:               []string{"this"},
+				interval:           5,
+				adaptor:            "localhost:80/cnwan",
+				eventsVersion:      "v2",
+				queueOpts:          &queue.Options{},
+				shutdownOpts:       &shutdown.Options{DrainTimeout: 20 * time.Second},
+				metadataPrecedence: "endpoint",
+			},
+		},
+		{
+			cmd: newCmd("--metadata-keys=this", "--project=from-flag", "--region=us-west2"),
+			conf: &configuration.Config{
+				ServiceRegistry: &configuration.ServiceRegistrySettings{
+					GCPServiceDirectory: &configuration.ServiceDirectoryConfig{
+						Locations: []string{"one/us-east1"},
+					},
+				},
+			},
+			expRes: &options{
+				locations:          []sdhandler.Location{{Project: "from-flag", Region: "us-west2"}},
+				keys:               []string{"this"},
+				interval:           5,
+				adaptor:            "localhost:80/cnwan",
+				eventsVersion:      "v2",
+				queueOpts:          &queue.Options{},
+				shutdownOpts:       &shutdown.Options{DrainTimeout: 20 * time.Second},
+				metadataPrecedence: "endpoint",
+			},
+		},
+		{
+			cmd:    newCmd("--metadata-keys=this", "--locations=one/us-west2/extra"),
+			expErr: fmt.Errorf("invalid location: one/us-west2/extra"),
+		},
+	}
+
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+	for i, currCase := range cases {
+		res, err := parseFlags(currCase.cmd, currCase.conf)
+		if !a.Equal(currCase.expRes, res) || !a.Equal(currCase.expErr, err) {
+			failed(i)
+		}
+	}
+}
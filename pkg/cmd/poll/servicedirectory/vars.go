@@ -0,0 +1,50 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package servicedirectory
+
+const (
+	cmdUse   string = "servicedirectory [--project <project> --region <region> | --locations <locations>] [--service-account <service-account-path>]"
+	cmdShort string = "connect to Service Directory to get registered services"
+	cmdLong  string = `servicedirectory connects to Google Cloud Service
+Directory and observes changes to services published in it, i.e. metadata,
+addresses and ports.
+
+For this to work, a project and a region must be provided with --project and
+--region, or a list of locations with --locations: each location is in the
+form of project/region, or just project to scan all its regions.
+
+Unless the path of a service account JSON file is provided with
+--service-account, Application Default Credentials are used: i.e. the file
+pointed by GOOGLE_APPLICATION_CREDENTIALS, the credentials of the gcloud CLI
+or the ones of the service account attached to the VM or pod where this is
+running.
+
+Endpoints are reported if they, or their service, have the metadata key in
+their annotations. When the key is in both, the endpoint's one wins, unless
+--metadata-precedence is set to service.`
+	cmdExample string = "servicedirectory --project my-project --region us-central1 --service-account path/to/service-account.json --metadata-keys traffic-profile"
+
+	// sourceName is the name of the service registry included in the
+	// events detected by this command.
+	sourceName string = "servicedirectory"
+
+	// sectionPath is the path of the section of the configuration read by
+	// this command.
+	sectionPath string = "serviceRegistry.gcpServiceDirectory"
+)
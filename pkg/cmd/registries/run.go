@@ -0,0 +1,194 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package registries
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/cmd/poll/cloudmap"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/cmd/poll/servicedirectory"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/cmd/watch/etcd"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/configuration"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/internal/utils"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/pipeline"
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+var (
+	log zerolog.Logger
+)
+
+func init() {
+	output := zerolog.ConsoleWriter{Out: os.Stdout}
+	log = zerolog.New(output).With().Timestamp().Logger().Level(zerolog.InfoLevel)
+}
+
+// Run runs all the service registries included in the configuration at
+// the same time and exits when done.
+func Run(cmd *cobra.Command, conf *configuration.Config) {
+	sources, err := getSources(cmd, conf)
+	if err != nil {
+		log.Fatal().Err(err).Msg("fatal error encountered")
+		return
+	}
+
+	opts, err := getPipelineOptions(cmd, sources)
+	if err != nil {
+		log.Fatal().Err(err).Msg("fatal error encountered")
+		return
+	}
+
+	names := make([]string, len(sources))
+	for i, src := range sources {
+		names[i] = src.Name()
+	}
+	log.Info().Strs("service-registries", names).Str("adaptor", opts.Adaptor).Msg("starting...")
+
+	ctx, canc := context.WithCancel(context.Background())
+	opts.Reload = pipeline.WatchConfigFile(ctx, cmd)
+
+	exitCode, err := pipeline.Run(sources, *opts)
+	canc()
+	if err != nil {
+		log.Fatal().Err(err).Msg("fatal error encountered")
+		return
+	}
+
+	log.Info().Msg("good bye!")
+	os.Exit(exitCode)
+}
+
+// getSources returns a source for each service registry included in the
+// configuration.
+func getSources(cmd *cobra.Command, conf *configuration.Config) ([]pipeline.Source, error) {
+	if conf == nil || conf.ServiceRegistry == nil {
+		return nil, fmt.Errorf("no service registry provided")
+	}
+
+	sources := []pipeline.Source{}
+	if conf.ServiceRegistry.GCPServiceDirectory != nil {
+		src, err := servicedirectory.NewSource(cmd, conf)
+		if err != nil {
+			return nil, fmt.Errorf("error in service directory configuration: %w", err)
+		}
+		sources = append(sources, src)
+	}
+
+	if conf.ServiceRegistry.AWSCloudMap != nil {
+		src, err := cloudmap.NewSource(cmd, conf)
+		if err != nil {
+			return nil, fmt.Errorf("error in cloud map configuration: %w", err)
+		}
+		sources = append(sources, src)
+	}
+
+	if conf.ServiceRegistry.Etcd != nil {
+		src, err := etcd.NewSource(cmd, conf)
+		if err != nil {
+			return nil, fmt.Errorf("error in etcd configuration: %w", err)
+		}
+		sources = append(sources, src)
+	}
+
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no service registry provided")
+	}
+
+	return sources, nil
+}
+
+// clientSource is a source that uses an etcd client, which can be used for
+// the leader election as well.
+type clientSource interface {
+	Client() *clientv3.Client
+}
+
+// getPipelineOptions returns the settings about how events are sent to
+// the adaptor.
+func getPipelineOptions(cmd *cobra.Command, sources []pipeline.Source) (*pipeline.Options, error) {
+	adaptor, err := utils.GetAdaptorEndpointFromFlags(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	eventsVersion, err := utils.GetEventsVersionFromFlags(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	flt, err := pipeline.GetFilterFromFlags(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	transformer, err := pipeline.GetTransformerFromConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	queueOpts, err := pipeline.GetQueueOptionsFromFlags(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	shutdownOpts, err := pipeline.GetShutdownOptionsFromFlags(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	electionOpts, err := pipeline.GetElectionOptionsFromFlags(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unless told otherwise, the election takes place in the same etcd
+	// cluster that is being watched, if any.
+	var electionCli *clientv3.Client
+	if electionOpts != nil && len(electionOpts.Endpoints) == 0 {
+		for _, src := range sources {
+			if cs, ok := src.(clientSource); ok {
+				electionCli = cs.Client()
+				break
+			}
+		}
+
+		if electionCli == nil {
+			return nil, fmt.Errorf("no leader election endpoints provided")
+		}
+	}
+
+	if utils.GetDebugModeFromFlags(cmd) {
+		log = log.Level(zerolog.DebugLevel)
+	}
+
+	return &pipeline.Options{
+		Adaptor:        adaptor,
+		EventsVersion:  eventsVersion,
+		Filter:         flt,
+		Transformer:    transformer,
+		Queue:          queueOpts,
+		Shutdown:       shutdownOpts,
+		Election:       electionOpts,
+		ElectionClient: electionCli,
+	}, nil
+}
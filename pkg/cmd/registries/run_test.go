@@ -0,0 +1,106 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package registries
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/configuration"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSources(t *testing.T) {
+	a := assert.New(t)
+	cmd := &cobra.Command{}
+	cmd.Flags().StringSlice("metadata-keys", []string{}, "")
+	cmd.Flags().Set("metadata-keys", "this")
+
+	sd := &configuration.ServiceDirectoryConfig{ProjectID: "my-project", Region: "us-west2"}
+	cm := &configuration.CloudMapConfig{Region: "us-west-2"}
+	cases := []struct {
+		conf     *configuration.Config
+		expNames []string
+		expErr   bool
+	}{
+		{
+			expErr: true,
+		},
+		{
+			conf:   &configuration.Config{ServiceRegistry: &configuration.ServiceRegistrySettings{}},
+			expErr: true,
+		},
+		{
+			conf: &configuration.Config{ServiceRegistry: &configuration.ServiceRegistrySettings{
+				GCPServiceDirectory: &configuration.ServiceDirectoryConfig{ProjectID: "my-project"},
+				AWSCloudMap:         cm,
+			}},
+			expErr: true,
+		},
+		{
+			conf: &configuration.Config{ServiceRegistry: &configuration.ServiceRegistrySettings{
+				GCPServiceDirectory: sd,
+			}},
+			expNames: []string{"servicedirectory"},
+		},
+		{
+			conf: &configuration.Config{ServiceRegistry: &configuration.ServiceRegistrySettings{
+				GCPServiceDirectory: sd,
+				AWSCloudMap:         cm,
+			}},
+			expNames: []string{"servicedirectory", "cloudmap"},
+		},
+		{
+			conf: &configuration.Config{ServiceRegistry: &configuration.ServiceRegistrySettings{
+				AWSCloudMap: cm,
+				Etcd: &configuration.EtcdConfig{
+					TLS: &configuration.EtcdTLSConfig{CertFile: "/path/to/client.crt"},
+				},
+			}},
+			expErr: true,
+		},
+		{
+			conf: &configuration.Config{ServiceRegistry: &configuration.ServiceRegistrySettings{
+				AWSCloudMap: cm,
+				Etcd:        &configuration.EtcdConfig{Endpoints: []string{"localhost:2379"}},
+			}},
+			expNames: []string{"cloudmap", "etcd"},
+		},
+	}
+
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+
+	for i, currCase := range cases {
+		res, err := getSources(cmd, currCase.conf)
+		if !a.Equal(currCase.expErr, err != nil) {
+			failed(i)
+		}
+
+		names := []string{}
+		for _, src := range res {
+			names = append(names, src.Name())
+		}
+		if len(currCase.expNames) > 0 && !a.Equal(currCase.expNames, names) {
+			failed(i)
+		}
+	}
+}
@@ -20,15 +20,14 @@ package etcd
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"os"
-	"os/signal"
-	"time"
 
 	opetcd "github.com/CloudNativeSDWAN/cnwan-operator/pkg/servregistry/etcd"
-	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/queue"
-	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/services"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/configuration"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/internal/utils"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/pipeline"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/shutdown"
 	"github.com/rs/zerolog"
 	"github.com/spf13/cobra"
 	clientv3 "go.etcd.io/etcd/client/v3"
@@ -57,93 +56,101 @@ func GetEtcdCommand() *cobra.Command {
 		Short:   etcdShort,
 		Long:    etcdLong,
 		Example: etcdExample,
+		Annotations: map[string]string{
+			configuration.SectionAnnotation: sectionPath,
+		},
 		PreRun: func(cmd *cobra.Command, _ []string) {
 			// Parse the flags
-			options, err := parseFlags(cmd)
+			options, err := parseFlags(cmd, configuration.GetConfigFile())
 			if err != nil {
 				log.Fatal().Err(err).Msg("error while parsing commands, check usage with --help")
 				return
 			}
 
-			// Get the etcd clients
-			cli, err := clientv3.New(getEtcdClientConfig(options))
+			watcher, err = newWatcher(cmd, options)
 			if err != nil {
 				log.Fatal().Err(err).Msg("error while establishing connection to etcd client")
 				return
 			}
-
-			sr := opetcd.NewServiceRegistryWithEtcd(context.Background(), cli, &options.Prefix)
-
-			watcher = &etcdWatcher{
-				options: options,
-				cli:     cli,
-				kv:      namespace.NewKV(cli.KV, options.Prefix),
-				watcher: namespace.NewWatcher(cli.Watcher, options.Prefix),
-				servreg: sr,
-			}
 		},
 		Run: func(cmd *cobra.Command, args []string) {
 
 			defer watcher.cli.Close()
 
 			// Get the adaptor endpoint
-			fromFlag, _ := cmd.Flags().GetString("adaptor-api")
-			adaptorEndpoint, err := sanitizeLocalhost(fromFlag, os.Getenv("MODE"))
+			adaptorEndpoint, err := utils.GetAdaptorEndpointFromFlags(cmd)
 			if err != nil {
-				log.Err(err).Str("adaptor-endpoint", fromFlag).Msg("adaptor endpoint doesn't seem valid")
+				log.Err(err).Msg("adaptor endpoint doesn't seem valid")
 				return
 			}
 
-			// Get create events
-			log.Info().Msg("getting current state of service registry from etcd...")
-			currStateCtx, currStateCanc := context.WithTimeout(context.Background(), time.Minute)
-			initialEvents, err := watcher.getCurrentState(currStateCtx, "create")
-			currStateCanc()
+			eventsVersion, err := utils.GetEventsVersionFromFlags(cmd)
 			if err != nil {
-				if errors.Is(err, context.DeadlineExceeded) {
-					log.Err(err).Int("seconds", 60).Msg("timeout expired while getting current state (did you specify the correct --endpoints ?)")
-					return
-				}
-
-				log.Err(err).Msg("error while retrieving current state from etcd")
+				log.Err(err).Msg("error while parsing events version")
 				return
 			}
 
-			ctx, canc := context.WithCancel(context.Background())
-			exitChan := make(chan bool)
+			flt, err := pipeline.GetFilterFromFlags(cmd)
+			if err != nil {
+				log.Err(err).Msg("error while parsing filters")
+				return
+			}
 
-			// Get the queue and send the events
-			servsHandler, err := services.NewHandler(ctx, adaptorEndpoint)
+			transformer, err := pipeline.GetTransformerFromConfig()
 			if err != nil {
-				log.Err(err).Msg("error while trying to connect to service directory")
-				canc()
+				log.Err(err).Msg("error while parsing transforms")
 				return
 			}
-			watcher.Queue = queue.New(ctx, servsHandler)
-			if len(initialEvents) > 0 {
-				go watcher.Enqueue(initialEvents)
+
+			queueOpts, err := pipeline.GetQueueOptionsFromFlags(cmd)
+			if err != nil {
+				log.Err(err).Msg("error while parsing delivery options")
+				return
 			}
 
-			go func() {
-				log.Info().Msg("watching for changes...")
-				watcher.Watch(ctx)
-				close(exitChan)
-			}()
+			shutdownOpts, err := pipeline.GetShutdownOptionsFromFlags(cmd)
+			if err != nil {
+				log.Err(err).Msg("error while parsing shutdown options")
+				return
+			}
 
-			// Graceful shutdown
-			sig := make(chan os.Signal, 1)
-			signal.Notify(sig, os.Interrupt)
+			electionOpts, err := pipeline.GetElectionOptionsFromFlags(cmd)
+			if err != nil {
+				log.Err(err).Msg("error while parsing leader election options")
+				return
+			}
 
-			<-sig
-			fmt.Println()
-			log.Info().Msg("exit requested")
+			reloadCtx, reloadCanc := context.WithCancel(context.Background())
+			defer reloadCanc()
+
+			opts := pipeline.Options{
+				Adaptor:       adaptorEndpoint,
+				EventsVersion: eventsVersion,
+				Filter:        flt,
+				Transformer:   transformer,
+				Queue:         queueOpts,
+				Shutdown:      shutdownOpts,
+				Election:      electionOpts,
+				Reload:        pipeline.WatchConfigFile(reloadCtx, cmd),
+			}
+			if electionOpts != nil && len(electionOpts.Endpoints) == 0 {
+				// Unless told otherwise, the election takes place in the
+				// same etcd cluster that is being watched.
+				opts.ElectionClient = watcher.cli
+			}
 
-			// Cancel the context and wait for objects that use it to receive
-			// the stop command
-			canc()
-			<-exitChan
+			exitCode, err := pipeline.Run([]pipeline.Source{watcher}, opts)
+			if err != nil {
+				log.Err(err).Msg("error while starting")
+				return
+			}
 
 			log.Info().Msg("good bye!")
+			if exitCode != shutdown.ExitOK {
+				// Deferred functions are not run by os.Exit
+				watcher.cli.Close()
+				os.Exit(exitCode)
+			}
 		},
 	}
 
@@ -156,6 +163,61 @@ func GetEtcdCommand() *cobra.Command {
 	cmd.Flags().String("password", "", "the password to use for this user")
 	cmd.Flags().String("prefix", "/", "the prefix to include for all objects")
 	cmd.Flags().StringSlice("metadata-keys", []string{}, "the metadata keys to look for")
+	cmd.Flags().String("tls-ca-file", "", "the path of the CA bundle to verify the etcd nodes with")
+	cmd.Flags().String("tls-cert-file", "", "the path of the client certificate to authenticate with")
+	cmd.Flags().String("tls-key-file", "", "the path of the key of the client certificate")
+	cmd.Flags().Bool("tls-insecure-skip-verify", false, "whether to skip the verification of the certificates of the etcd nodes")
+
+	// Fields of the configuration overridden by flags
+	for flag, path := range map[string]string{
+		"endpoints":                ".endpoints",
+		"username":                 ".credentials.username",
+		"password":                 ".credentials.password",
+		"prefix":                   ".prefix",
+		"metadata-keys":            "metadataKeys",
+		"tls-ca-file":              ".tls.caFile",
+		"tls-cert-file":            ".tls.certFile",
+		"tls-key-file":             ".tls.keyFile",
+		"tls-insecure-skip-verify": ".tls.insecureSkipVerify",
+	} {
+		configuration.BindFlag(cmd.Flags(), flag, path)
+	}
 
 	return cmd
 }
+
+// NewSource returns a source that watches etcd with the settings from the
+// provided command and configuration, to be run along with other sources.
+func NewSource(cmd *cobra.Command, conf *configuration.Config) (pipeline.Source, error) {
+	options, err := parseFlags(cmd, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return newWatcher(cmd, options)
+}
+
+func newWatcher(cmd *cobra.Command, options *Options) (*etcdWatcher, error) {
+	// Get the etcd clients
+	cfg, err := getEtcdClientConfig(options)
+	if err != nil {
+		return nil, err
+	}
+
+	cli, err := clientv3.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	sr := opetcd.NewServiceRegistryWithEtcd(context.Background(), cli, &options.Prefix)
+
+	return &etcdWatcher{
+		options: options,
+		cli:     cli,
+		kv:      namespace.NewKV(cli.KV, options.Prefix),
+		watcher: namespace.NewWatcher(cli.Watcher, options.Prefix),
+		servreg: sr,
+		cmd:     cmd,
+		reload:  make(chan *Options, 1),
+	}, nil
+}
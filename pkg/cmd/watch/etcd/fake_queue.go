@@ -18,12 +18,26 @@
 
 package etcd
 
-import "github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
+import (
+	"context"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/queue"
+)
 
 type fakeQ struct {
 	_enqueue func(map[string]*openapi.Event)
+	_close   func(context.Context) []queue.PendingEvent
 }
 
 func (f *fakeQ) Enqueue(m map[string]*openapi.Event) {
 	f._enqueue(m)
 }
+
+func (f *fakeQ) Close(ctx context.Context) []queue.PendingEvent {
+	if f._close == nil {
+		return []queue.PendingEvent{}
+	}
+
+	return f._close(ctx)
+}
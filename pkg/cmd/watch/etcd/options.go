@@ -18,6 +18,10 @@
 
 package etcd
 
+import (
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/services"
+)
+
 // Options contans data needed to connect to the etcd cluster correctly
 type Options struct {
 	// Endpoints is a list of hosts and ports where etcd nodes are running
@@ -26,10 +30,15 @@ type Options struct {
 	Credentials *Credentials `yaml:"credentials,omitempty"`
 	// Prefix where the service registry objects are stored
 	Prefix string `yaml:"prefix,omitempty"`
+	// TLS settings to connect to the cluster, if it requires TLS
+	TLS *TLS `yaml:"tls,omitempty"`
 
 	// targetKeys is a list of metadata keys to look for.
 	// This is not dervied from etcd's own flags, so we make it unexported.
 	targetKeys []string
+	// extraMetadata contains which metadata are included in events in
+	// addition to targetKeys.
+	extraMetadata *services.ExtraMetadata
 }
 
 // Endpoint is a container with host and port of an etcd node
@@ -48,3 +57,15 @@ type Credentials struct {
 	// Password for this username
 	Password string `yaml:"password,omitempty"`
 }
+
+// TLS is a container with the files to use to connect to etcd through TLS
+type TLS struct {
+	// CAFile is the path of the CA bundle to verify the etcd nodes with
+	CAFile string `yaml:"caFile,omitempty"`
+	// CertFile is the path of the client certificate
+	CertFile string `yaml:"certFile,omitempty"`
+	// KeyFile is the path of the key of the client certificate
+	KeyFile string `yaml:"keyFile,omitempty"`
+	// InsecureSkipVerify skips the verification of the etcd nodes
+	InsecureSkipVerify bool `yaml:"insecureSkipVerify,omitempty"`
+}
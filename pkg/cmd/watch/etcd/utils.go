@@ -26,8 +26,12 @@ import (
 
 	opsr "github.com/CloudNativeSDWAN/cnwan-operator/pkg/servregistry"
 	opetcd "github.com/CloudNativeSDWAN/cnwan-operator/pkg/servregistry/etcd"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/configuration"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/internal/utils"
 	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/pipeline"
 	"github.com/spf13/cobra"
+	"go.etcd.io/etcd/client/pkg/v3/transport"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"gopkg.in/yaml.v3"
 )
@@ -99,28 +103,37 @@ func parseEndpointsFromFlags(endpoints []string) (parsed []Endpoint) {
 	return parsed
 }
 
-func parseFlags(cmd *cobra.Command) (*Options, error) {
+func parseFlags(cmd *cobra.Command, conf *configuration.Config) (*Options, error) {
 	opts := &Options{}
 
-	endpoints, _ := cmd.Flags().GetStringSlice("endpoints")
-	opts.Endpoints = parseEndpointsFromFlags(endpoints)
+	resolved, _ := ResolveConfig(cmd, conf)
+	etcdConf := resolved.ServiceRegistry.Etcd
+	opts.Endpoints = parseEndpointsFromFlags(etcdConf.Endpoints)
 
-	keys := []string{}
-	_keys, _ := cmd.Flags().GetStringSlice("metadata-keys")
-	switch l := len(_keys); {
-	case l == 0:
-		return nil, fmt.Errorf("no metadata keys provided")
-	case l > 1:
-		log.Warn().Msg("multiple metadata keys are not supported yet, only the first one will be used")
-		fallthrough
-	default:
-		keys = append(keys, _keys[0])
+	keys, err := utils.GetMetadataKeysFromCmdFlags(cmd)
+	if err != nil {
+		return nil, err
 	}
 
-	opts.targetKeys = keys
+	// The keys are the ones services have after being transformed, while
+	// the service registry must look for their original names.
+	transformer, err := pipeline.GetTransformerFromConfig()
+	if err != nil {
+		return nil, err
+	}
+	opts.targetKeys = transformer.SourceKeys(sourceName, keys)
 
-	username, _ := cmd.Flags().GetString("username")
-	password, _ := cmd.Flags().GetString("password")
+	extraMetadata := utils.GetExtraMetadataFromFlags(cmd)
+	if extraMetadata != nil {
+		extraMetadata.Include = transformer.SourceKeys(sourceName, extraMetadata.Include)
+		extraMetadata.Exclude = transformer.SourceKeys(sourceName, extraMetadata.Exclude)
+	}
+	opts.extraMetadata = extraMetadata
+
+	username, password := "", ""
+	if etcdConf.Credentials != nil {
+		username, password = etcdConf.Credentials.Username, etcdConf.Credentials.Password
+	}
 
 	if len(username) > 0 && len(password) > 0 {
 		opts.Credentials = &Credentials{Username: username, Password: password}
@@ -133,13 +146,115 @@ func parseFlags(cmd *cobra.Command) (*Options, error) {
 		}
 	}
 
-	prefix, _ := cmd.Flags().GetString("prefix")
-	opts.Prefix = parsePrefix(prefix)
+	opts.Prefix = parsePrefix(etcdConf.Prefix)
+
+	if etcdConf.TLS != nil {
+		tlsOpts := &TLS{
+			CAFile:             etcdConf.TLS.CAFile,
+			CertFile:           etcdConf.TLS.CertFile,
+			KeyFile:            etcdConf.TLS.KeyFile,
+			InsecureSkipVerify: etcdConf.TLS.InsecureSkipVerify,
+		}
+
+		switch {
+		case len(tlsOpts.CertFile) > 0 && len(tlsOpts.KeyFile) == 0:
+			return nil, fmt.Errorf("tls cert file set but no key file provided")
+		case len(tlsOpts.CertFile) == 0 && len(tlsOpts.KeyFile) > 0:
+			return nil, fmt.Errorf("tls key file set but no cert file provided")
+		}
+		opts.TLS = tlsOpts
+	}
 
 	return opts, nil
 }
 
-func getEtcdClientConfig(opts *Options) clientv3.Config {
+// ResolveConfig returns the configuration with only the etcd section used
+// by the command, resolved from its flags and from conf, and where the
+// value of each of its fields comes from.
+//
+// Flags take precedence over the configuration file when they are
+// provided, even if empty.
+func ResolveConfig(cmd *cobra.Command, conf *configuration.Config) (*configuration.Config, map[string]configuration.Origin) {
+	etcdConf := &configuration.EtcdConfig{}
+	if conf != nil && conf.ServiceRegistry != nil && conf.ServiceRegistry.Etcd != nil {
+		etcdConf = conf.ServiceRegistry.Etcd
+	}
+	res := &configuration.EtcdConfig{}
+	origins := configuration.NewSectionOrigins(sectionPath)
+
+	res.Endpoints, _ = cmd.Flags().GetStringSlice("endpoints")
+	switch {
+	case cmd.Flags().Changed("endpoints"):
+		origins.Flag("endpoints")
+	case len(etcdConf.Endpoints) > 0:
+		res.Endpoints = etcdConf.Endpoints
+		origins.Conf("endpoints")
+	case len(res.Endpoints) > 0:
+		origins.Default("endpoints")
+	}
+
+	stringFlagOrConf := func(flag, path, fromConf string) string {
+		if cmd.Flags().Changed(flag) {
+			origins.Flag(path)
+			val, _ := cmd.Flags().GetString(flag)
+			return val
+		}
+
+		if len(fromConf) > 0 {
+			origins.Conf(path)
+		}
+		return fromConf
+	}
+
+	credsConf := etcdConf.Credentials
+	if credsConf == nil {
+		credsConf = &configuration.EtcdCredentials{}
+	}
+	creds := &configuration.EtcdCredentials{
+		Username: stringFlagOrConf("username", "credentials.username", credsConf.Username),
+		Password: stringFlagOrConf("password", "credentials.password", credsConf.Password),
+	}
+	if *creds != (configuration.EtcdCredentials{}) {
+		res.Credentials = creds
+	}
+
+	res.Prefix, _ = cmd.Flags().GetString("prefix")
+	switch {
+	case cmd.Flags().Changed("prefix"):
+		origins.Flag("prefix")
+	case len(etcdConf.Prefix) > 0:
+		res.Prefix = etcdConf.Prefix
+		origins.Conf("prefix")
+	case len(res.Prefix) > 0:
+		origins.Default("prefix")
+	}
+
+	tlsConf := etcdConf.TLS
+	if tlsConf == nil {
+		tlsConf = &configuration.EtcdTLSConfig{}
+	}
+	tls := &configuration.EtcdTLSConfig{
+		CAFile:             stringFlagOrConf("tls-ca-file", "tls.caFile", tlsConf.CAFile),
+		CertFile:           stringFlagOrConf("tls-cert-file", "tls.certFile", tlsConf.CertFile),
+		KeyFile:            stringFlagOrConf("tls-key-file", "tls.keyFile", tlsConf.KeyFile),
+		InsecureSkipVerify: tlsConf.InsecureSkipVerify,
+	}
+	if cmd.Flags().Changed("tls-insecure-skip-verify") {
+		tls.InsecureSkipVerify, _ = cmd.Flags().GetBool("tls-insecure-skip-verify")
+		origins.Flag("tls.insecureSkipVerify")
+	} else if tls.InsecureSkipVerify {
+		origins.Conf("tls.insecureSkipVerify")
+	}
+	if *tls != (configuration.EtcdTLSConfig{}) {
+		res.TLS = tls
+	}
+
+	return &configuration.Config{
+		ServiceRegistry: &configuration.ServiceRegistrySettings{Etcd: res},
+	}, origins.Origins()
+}
+
+func getEtcdClientConfig(opts *Options) (clientv3.Config, error) {
 	endps := []string{}
 
 	for _, endp := range opts.Endpoints {
@@ -154,8 +269,22 @@ func getEtcdClientConfig(opts *Options) clientv3.Config {
 		cfg.Password = opts.Credentials.Password
 	}
 
-	// TODO: support for TLS authentication
-	return cfg
+	if opts.TLS != nil {
+		tlsInfo := transport.TLSInfo{
+			TrustedCAFile:      opts.TLS.CAFile,
+			CertFile:           opts.TLS.CertFile,
+			KeyFile:            opts.TLS.KeyFile,
+			InsecureSkipVerify: opts.TLS.InsecureSkipVerify,
+		}
+
+		tlsConfig, err := tlsInfo.ClientConfig()
+		if err != nil {
+			return clientv3.Config{}, fmt.Errorf("error while loading tls files: %w", err)
+		}
+		cfg.TLS = tlsConfig
+	}
+
+	return cfg, nil
 }
 
 func parsePrefix(prefix string) string {
@@ -168,6 +297,17 @@ func parsePrefix(prefix string) string {
 	return fmt.Sprintf("/%s/", pref)
 }
 
+// servicesFromEvents returns the services included in the events.
+func servicesFromEvents(events map[string]*openapi.Event) map[string]*openapi.Service {
+	servs := make(map[string]*openapi.Service, len(events))
+	for key, ev := range events {
+		serv := ev.Service
+		servs[key] = &serv
+	}
+
+	return servs
+}
+
 func validateEndpointFromEtcd(bytesVal []byte) (*opsr.Endpoint, error) {
 	if len(bytesVal) == 0 {
 		return nil, fmt.Errorf("no value provided")
@@ -193,22 +333,16 @@ func validateEndpointFromEtcd(bytesVal []byte) (*opsr.Endpoint, error) {
 	return &endp, nil
 }
 
-func createOpenapiEvent(endp *opsr.Endpoint, srv *opsr.Service, eventType string) *openapi.Event {
-	event := openapi.Event{
+func createOpenapiEvent(endp *opsr.Endpoint, metadata []openapi.Metadata, eventType string) *openapi.Event {
+	return &openapi.Event{
 		Event: eventType,
 		Service: openapi.Service{
 			Name:     endp.Name,
 			Address:  endp.Address,
 			Port:     endp.Port,
-			Metadata: []openapi.Metadata{},
+			Metadata: metadata,
 		},
 	}
-
-	for mtdKey, mtdVal := range srv.Metadata {
-		event.Service.Metadata = append(event.Service.Metadata, openapi.Metadata{Key: mtdKey, Value: mtdVal})
-	}
-
-	return &event
 }
 
 func mapContainsKeys(subject map[string]string, targets []string) bool {
@@ -247,6 +381,20 @@ func mapValuesChanged(now, prev map[string]string, keys []string) bool {
 	return false
 }
 
+// mapKeysChanged returns true if any of the provided keys is in only one
+// of the two maps.
+func mapKeysChanged(now, prev map[string]string, keys []string) bool {
+	for _, key := range keys {
+		_, nowExists := now[key]
+		_, prevExists := prev[key]
+		if nowExists != prevExists {
+			return true
+		}
+	}
+
+	return false
+}
+
 func validateServiceFromEtcd(val []byte) (*opsr.Service, error) {
 	if len(val) == 0 {
 		return nil, fmt.Errorf("no value provided")
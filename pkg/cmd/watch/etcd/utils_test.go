@@ -23,7 +23,9 @@ import (
 	"testing"
 
 	opsr "github.com/CloudNativeSDWAN/cnwan-operator/pkg/servregistry"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/configuration"
 	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/services"
 	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/yaml.v2"
@@ -123,8 +125,34 @@ func TestParseEndpointsFromFlags(t *testing.T) {
 func TestParseFlags(t *testing.T) {
 	a := assert.New(t)
 
+	newCmd := func(args ...string) *cobra.Command {
+		c := GetEtcdCommand()
+		c.SetArgs(args)
+		c.PreRun = func(*cobra.Command, []string) {}
+		c.Run = func(*cobra.Command, []string) {}
+		c.Execute()
+		return c
+	}
+	etcdConf := &configuration.Config{
+		ServiceRegistry: &configuration.ServiceRegistrySettings{
+			Etcd: &configuration.EtcdConfig{
+				Endpoints: []string{"example.com:5544"},
+				Credentials: &configuration.EtcdCredentials{
+					Username: "user", Password: "pass",
+				},
+				Prefix: "service-registry",
+				TLS: &configuration.EtcdTLSConfig{
+					CAFile:   "/path/to/ca.crt",
+					CertFile: "/path/to/client.crt",
+					KeyFile:  "/path/to/client.key",
+				},
+			},
+		},
+	}
+
 	cases := []struct {
 		cmd    *cobra.Command
+		conf   *configuration.Config
 		expRes *Options
 		expErr error
 	}{
@@ -194,6 +222,75 @@ func TestParseFlags(t *testing.T) {
 				targetKeys: []string{"whatever"},
 			},
 		},
+		{
+			cmd:  newCmd("--metadata-keys=whatever"),
+			conf: etcdConf,
+			expRes: &Options{
+				Endpoints:   []Endpoint{{Host: "example.com", Port: 5544}},
+				Prefix:      "/service-registry/",
+				Credentials: &Credentials{Username: "user", Password: "pass"},
+				TLS: &TLS{
+					CAFile:   "/path/to/ca.crt",
+					CertFile: "/path/to/client.crt",
+					KeyFile:  "/path/to/client.key",
+				},
+				targetKeys: []string{"whatever"},
+			},
+		},
+		{
+			cmd: newCmd(
+				"--metadata-keys=whatever",
+				"--endpoints=localhost:3344",
+				"--username=other",
+				"--prefix=/",
+				"--tls-ca-file=/path/to/other.crt",
+				"--tls-insecure-skip-verify",
+			),
+			conf: etcdConf,
+			expRes: &Options{
+				Endpoints:   []Endpoint{{Host: "localhost", Port: 3344}},
+				Prefix:      "/",
+				Credentials: &Credentials{Username: "other", Password: "pass"},
+				TLS: &TLS{
+					CAFile:             "/path/to/other.crt",
+					CertFile:           "/path/to/client.crt",
+					KeyFile:            "/path/to/client.key",
+					InsecureSkipVerify: true,
+				},
+				targetKeys: []string{"whatever"},
+			},
+		},
+		{
+			// Empty flags override the configuration file as well
+			cmd:  newCmd("--metadata-keys=whatever", "--username=", "--password=", "--tls-ca-file="),
+			conf: etcdConf,
+			expRes: &Options{
+				Endpoints: []Endpoint{{Host: "example.com", Port: 5544}},
+				Prefix:    "/service-registry/",
+				TLS: &TLS{
+					CertFile: "/path/to/client.crt",
+					KeyFile:  "/path/to/client.key",
+				},
+				targetKeys: []string{"whatever"},
+			},
+		},
+		{
+			cmd:    newCmd("--metadata-keys=whatever", "--tls-cert-file=/path/to/client.crt"),
+			expErr: fmt.Errorf("tls cert file set but no key file provided"),
+		},
+		{
+			cmd:    newCmd("--metadata-keys=whatever", "--tls-key-file=/path/to/client.key"),
+			expErr: fmt.Errorf("tls key file set but no cert file provided"),
+		},
+		{
+			cmd: newCmd("--metadata-keys=whatever", "--tls-insecure-skip-verify"),
+			expRes: &Options{
+				Endpoints:  []Endpoint{{Host: defaultHost, Port: defaultPort}},
+				Prefix:     "/",
+				TLS:        &TLS{InsecureSkipVerify: true},
+				targetKeys: []string{"whatever"},
+			},
+		},
 	}
 
 	failed := func(i int) {
@@ -201,7 +298,7 @@ func TestParseFlags(t *testing.T) {
 	}
 
 	for i, currCase := range cases {
-		res, err := parseFlags(currCase.cmd)
+		res, err := parseFlags(currCase.cmd, currCase.conf)
 		er := currCase.expRes
 		if !a.Equal(currCase.expErr, err) {
 			failed(i)
@@ -213,6 +310,29 @@ func TestParseFlags(t *testing.T) {
 	}
 }
 
+func TestGetEtcdClientConfig(t *testing.T) {
+	a := assert.New(t)
+
+	res, err := getEtcdClientConfig(&Options{
+		Endpoints:   []Endpoint{{Host: "localhost", Port: 3344}, {Host: "example.com", Port: 5544}},
+		Credentials: &Credentials{Username: "user", Password: "pass"},
+	})
+	a.NoError(err)
+	a.Equal([]string{"localhost:3344", "example.com:5544"}, res.Endpoints)
+	a.Equal("user", res.Username)
+	a.Equal("pass", res.Password)
+	a.Nil(res.TLS)
+
+	res, err = getEtcdClientConfig(&Options{TLS: &TLS{InsecureSkipVerify: true}})
+	a.NoError(err)
+	if a.NotNil(res.TLS) {
+		a.True(res.TLS.InsecureSkipVerify)
+	}
+
+	_, err = getEtcdClientConfig(&Options{TLS: &TLS{CAFile: "/does/not/exist.crt"}})
+	a.Error(err)
+}
+
 func TestParsePrefix(t *testing.T) {
 	a := assert.New(t)
 	empty := ""
@@ -391,7 +511,7 @@ func TestCreateOpenapiEvent(t *testing.T) {
 		},
 	}
 
-	res := createOpenapiEvent(endp, srv, "create")
+	res := createOpenapiEvent(endp, (&services.ExtraMetadata{All: true}).Select(srv.Metadata, nil), "create")
 	a.Equal(expRes, res)
 }
 
@@ -431,6 +551,50 @@ func TestMapContainsKeys(t *testing.T) {
 	}
 }
 
+func TestMapKeysChanged(t *testing.T) {
+	a := assert.New(t)
+	prev := map[string]string{
+		"key1": "val1",
+		"key2": "val2",
+	}
+	cases := []struct {
+		now     map[string]string
+		targets []string
+		expRes  bool
+	}{
+		{
+			now:     map[string]string{"key1": "new", "key2": "new"},
+			targets: []string{"key1", "key2"},
+			expRes:  false,
+		},
+		{
+			now:     map[string]string{"key1": "val1"},
+			targets: []string{"key1", "key2"},
+			expRes:  true,
+		},
+		{
+			now:     map[string]string{"key1": "val1", "key2": "val2", "key3": "val3"},
+			targets: []string{"key3"},
+			expRes:  true,
+		},
+		{
+			now:     map[string]string{"key1": "val1", "key3": "val3"},
+			targets: []string{"key1"},
+			expRes:  false,
+		},
+	}
+
+	fail := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+	for i, currCase := range cases {
+		res := mapKeysChanged(currCase.now, prev, currCase.targets)
+		if !a.Equal(currCase.expRes, res) {
+			fail(i)
+		}
+	}
+}
+
 func TestMapValuesChanged(t *testing.T) {
 	a := assert.New(t)
 	prev := map[string]string{
@@ -18,6 +18,8 @@
 
 package etcd
 
+import "time"
+
 const (
 	etcdUse   string = "etcd [flags]"
 	etcdShort string = "watch for changes in etcd"
@@ -38,4 +40,16 @@ permissions for the cnwan-reader to do its job: it must have read access to this
 
 	defaultPort int32  = 2379
 	defaultHost string = "localhost"
+
+	// currentStateTimeout is how long to wait for the current state of
+	// etcd to be retrieved before giving up.
+	currentStateTimeout time.Duration = time.Minute
+
+	// sourceName is the name of the service registry included in the
+	// events detected by this command.
+	sourceName string = "etcd"
+
+	// sectionPath is the path of the section of the configuration read by
+	// this command.
+	sectionPath string = "serviceRegistry.etcd"
 )
@@ -28,9 +28,13 @@ import (
 	opsr "github.com/CloudNativeSDWAN/cnwan-operator/pkg/servregistry"
 	"github.com/CloudNativeSDWAN/cnwan-operator/pkg/servregistry/etcd"
 	opetcd "github.com/CloudNativeSDWAN/cnwan-operator/pkg/servregistry/etcd"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/configuration"
 	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
 	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/queue"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/services"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/spf13/cobra"
 	"go.etcd.io/etcd/api/v3/mvccpb"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"gopkg.in/yaml.v3"
@@ -44,6 +48,104 @@ type etcdWatcher struct {
 	watcher clientv3.Watcher
 	queue.Queue
 	servreg opsr.ServiceRegistry
+	// elected receives a value every time this replica becomes the leader,
+	// if leader election is enabled.
+	elected <-chan struct{}
+	// cmd is used to parse the configuration again when it changes.
+	cmd *cobra.Command
+	// reload receives the new options with the metadata keys to look for
+	// and the metadata to include.
+	reload chan *Options
+}
+
+// Name returns the name of the source.
+func (e *etcdWatcher) Name() string {
+	return sourceName
+}
+
+// Client returns the etcd client used to watch etcd, so that it can be
+// used for the leader election as well.
+func (e *etcdWatcher) Client() *clientv3.Client {
+	return e.cli
+}
+
+// Reload applies the new metadata keys and metadata to include, sending
+// delete and create events for the endpoints that leave or enter the
+// filter, respectively, and update events for the ones whose metadata
+// change. All other settings require a restart.
+func (e *etcdWatcher) Reload(conf *configuration.Config) error {
+	opts, err := parseFlags(e.cmd, conf)
+	if err != nil {
+		return err
+	}
+
+	if !cmp.Equal(opts, e.options, cmpopts.IgnoreUnexported(Options{})) {
+		log.Warn().Msg("changes to the connection to etcd are only applied after a restart")
+	}
+
+	// Only the latest keys matter
+	select {
+	case <-e.reload:
+	default:
+	}
+	e.reload <- opts
+	return nil
+}
+
+// applyKeys sends the changes caused by looking for the metadata keys and
+// including the metadata of next instead of the current ones.
+func (e *etcdWatcher) applyKeys(ctx context.Context, next *Options) {
+	prev, err := e.getCurrentState(ctx, "create")
+	if err != nil {
+		log.Err(err).Msg("error while retrieving current state from etcd, keeping the current metadata keys")
+		return
+	}
+
+	prevKeys, prevExtra := e.options.targetKeys, e.options.extraMetadata
+	e.options.targetKeys, e.options.extraMetadata = next.targetKeys, next.extraMetadata
+	curr, err := e.getCurrentState(ctx, "create")
+	if err != nil {
+		log.Err(err).Msg("error while retrieving current state from etcd, keeping the current metadata keys")
+		e.options.targetKeys, e.options.extraMetadata = prevKeys, prevExtra
+		return
+	}
+
+	datastore := services.NewDatastore()
+	datastore.GetEvents(servicesFromEvents(prev))
+	changes := datastore.GetEvents(servicesFromEvents(curr))
+	log.Info().Strs("metadata-keys", next.targetKeys).Int("changes", len(changes)).Msg("metadata keys changed")
+
+	if e.Queue != nil && len(changes) > 0 {
+		services.StampEvents(changes, sourceName)
+		e.Queue.Enqueue(changes)
+	}
+}
+
+// Run sends the current state of etcd and then watches for changes until
+// ctx is done.
+func (e *etcdWatcher) Run(ctx context.Context, q queue.Queue, resync <-chan struct{}) error {
+	e.Queue, e.elected = q, resync
+
+	log.Info().Msg("getting current state of service registry from etcd...")
+	currStateCtx, currStateCanc := context.WithTimeout(ctx, currentStateTimeout)
+	initialEvents, err := e.getCurrentState(currStateCtx, "create")
+	currStateCanc()
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("timeout expired while getting current state (did you specify the correct --endpoints ?): %w", err)
+		}
+
+		return fmt.Errorf("error while retrieving current state from etcd: %w", err)
+	}
+
+	if len(initialEvents) > 0 {
+		services.StampEvents(initialEvents, sourceName)
+		e.Enqueue(initialEvents)
+	}
+
+	log.Info().Msg("watching for changes...")
+	e.Watch(ctx)
+	return nil
 }
 
 func (e *etcdWatcher) Watch(ctx context.Context) {
@@ -51,8 +153,29 @@ func (e *etcdWatcher) Watch(ctx context.Context) {
 	wchan := e.watcher.Watch(ctx, "", clientv3.WithPrefix(), clientv3.WithPrevKV())
 	defer e.watcher.Close()
 
-	for wresp := range wchan {
+	// Changes up to this revision are already included in the last resync
+	resyncRev := int64(0)
+
+	for {
+		var wresp clientv3.WatchResponse
+		select {
+		case <-e.elected:
+			resyncRev = e.resync(ctx)
+			continue
+		case next := <-e.reload:
+			e.applyKeys(ctx, next)
+			continue
+		case _wresp, ok := <-wchan:
+			if !ok {
+				return
+			}
+			wresp = _wresp
+		}
+
 		for _, ev := range wresp.Events {
+			if ev.Kv.ModRevision <= resyncRev {
+				continue
+			}
 
 			key := opetcd.KeyFromString(string(ev.Kv.Key))
 			var eventsToSend map[string]*openapi.Event
@@ -88,12 +211,35 @@ func (e *etcdWatcher) Watch(ctx context.Context) {
 			}
 
 			if e.Queue != nil && len(eventsToSend) > 0 {
-				go e.Queue.Enqueue(eventsToSend)
+				for _, evToSend := range eventsToSend {
+					evToSend.Revision = ev.Kv.ModRevision
+				}
+				services.StampEvents(eventsToSend, sourceName)
+				e.Queue.Enqueue(eventsToSend)
 			}
 		}
 	}
 }
 
+// resync sends the whole current state, as events may have been missed
+// while this replica was a follower. It returns the revision of the state
+// that was sent, or 0 in case of errors.
+func (e *etcdWatcher) resync(ctx context.Context) int64 {
+	log.Info().Msg("elected as leader: sending current state of service registry...")
+	events, rev, err := e.getCurrentStateAndRevision(ctx, "create")
+	if err != nil {
+		log.Err(err).Msg("error while retrieving current state from etcd")
+		return 0
+	}
+
+	if e.Queue != nil && len(events) > 0 {
+		services.StampEvents(events, sourceName)
+		e.Queue.Enqueue(events)
+	}
+
+	return rev
+}
+
 func (e *etcdWatcher) parseEndpointAndCreateEvent(kvpair *mvccpb.KeyValue, eventName string) (*openapi.Event, error) {
 	key := opetcd.KeyFromString(string(kvpair.Key))
 	l := log.With().Str("key", key.String()).Str("event", eventName).Logger()
@@ -124,10 +270,16 @@ func (e *etcdWatcher) parseEndpointAndCreateEvent(kvpair *mvccpb.KeyValue, event
 		return nil, nil
 	}
 
-	event := createOpenapiEvent(endp, srv, eventName)
+	event := createOpenapiEvent(endp, e.selectMetadata(srv.Metadata), eventName)
 	return event, nil
 }
 
+// selectMetadata returns the metadata of a service to include in its
+// events.
+func (e *etcdWatcher) selectMetadata(metadata map[string]string) []openapi.Metadata {
+	return e.options.extraMetadata.Select(metadata, e.options.targetKeys)
+}
+
 func (e *etcdWatcher) getServiceBeforeDelete(name string) (*opsr.Service, error) {
 	// First, we need to get the revision (WithPrevKV does not work here)
 	resp, err := e.kv.Get(context.Background(), name, clientv3.WithCountOnly())
@@ -201,12 +353,7 @@ func (e *etcdWatcher) parseEndpointChange(now, prev *mvccpb.KeyValue) (*openapi.
 		return nil, nil
 	}
 
-	// TODO: on future versions, this will be removed, in favor of a
-	// simple map[string]string, the ones used by the operator
-	parsedMetadata := []openapi.Metadata{}
-	for key, val := range srv.Metadata {
-		parsedMetadata = append(parsedMetadata, openapi.Metadata{Key: key, Value: val})
-	}
+	parsedMetadata := e.selectMetadata(srv.Metadata)
 
 	// It is not valid now
 	if parsedNow == nil {
@@ -284,18 +431,6 @@ func (e *etcdWatcher) parseServiceChange(now, prev *mvccpb.KeyValue) (map[string
 		return nil, nil
 	}
 
-	parsedMetadata := []openapi.Metadata{}
-	metadata := func() map[string]string {
-		if parsedNow != nil {
-			return parsedNow.Metadata
-		}
-
-		return parsedPrev.Metadata
-	}()
-	for key, val := range metadata {
-		parsedMetadata = append(parsedMetadata, openapi.Metadata{Key: key, Value: val})
-	}
-
 	hadTarget := parsedPrev != nil && mapContainsKeys(parsedPrev.Metadata, e.options.targetKeys)
 	hasTarget := parsedNow != nil && mapContainsKeys(parsedNow.Metadata, e.options.targetKeys)
 	srv := parsedNow
@@ -314,7 +449,8 @@ func (e *etcdWatcher) parseServiceChange(now, prev *mvccpb.KeyValue) (map[string
 			log.Info().Msg("service now has target keys")
 			event = "create"
 		} else {
-			if !mapValuesChanged(parsedNow.Metadata, parsedPrev.Metadata, e.options.targetKeys) {
+			tracked := e.options.extraMetadata.TrackedKeys(e.options.targetKeys)
+			if !mapValuesChanged(parsedNow.Metadata, parsedPrev.Metadata, tracked) && !mapKeysChanged(parsedNow.Metadata, parsedPrev.Metadata, tracked) {
 				log.Info().Msg("no relevant changes found, skipping...")
 				return nil, nil
 			}
@@ -332,20 +468,28 @@ func (e *etcdWatcher) parseServiceChange(now, prev *mvccpb.KeyValue) (map[string
 	events := map[string]*openapi.Event{}
 	for _, endp := range endpList {
 		key := opetcd.KeyFromNames(endp.NsName, endp.ServName, endp.Name)
-		events[key.String()] = createOpenapiEvent(endp, srv, event)
+		events[key.String()] = createOpenapiEvent(endp, e.selectMetadata(srv.Metadata), event)
 	}
 
 	return events, nil
 }
 
 func (e *etcdWatcher) getCurrentState(ctx context.Context, event string) (map[string]*openapi.Event, error) {
+	events, _, err := e.getCurrentStateAndRevision(ctx, event)
+	return events, err
+}
+
+// getCurrentStateAndRevision is the same as getCurrentState, but also
+// returns the revision of etcd when the state was retrieved.
+func (e *etcdWatcher) getCurrentStateAndRevision(ctx context.Context, event string) (map[string]*openapi.Event, int64, error) {
 	resp, err := e.kv.Get(ctx, "namespaces", clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	servs := map[string]*opsr.Service{}
 	servsEndps := map[string][]*opsr.Endpoint{}
+	endpsRevs := map[*opsr.Endpoint]int64{}
 
 	for _, resp := range resp.Kvs {
 		key := opetcd.KeyFromString(string(resp.Key))
@@ -384,6 +528,7 @@ func (e *etcdWatcher) getCurrentState(ctx context.Context, event string) (map[st
 
 			srvKey := opetcd.KeyFromNames(endp.NsName, endp.ServName)
 			servsEndps[srvKey.String()] = append(servsEndps[srvKey.String()], &endp)
+			endpsRevs[&endp] = resp.ModRevision
 		}
 	}
 
@@ -397,7 +542,8 @@ func (e *etcdWatcher) getCurrentState(ctx context.Context, event string) (map[st
 
 		for _, endp := range endpList {
 			ev := openapi.Event{
-				Event: event,
+				Event:    event,
+				Revision: endpsRevs[endp],
 				Service: openapi.Service{
 					Name:    endp.Name,
 					Address: endp.Address,
@@ -405,16 +551,12 @@ func (e *etcdWatcher) getCurrentState(ctx context.Context, event string) (map[st
 				},
 			}
 
-			metadataList := []openapi.Metadata{}
-			for key, val := range srv.Metadata {
-				metadataList = append(metadataList, openapi.Metadata{Key: key, Value: val})
-			}
-			ev.Service.Metadata = metadataList
+			ev.Service.Metadata = e.selectMetadata(srv.Metadata)
 
 			evKey := fmt.Sprintf("%s:%d", endp.Address, endp.Port)
 			events[evKey] = &ev
 		}
 	}
 
-	return events, nil
+	return events, resp.Header.GetRevision(), nil
 }
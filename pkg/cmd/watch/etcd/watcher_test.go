@@ -26,6 +26,7 @@ import (
 	opsr "github.com/CloudNativeSDWAN/cnwan-operator/pkg/servregistry"
 	opetcd "github.com/CloudNativeSDWAN/cnwan-operator/pkg/servregistry/etcd"
 	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/services"
 	"github.com/stretchr/testify/assert"
 	"go.etcd.io/etcd/api/v3/mvccpb"
 	clientv3 "go.etcd.io/etcd/client/v3"
@@ -841,3 +842,67 @@ func TestParseServiceChange(t *testing.T) {
 		}
 	}
 }
+
+func TestApplyKeys(t *testing.T) {
+	a := assert.New(t)
+	srvOne := opsr.Service{Name: "one", NsName: "ns", Metadata: map[string]string{"one": "yes"}}
+	srvTwo := opsr.Service{Name: "two", NsName: "ns", Metadata: map[string]string{"two": "yes", "site": "eu-west"}}
+	epOne := opsr.Endpoint{Name: "ep", ServName: "one", NsName: "ns", Address: "10.10.10.10", Port: 80}
+	epTwo := opsr.Endpoint{Name: "ep", ServName: "two", NsName: "ns", Address: "10.10.10.11", Port: 80}
+	kvs := []*mvccpb.KeyValue{}
+	for _, obj := range []struct {
+		key *opetcd.KeyBuilder
+		val interface{}
+	}{
+		{opetcd.KeyFromNames(srvOne.NsName, srvOne.Name), srvOne},
+		{opetcd.KeyFromNames(srvTwo.NsName, srvTwo.Name), srvTwo},
+		{opetcd.KeyFromNames(epOne.NsName, epOne.ServName, epOne.Name), epOne},
+		{opetcd.KeyFromNames(epTwo.NsName, epTwo.ServName, epTwo.Name), epTwo},
+	} {
+		val, _ := yaml.Marshal(obj.val)
+		kvs = append(kvs, &mvccpb.KeyValue{Key: []byte(obj.key.String()), Value: val})
+	}
+
+	getErr := error(nil)
+	enqueued := map[string]*openapi.Event{}
+	e := &etcdWatcher{
+		kv: &fakeKV{
+			_get: func(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+				if getErr != nil {
+					return nil, getErr
+				}
+				return &clientv3.GetResponse{Kvs: kvs}, nil
+			},
+		},
+		options: &Options{targetKeys: []string{"one"}},
+		Queue: &fakeQ{_enqueue: func(m map[string]*openapi.Event) {
+			for k, v := range m {
+				enqueued[k] = v
+			}
+		}},
+	}
+
+	// Errors keep the current keys
+	getErr = fmt.Errorf("any error")
+	e.applyKeys(context.Background(), &Options{targetKeys: []string{"two"}})
+	a.Equal([]string{"one"}, e.options.targetKeys)
+	a.Empty(enqueued)
+
+	getErr = nil
+	e.applyKeys(context.Background(), &Options{targetKeys: []string{"two"}})
+	a.Equal([]string{"two"}, e.options.targetKeys)
+	if a.Len(enqueued, 2) {
+		a.Equal("delete", enqueued["10.10.10.10:80"].Event)
+		a.Equal("create", enqueued["10.10.10.11:80"].Event)
+		a.Equal(sourceName, enqueued["10.10.10.11:80"].Source)
+		a.Equal([]openapi.Metadata{{Key: "two", Value: "yes"}}, enqueued["10.10.10.11:80"].Service.Metadata)
+	}
+
+	// Including other metadata updates the services that have them
+	enqueued = map[string]*openapi.Event{}
+	e.applyKeys(context.Background(), &Options{targetKeys: []string{"two"}, extraMetadata: &services.ExtraMetadata{Include: []string{"site"}}})
+	if a.Len(enqueued, 1) {
+		a.Equal("update", enqueued["10.10.10.11:80"].Event)
+		a.Equal([]openapi.Metadata{{Key: "two", Value: "yes"}, {Key: "site", Value: "eu-west"}}, enqueued["10.10.10.11:80"].Service.Metadata)
+	}
+}
@@ -19,45 +19,87 @@
 package configuration
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v2"
 )
 
+const (
+	// DefaultWatchInterval is how often the configuration file is checked
+	// for changes by default.
+	DefaultWatchInterval time.Duration = 5 * time.Second
+
+	// settleTime is how long to wait before reading the configuration file
+	// again, to make sure it is not being written, when it is read because
+	// of SIGHUP.
+	settleTime time.Duration = 100 * time.Millisecond
+)
+
 var (
-	conf *Config
+	lock     sync.RWMutex
+	conf     *Config
+	confPath string
+	origins  map[string]Origin
 )
 
 // ParseConfigurationFile parses the configuration file starting from the
-// command
+// command.
+//
+// All ${VAR} in the file are replaced with the value of the environment
+// variable VAR, then fields are overridden by the environment variables
+// starting with EnvPrefix, if set, and finally secrets provided as files,
+// i.e. passwordFile, are read. If no file is provided via --conf, the
+// configuration is built from the environment variables alone, if any is
+// set. Maps cannot be overridden, and setting a variable for one of them
+// is reported as an error.
+//
+// Fields that are not part of the configuration and invalid values are
+// reported as a *ValidationError.
 func ParseConfigurationFile(cmd *cobra.Command) (err error) {
-	if conf != nil {
+	if GetConfigFile() != nil {
 		return
 	}
 
 	if !cmd.Flags().Changed("conf") {
-		return
-	}
+		// The configuration can also be provided with environment
+		// variables only.
+		var _conf Config
+		_origins := map[string]Origin{}
+		set, err := applyEnv(&_conf, lookupEnv, _origins)
+		if err != nil || !set {
+			return err
+		}
 
-	filePath, _ := cmd.Flags().GetString("conf")
+		if err := finalize(&_conf, nil, _origins); err != nil {
+			return err
+		}
 
-	yamlFile, err := ioutil.ReadFile(filePath)
-	if err != nil {
-		return
+		lock.Lock()
+		conf, origins = &_conf, _origins
+		lock.Unlock()
+		return nil
 	}
 
-	var _conf Config
-	err = yaml.Unmarshal(yamlFile, &_conf)
+	filePath, _ := cmd.Flags().GetString("conf")
+
+	_conf, _origins, err := load(filePath)
 	if err != nil {
 		return
 	}
 
-	if len(_conf.MetadataKeys) > 0 {
-		_conf.MetadataKeys = []string{_conf.MetadataKeys[0]}
-	}
-
-	conf = &_conf
+	lock.Lock()
+	conf, confPath, origins = _conf, filePath, _origins
+	lock.Unlock()
 	return
 }
 
@@ -66,5 +108,215 @@ func ParseConfigurationFile(cmd *cobra.Command) (err error) {
 // If the configuration file was not provided via --conf, then this returns
 // nil.
 func GetConfigFile() *Config {
+	lock.RLock()
+	defer lock.RUnlock()
+
 	return conf
 }
+
+// Watch checks the configuration file parsed with ParseConfigurationFile
+// for changes every interval, and also reads it again every time SIGHUP is
+// received, until ctx is done.
+//
+// Every time the file is read again, its new content replaces the one
+// returned by GetConfigFile and is sent to the returned channel. A change
+// is only applied once the file has the same content in two consecutive
+// reads, so that a file that is still being written is not mistaken for
+// the new configuration. Files that cannot be parsed are ignored. If no
+// configuration file was parsed, the returned channel never receives
+// anything.
+func Watch(ctx context.Context, interval time.Duration) <-chan *Config {
+	changes := make(chan *Config)
+
+	lock.RLock()
+	filePath := confPath
+	lock.RUnlock()
+	if len(filePath) == 0 {
+		return changes
+	}
+
+	go func() {
+		l := log.With().Str("func", "configuration.Watch").Str("file", filePath).Logger()
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		w := &fileWatcher{path: filePath}
+		w.last, _ = ioutil.ReadFile(filePath)
+		for {
+			var content []byte
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				l.Info().Msg("SIGHUP received: reading configuration file again...")
+				var err error
+				if content, err = w.read(ctx); err != nil {
+					l.Err(err).Msg("could not read configuration file, keeping the current one")
+					continue
+				}
+			case <-ticker.C:
+				// The file may be being replaced: errors are only
+				// reported when reading it is explicitly requested.
+				changed := false
+				if content, changed = w.poll(); !changed {
+					continue
+				}
+				l.Info().Msg("configuration file changed: reading it again...")
+			}
+
+			_conf, _origins, err := parse(content)
+			if err != nil {
+				l.Err(err).Msg("could not parse configuration file, keeping the current one")
+				continue
+			}
+
+			lock.Lock()
+			conf, origins = _conf, _origins
+			lock.Unlock()
+
+			select {
+			case changes <- _conf:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return changes
+}
+
+// fileWatcher reads a file and tells when its content changed.
+type fileWatcher struct {
+	path string
+	// last is the content that was last applied.
+	last []byte
+	// pending is a new content read in the last poll, which is applied if
+	// it is still the same in the next one.
+	pending []byte
+}
+
+// poll reads the file and returns its content and true if it is different
+// from the last one and the same as in the previous poll.
+func (w *fileWatcher) poll() ([]byte, bool) {
+	content, err := ioutil.ReadFile(w.path)
+	if err != nil || bytes.Equal(content, w.last) {
+		w.pending = nil
+		return nil, false
+	}
+
+	if w.pending == nil || !bytes.Equal(content, w.pending) {
+		// Wait for the file to stop changing
+		w.pending = content
+		return nil, false
+	}
+
+	w.last, w.pending = content, nil
+	return content, true
+}
+
+// read reads the file twice, settleTime apart, and returns its content if
+// it is the same in both reads.
+func (w *fileWatcher) read(ctx context.Context) ([]byte, error) {
+	content, err := ioutil.ReadFile(w.path)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(settleTime):
+	}
+
+	again, err := ioutil.ReadFile(w.path)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(content, again) {
+		// It is applied by a next poll when it stops changing
+		w.pending = again
+		return nil, fmt.Errorf("file is still being written")
+	}
+
+	w.last, w.pending = content, nil
+	return content, nil
+}
+
+// Load reads, parses and validates the configuration file in the provided
+// path, just like ParseConfigurationFile, but without replacing the one
+// returned by GetConfigFile.
+func Load(filePath string) (*Config, error) {
+	_conf, _, err := load(filePath)
+	return _conf, err
+}
+
+func load(filePath string) (*Config, map[string]Origin, error) {
+	yamlFile, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return parse(yamlFile)
+}
+
+// parse returns the configuration in the provided yaml file and where the
+// value of each of its fields comes from.
+func parse(yamlFile []byte) (*Config, map[string]Origin, error) {
+	unknown, err := checkUnknownFields(yamlFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Unknown fields are reported along with invalid values, so they
+	// must not stop the decoding.
+	decode := yaml.UnmarshalStrict
+	if len(unknown) > 0 {
+		decode = yaml.Unmarshal
+	}
+
+	var _conf Config
+	if err := decode(yamlFile, &_conf); err != nil {
+		return nil, nil, err
+	}
+
+	expandEnv(&_conf, lookupEnv)
+	_origins := fileOrigins(yamlFile)
+	if _, err := applyEnv(&_conf, lookupEnv, _origins); err != nil {
+		return nil, nil, err
+	}
+
+	if err := finalize(&_conf, unknown, _origins); err != nil {
+		return nil, nil, err
+	}
+
+	return &_conf, _origins, nil
+}
+
+func finalize(_conf *Config, unknown []*FieldError, _origins map[string]Origin) error {
+	if err := readSecretFiles(_conf); err != nil {
+		return err
+	}
+
+	// Passwords read from files come from wherever their files come from.
+	for _, path := range []string{"leaderElection.password", "serviceRegistry.etcd.credentials.password"} {
+		if origin, exists := _origins[path+"File"]; exists {
+			_origins[path] = origin
+		}
+	}
+
+	v := &validator{errs: unknown}
+	v.validate(_conf)
+	if err := v.err(); err != nil {
+		return err
+	}
+
+	if len(_conf.MetadataKeys) > 0 {
+		_conf.MetadataKeys = []string{_conf.MetadataKeys[0]}
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package configuration
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatch(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "cnwan-reader")
+	if !a.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	filePath := path.Join(dir, "config.yaml")
+	// Files are replaced at once, so that they are never read while only
+	// partially written.
+	write := func(content string) {
+		ioutil.WriteFile(filePath+".tmp", []byte(content), 0644)
+		os.Rename(filePath+".tmp", filePath)
+	}
+	write("adaptor: localhost:8080\n")
+
+	ctx, canc := context.WithCancel(context.Background())
+	defer canc()
+
+	// Without a configuration file nothing is watched
+	select {
+	case <-Watch(ctx, 10*time.Millisecond):
+		a.FailNow("no configuration should have been received")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	prevConf, prevPath := conf, confPath
+	defer func() {
+		conf, confPath = prevConf, prevPath
+	}()
+	conf, confPath = &Config{Adaptor: "localhost:8080"}, filePath
+
+	changes := Watch(ctx, 10*time.Millisecond)
+	select {
+	case <-changes:
+		a.FailNow("the file has not changed yet")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Files that cannot be parsed are ignored
+	write("adaptor: [\n")
+	select {
+	case <-changes:
+		a.FailNow("invalid files should be ignored")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	write("adaptor: example.com\nmetadataKeys:\n  - one\n  - two\n")
+	select {
+	case newConf := <-changes:
+		exp := &Config{Adaptor: "example.com", MetadataKeys: []string{"one"}}
+		a.Equal(exp, newConf)
+		a.Equal(exp, GetConfigFile())
+	case <-time.After(time.Second):
+		a.FailNow("new configuration not received")
+	}
+}
+
+func TestFileWatcherPoll(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "cnwan-reader")
+	if !a.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	filePath := path.Join(dir, "config.yaml")
+	ioutil.WriteFile(filePath, []byte("adaptor: localhost:8080\n"), 0644)
+	w := &fileWatcher{path: filePath, last: []byte("adaptor: localhost:8080\n")}
+
+	_, changed := w.poll()
+	a.False(changed)
+
+	// A file that is still being written is not applied, even if it can
+	// be parsed
+	ioutil.WriteFile(filePath, []byte("adaptor: example.com\n"), 0644)
+	_, changed = w.poll()
+	a.False(changed)
+	ioutil.WriteFile(filePath, []byte("adaptor: example.com\nmetadataKeys:\n  - one\n"), 0644)
+	_, changed = w.poll()
+	a.False(changed)
+
+	content, changed := w.poll()
+	a.True(changed)
+	a.Equal("adaptor: example.com\nmetadataKeys:\n  - one\n", string(content))
+
+	_, changed = w.poll()
+	a.False(changed)
+
+	// Reading it explicitly applies it if it doesn't change meanwhile
+	ioutil.WriteFile(filePath, []byte("adaptor: other.com\n"), 0644)
+	content, err = w.read(context.Background())
+	a.NoError(err)
+	a.Equal("adaptor: other.com\n", string(content))
+	_, changed = w.poll()
+	a.False(changed)
+}
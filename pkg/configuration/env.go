@@ -0,0 +1,325 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package configuration
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// EnvPrefix is the prefix of the environment variables that override
+	// the fields of the configuration, i.e. CNWAN_READER_ADAPTOR for
+	// adaptor and CNWAN_READER_SERVICE_REGISTRY_ETCD_PREFIX for
+	// serviceRegistry.etcd.prefix.
+	EnvPrefix string = "CNWAN_READER"
+)
+
+var (
+	envVarRegex = regexp.MustCompile(`\$\{([a-zA-Z_][a-zA-Z0-9_]*)\}`)
+)
+
+// expandEnv replaces all ${VAR} in the string values of the configuration
+// with the value of the environment variable VAR, or an empty string if it
+// is not set. Other occurrences of $ are left as they are, i.e. in
+// passwords.
+//
+// This is done after the file is decoded, so that the values of the
+// variables are never parsed as yaml and cannot change other fields.
+func expandEnv(conf *Config, lookup func(string) (string, bool)) {
+	expandEnvInValue(reflect.ValueOf(conf).Elem(), lookup)
+}
+
+func expandEnvInValue(v reflect.Value, lookup func(string) (string, bool)) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			expandEnvInValue(v.Elem(), lookup)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath == "" {
+				expandEnvInValue(v.Field(i), lookup)
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			expandEnvInValue(v.Index(i), lookup)
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			// Map values are not addressable, so they are expanded in
+			// a copy that replaces them.
+			val := reflect.New(v.Type().Elem()).Elem()
+			val.Set(v.MapIndex(key))
+			expandEnvInValue(val, lookup)
+			v.SetMapIndex(key, val)
+		}
+	case reflect.String:
+		v.SetString(expandEnvInString(v.String(), lookup))
+	}
+}
+
+func expandEnvInString(s string, lookup func(string) (string, bool)) string {
+	return envVarRegex.ReplaceAllStringFunc(s, func(match string) string {
+		val, _ := lookup(envVarRegex.FindStringSubmatch(match)[1])
+		return val
+	})
+}
+
+// applyEnv overrides the fields of the configuration with the environment
+// variables that are set for them. It returns true if at least one was
+// set, and records the paths of the overridden fields in origins, if not
+// nil.
+//
+// The name of the variable of a field is EnvPrefix followed by the yaml
+// names of the field and its parents in upper snake case. Lists are
+// provided as comma separated values, except for lists of objects, whose
+// fields are provided one by one with the index of the object, i.e.
+// CNWAN_READER_TRANSFORMS_0_SOURCE. Maps are not supported.
+func applyEnv(conf *Config, lookup func(string) (string, bool), origins map[string]Origin) (bool, error) {
+	return applyEnvToStruct(reflect.ValueOf(conf).Elem(), EnvPrefix, "", lookup, origins)
+}
+
+func applyEnvToStruct(v reflect.Value, prefix, path string, lookup func(string) (string, bool), origins map[string]Origin) (bool, error) {
+	set := false
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name := yamlName(field)
+		if len(name) == 0 || name == "-" {
+			continue
+		}
+		envName := prefix + "_" + toEnvName(name)
+		fieldPath := joinPath(path, name)
+		fieldVal := v.Field(i)
+
+		if field.Type.Kind() == reflect.Ptr && field.Type.Elem().Kind() == reflect.Struct {
+			target := fieldVal
+			if target.IsNil() {
+				target = reflect.New(field.Type.Elem())
+			}
+
+			_set, err := applyEnvToStruct(target.Elem(), envName, fieldPath, lookup, origins)
+			if err != nil {
+				return false, err
+			}
+			if _set {
+				fieldVal.Set(target)
+				set = true
+			}
+			continue
+		}
+
+		if field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct {
+			_set, err := applyEnvToList(fieldVal, envName, lookup)
+			if err != nil {
+				return false, err
+			}
+			if _set {
+				if origins != nil {
+					origins[fieldPath] = OriginEnv
+				}
+				set = true
+			}
+			continue
+		}
+
+		val, exists := lookup(envName)
+		if !exists {
+			continue
+		}
+
+		if err := setFromEnv(fieldVal, val); err != nil {
+			return false, fmt.Errorf("invalid value for %s: %w", envName, err)
+		}
+		if origins != nil {
+			origins[fieldPath] = OriginEnv
+		}
+		set = true
+	}
+
+	return set, nil
+}
+
+// applyEnvToList overrides the fields of the objects of a list with the
+// environment variables that include their index, i.e. prefix_0_SOURCE.
+// Objects are appended to the list as long as variables are set for the
+// index that follows the last one.
+func applyEnvToList(v reflect.Value, prefix string, lookup func(string) (string, bool)) (bool, error) {
+	if _, exists := lookup(prefix); exists {
+		return false, fmt.Errorf("invalid value for %s: lists of objects must be provided one field at a time, i.e. with %s_0_<FIELD>", prefix, prefix)
+	}
+
+	set := false
+	for i := 0; ; i++ {
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if i < v.Len() {
+			elem = v.Index(i)
+		}
+
+		_set, err := applyEnvToStruct(elem, prefix+"_"+strconv.Itoa(i), "", lookup, nil)
+		if err != nil {
+			return false, err
+		}
+
+		switch {
+		case i < v.Len():
+			set = set || _set
+		case _set:
+			v.Set(reflect.Append(v, elem))
+			set = true
+		default:
+			return set, nil
+		}
+	}
+}
+
+func setFromEnv(v reflect.Value, val string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(val)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", v.Type())
+		}
+
+		vals := []string{}
+		for _, s := range splitList(val) {
+			if s = strings.TrimSpace(s); len(s) > 0 {
+				vals = append(vals, s)
+			}
+		}
+		v.Set(reflect.ValueOf(vals))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
+
+// splitList splits a comma separated list, except for the commas enclosed
+// in parentheses, brackets or braces, i.e. in filters.
+func splitList(val string) []string {
+	split := []string{}
+	depth, start := 0, 0
+
+	for i, r := range val {
+		switch r {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				split = append(split, val[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(split, val[start:])
+}
+
+// toEnvName converts a yaml name to upper snake case, i.e. roleARN to
+// ROLE_ARN and awsCloudMap to AWS_CLOUD_MAP.
+func toEnvName(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prevLower := !unicode.IsUpper(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || nextLower {
+				b.WriteRune('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+
+	return b.String()
+}
+
+// readSecretFiles reads the values of the fields that are provided as
+// files, i.e. passwordFile.
+func readSecretFiles(conf *Config) error {
+	if le := conf.LeaderElection; le != nil {
+		password, err := readSecretFile(le.Password, le.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("leaderElection: %w", err)
+		}
+		le.Password = password
+	}
+
+	if conf.ServiceRegistry != nil && conf.ServiceRegistry.Etcd != nil && conf.ServiceRegistry.Etcd.Credentials != nil {
+		creds := conf.ServiceRegistry.Etcd.Credentials
+		password, err := readSecretFile(creds.Password, creds.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("serviceRegistry.etcd.credentials: %w", err)
+		}
+		creds.Password = password
+	}
+
+	return nil
+}
+
+// readSecretFile returns the content of the provided file, without the
+// trailing new line, or value if no file is provided.
+func readSecretFile(value, filePath string) (string, error) {
+	if len(filePath) == 0 {
+		return value, nil
+	}
+
+	if len(value) > 0 {
+		return "", fmt.Errorf("password and passwordFile cannot be both provided")
+	}
+
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// lookupEnv is the function used to read environment variables.
+var lookupEnv = os.LookupEnv
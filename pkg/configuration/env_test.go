@@ -0,0 +1,253 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package configuration
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandEnv(t *testing.T) {
+	a := assert.New(t)
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+	env := map[string]string{
+		"PASSWORD":  "secret",
+		"INJECTION": "secret\nadaptor: evil:80",
+		"COLON":     "user: admin",
+		"COMMENT":   "s3cr3t #not-a-comment",
+	}
+	lookupEnv = func(key string) (string, bool) {
+		val, ok := env[key]
+		return val, ok
+	}
+	defer func() { lookupEnv = os.LookupEnv }()
+
+	cases := []struct {
+		password string
+		expRes   string
+	}{
+		{password: "${PASSWORD}", expRes: "secret"},
+		{password: "${NOT_SET}", expRes: ""},
+		{password: "s5B7&$n_12C", expRes: "s5B7&$n_12C"},
+		{password: "$PASSWORD", expRes: "$PASSWORD"},
+		{password: "pre-${PASSWORD}-post", expRes: "pre-secret-post"},
+		{password: "${INJECTION}", expRes: "secret\nadaptor: evil:80"},
+		{password: "${COLON}", expRes: "user: admin"},
+		{password: "${COMMENT}", expRes: "s3cr3t #not-a-comment"},
+	}
+
+	for i, currCase := range cases {
+		content := "adaptor: localhost\nserviceRegistry:\n  etcd:\n    credentials:\n      username: ${COLON}\n      password: " + currCase.password + "\n"
+		conf, _, err := parse([]byte(content))
+		if !a.NoError(err) {
+			failed(i)
+		}
+		creds := conf.ServiceRegistry.Etcd.Credentials
+		if !a.Equal(currCase.expRes, creds.Password) ||
+			!a.Equal("user: admin", creds.Username) ||
+			!a.Equal("localhost", conf.Adaptor) {
+			failed(i)
+		}
+	}
+
+	conf := &Config{
+		Filters:    []string{"${PASSWORD}"},
+		Transforms: []Transform{{AddMetadata: map[string]string{"key": "${COMMENT}"}}},
+	}
+	expandEnv(conf, lookupEnv)
+	a.Equal([]string{"secret"}, conf.Filters)
+	a.Equal(map[string]string{"key": "s3cr3t #not-a-comment"}, conf.Transforms[0].AddMetadata)
+}
+
+func TestApplyEnv(t *testing.T) {
+	a := assert.New(t)
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+	lookupFrom := func(env map[string]string) func(string) (string, bool) {
+		return func(key string) (string, bool) {
+			val, ok := env[key]
+			return val, ok
+		}
+	}
+
+	cases := []struct {
+		conf    *Config
+		env     map[string]string
+		expConf *Config
+		expSet  bool
+		expErr  error
+	}{
+		{
+			conf:    &Config{Adaptor: "localhost"},
+			env:     map[string]string{},
+			expConf: &Config{Adaptor: "localhost"},
+		},
+		{
+			conf: &Config{Adaptor: "localhost"},
+			env: map[string]string{
+				"CNWAN_READER_ADAPTOR":                                       "adaptor:8080",
+				"CNWAN_READER_DEBUG_MODE":                                    "true",
+				"CNWAN_READER_METADATA_KEYS":                                 "one, two,",
+				"CNWAN_READER_FILTERS":                                       "one in (a, b), two",
+				"CNWAN_READER_SERVICE_REGISTRY_ETCD_PREFIX":                  "/prefix",
+				"CNWAN_READER_SERVICE_REGISTRY_ETCD_CREDENTIALS_PASSWORD":    "pass",
+				"CNWAN_READER_SERVICE_REGISTRY_GCP_SERVICE_DIRECTORY_REGION": "us-west1",
+				"CNWAN_READER_SERVICE_REGISTRY_AWS_CLOUD_MAP_POLL_INTERVAL":  "10",
+			},
+			expConf: &Config{
+				Adaptor:      "adaptor:8080",
+				DebugMode:    true,
+				MetadataKeys: []string{"one", "two"},
+				Filters:      []string{"one in (a, b)", "two"},
+				ServiceRegistry: &ServiceRegistrySettings{
+					Etcd: &EtcdConfig{
+						Prefix:      "/prefix",
+						Credentials: &EtcdCredentials{Password: "pass"},
+					},
+					GCPServiceDirectory: &ServiceDirectoryConfig{Region: "us-west1"},
+					AWSCloudMap:         &CloudMapConfig{PollInterval: 10},
+				},
+			},
+			expSet: true,
+		},
+		{
+			conf: &Config{},
+			env: map[string]string{
+				"CNWAN_READER_DEBUG_MODE": "maybe",
+			},
+			expErr: fmt.Errorf("invalid value for CNWAN_READER_DEBUG_MODE: %w", fmt.Errorf(`strconv.ParseBool: parsing "maybe": invalid syntax`)),
+		},
+		{
+			// Objects are overridden field by field and added only right
+			// after the last one
+			conf: &Config{
+				Transforms: []Transform{{Source: "etcd", AddMetadata: map[string]string{"site": "eu-west"}}},
+			},
+			env: map[string]string{
+				"CNWAN_READER_TRANSFORMS_0_SOURCE":                                   "cloudmap",
+				"CNWAN_READER_TRANSFORMS_1_SOURCE":                                   "servicedirectory",
+				"CNWAN_READER_TRANSFORMS_3_SOURCE":                                   "etcd",
+				"CNWAN_READER_SERVICE_REGISTRY_AWS_CLOUD_MAP_ACCOUNTS_0_ROLE_ARN":    "arn:aws:iam::123456789012:role/reader",
+				"CNWAN_READER_SERVICE_REGISTRY_AWS_CLOUD_MAP_ACCOUNTS_0_REGIONS":     "us-east-1, eu-west-1",
+				"CNWAN_READER_SERVICE_REGISTRY_AWS_CLOUD_MAP_ACCOUNTS_1_EXTERNAL_ID": "id",
+			},
+			expConf: &Config{
+				Transforms: []Transform{
+					{Source: "cloudmap", AddMetadata: map[string]string{"site": "eu-west"}},
+					{Source: "servicedirectory"},
+				},
+				ServiceRegistry: &ServiceRegistrySettings{
+					AWSCloudMap: &CloudMapConfig{
+						Accounts: []CloudMapAccount{
+							{RoleARN: "arn:aws:iam::123456789012:role/reader", Regions: []string{"us-east-1", "eu-west-1"}},
+							{ExternalID: "id"},
+						},
+					},
+				},
+			},
+			expSet: true,
+		},
+		{
+			conf: &Config{},
+			env: map[string]string{
+				"CNWAN_READER_TRANSFORMS": "etcd",
+			},
+			expErr: fmt.Errorf("invalid value for CNWAN_READER_TRANSFORMS: lists of objects must be provided one field at a time, i.e. with CNWAN_READER_TRANSFORMS_0_<FIELD>"),
+		},
+		{
+			conf: &Config{},
+			env: map[string]string{
+				"CNWAN_READER_TRANSFORMS_0_ADD_METADATA": "site=eu-west",
+			},
+			expErr: fmt.Errorf("invalid value for CNWAN_READER_TRANSFORMS_0_ADD_METADATA: unsupported type map[string]string"),
+		},
+	}
+
+	for i, currCase := range cases {
+		set, err := applyEnv(currCase.conf, lookupFrom(currCase.env), nil)
+		if currCase.expErr != nil {
+			if !a.EqualError(err, currCase.expErr.Error()) {
+				failed(i)
+			}
+			continue
+		}
+
+		if !a.NoError(err) || !a.Equal(currCase.expSet, set) || !a.Equal(currCase.expConf, currCase.conf) {
+			failed(i)
+		}
+	}
+
+	// Lists of objects are overridden as a whole
+	origins := map[string]Origin{}
+	_, err := applyEnv(&Config{}, lookupFrom(map[string]string{"CNWAN_READER_TRANSFORMS_0_SOURCE": "etcd"}), origins)
+	a.NoError(err)
+	a.Equal(map[string]Origin{"transforms": OriginEnv}, origins)
+}
+
+func TestToEnvName(t *testing.T) {
+	a := assert.New(t)
+	cases := map[string]string{
+		"adaptor":             "ADAPTOR",
+		"awsCloudMap":         "AWS_CLOUD_MAP",
+		"roleARN":             "ROLE_ARN",
+		"projectID":           "PROJECT_ID",
+		"endpointURL":         "ENDPOINT_URL",
+		"gcpServiceDirectory": "GCP_SERVICE_DIRECTORY",
+		"caFile":              "CA_FILE",
+	}
+
+	for name, exp := range cases {
+		a.Equal(exp, toEnvName(name))
+	}
+}
+
+func TestReadSecretFiles(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "cnwan-reader")
+	if !a.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	filePath := path.Join(dir, "password")
+	ioutil.WriteFile(filePath, []byte("secret\n"), 0600)
+
+	conf := &Config{
+		LeaderElection: &LeaderElectionSettings{PasswordFile: filePath},
+		ServiceRegistry: &ServiceRegistrySettings{
+			Etcd: &EtcdConfig{Credentials: &EtcdCredentials{PasswordFile: filePath}},
+		},
+	}
+	a.NoError(readSecretFiles(conf))
+	a.Equal("secret", conf.LeaderElection.Password)
+	a.Equal("secret", conf.ServiceRegistry.Etcd.Credentials.Password)
+
+	conf = &Config{LeaderElection: &LeaderElectionSettings{Password: "pass", PasswordFile: filePath}}
+	a.EqualError(readSecretFiles(conf), "leaderElection: password and passwordFile cannot be both provided")
+
+	conf = &Config{LeaderElection: &LeaderElectionSettings{PasswordFile: path.Join(dir, "not-exists")}}
+	a.Error(readSecretFiles(conf))
+}
@@ -0,0 +1,372 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package configuration
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+)
+
+// Origin is where the value of a field of the configuration comes from.
+type Origin string
+
+const (
+	// OriginDefault is the origin of values that were not provided.
+	OriginDefault Origin = "default"
+	// OriginFile is the origin of values from the configuration file.
+	OriginFile Origin = "file"
+	// OriginEnv is the origin of values from environment variables.
+	OriginEnv Origin = "env"
+	// OriginFlag is the origin of values from flags.
+	OriginFlag Origin = "flag"
+)
+
+const (
+	// FlagAnnotation is the annotation of a flag that contains the path of
+	// the field it overrides, i.e. serviceRegistry.etcd.prefix. Paths
+	// starting with a dot are relative to the section of the command.
+	FlagAnnotation string = "cnwan-reader/config-path"
+	// SectionAnnotation is the annotation of a command that contains the
+	// path of the section of the configuration it reads, i.e.
+	// serviceRegistry.etcd.
+	SectionAnnotation string = "cnwan-reader/config-section"
+)
+
+// BindFlag annotates the flag with the path of the field of the
+// configuration that it overrides, so that it can be resolved by Resolve.
+func BindFlag(flags *pflag.FlagSet, name, path string) {
+	flags.SetAnnotation(name, FlagAnnotation, []string{path})
+}
+
+// GetOrigins returns where the value of each field of the configuration
+// returned by GetConfigFile comes from, by the path of the field.
+func GetOrigins() map[string]Origin {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	_origins := make(map[string]Origin, len(origins))
+	for path, origin := range origins {
+		_origins[path] = origin
+	}
+
+	return _origins
+}
+
+// SectionFunc returns the configuration with only the section of a
+// service registry, resolved from the flags of the provided command and
+// from conf in the same way as the command does, and where the value of
+// each of its fields comes from, by their full path.
+type SectionFunc func(cmd *cobra.Command, conf *Config) (*Config, map[string]Origin)
+
+// SectionOrigins records where the values of the fields of a section come
+// from, while a command resolves them.
+type SectionOrigins struct {
+	section string
+	conf    map[string]Origin
+	origins map[string]Origin
+}
+
+// NewSectionOrigins returns a SectionOrigins for the section in the
+// provided path, i.e. serviceRegistry.etcd.
+func NewSectionOrigins(section string) *SectionOrigins {
+	return &SectionOrigins{
+		section: section,
+		conf:    GetOrigins(),
+		origins: map[string]Origin{},
+	}
+}
+
+// Flag records that the field in the provided path, relative to the
+// section, comes from a flag.
+func (s *SectionOrigins) Flag(path string) {
+	s.origins[joinPath(s.section, path)] = OriginFlag
+}
+
+// Conf records that the field in the provided path, relative to the
+// section, comes from the configuration returned by GetConfigFile, that is
+// from the file or from an environment variable.
+func (s *SectionOrigins) Conf(path string) {
+	fullPath := joinPath(s.section, path)
+	origin, exists := s.conf[fullPath]
+	if !exists {
+		origin = OriginFile
+	}
+	s.origins[fullPath] = origin
+}
+
+// Default records that the field in the provided path, relative to the
+// section, has its default value.
+func (s *SectionOrigins) Default(path string) {
+	s.origins[joinPath(s.section, path)] = OriginDefault
+}
+
+// Origins returns the origins recorded so far, by the full path of the
+// fields.
+func (s *SectionOrigins) Origins() map[string]Origin {
+	return s.origins
+}
+
+// Resolve returns the effective configuration used by the provided
+// command, whose flags must have already been parsed, and where the value
+// of each of its fields comes from.
+//
+// Flags override the configuration returned by GetConfigFile, and the
+// default values of flags are used for the fields that are not set.
+// Only the section of the service registry read by the command, if any,
+// is included.
+//
+// The sections of the service registries are resolved by the function in
+// sections for their path, if any, as commands have their own rules for
+// them, i.e. flags that replace others.
+func Resolve(cmd *cobra.Command, sections map[string]SectionFunc) (*Config, map[string]Origin, error) {
+	effective := &Config{}
+	if base := GetConfigFile(); base != nil {
+		// Copy it, as it is going to be modified.
+		content, err := yaml.Marshal(base)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := yaml.Unmarshal(content, effective); err != nil {
+			return nil, nil, err
+		}
+	}
+	resolved := GetOrigins()
+
+	section := ""
+	for c := cmd; c != nil && len(section) == 0; c = c.Parent() {
+		section = c.Annotations[SectionAnnotation]
+	}
+	keepSection(effective, section)
+
+	// The same flag can be found on more commands, and the one that was
+	// provided wins.
+	flags := map[string]*pflag.Flag{}
+	for c := cmd; c != nil; c = c.Parent() {
+		c.Flags().VisitAll(func(f *pflag.Flag) {
+			paths := f.Annotations[FlagAnnotation]
+			if len(paths) == 0 {
+				return
+			}
+
+			path := paths[0]
+			if strings.HasPrefix(path, ".") {
+				if len(section) == 0 || sections[section] != nil {
+					return
+				}
+				path = section + path
+			}
+
+			if prev, exists := flags[path]; !exists || (!prev.Changed && f.Changed) {
+				flags[path] = f
+			}
+		})
+	}
+
+	paths := make([]string, 0, len(flags))
+	for path := range flags {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		f := flags[path]
+		_, provided := resolved[path]
+
+		switch {
+		case f.Changed:
+			val := f.Value.String()
+			if slice, ok := f.Value.(pflag.SliceValue); ok {
+				val = strings.Join(slice.GetSlice(), ",")
+			}
+			if err := setField(effective, path, val); err != nil {
+				return nil, nil, err
+			}
+			resolved[path] = OriginFlag
+		case !provided:
+			val := strings.Trim(f.DefValue, "[]")
+			if val == "" || val == "0" || val == "false" {
+				continue
+			}
+			if err := setField(effective, path, val); err != nil {
+				return nil, nil, err
+			}
+			resolved[path] = OriginDefault
+		}
+	}
+
+	for _, path := range sectionsToResolve(effective, section) {
+		resolveSection, exists := sections[path]
+		if !exists {
+			continue
+		}
+
+		sectionConf, sectionOrigins := resolveSection(cmd, GetConfigFile())
+		setSection(effective, sectionConf, path)
+		for fieldPath := range resolved {
+			if strings.HasPrefix(fieldPath, path+".") {
+				delete(resolved, fieldPath)
+			}
+		}
+		for fieldPath, origin := range sectionOrigins {
+			resolved[fieldPath] = origin
+		}
+	}
+
+	return effective, resolved, nil
+}
+
+// sectionsToResolve returns the paths of the sections of the service
+// registries in the configuration, or only the provided section if not
+// empty, as it is the one read by the command.
+func sectionsToResolve(conf *Config, section string) []string {
+	if len(section) > 0 {
+		return []string{section}
+	}
+
+	paths := []string{}
+	if conf.ServiceRegistry == nil {
+		return paths
+	}
+
+	v := reflect.ValueOf(conf.ServiceRegistry).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if !v.Field(i).IsNil() {
+			paths = append(paths, "serviceRegistry."+yamlName(v.Type().Field(i)))
+		}
+	}
+
+	return paths
+}
+
+// setSection sets the service registry in the provided section of dst to
+// the one of src.
+func setSection(dst, src *Config, section string) {
+	name := strings.TrimPrefix(section, "serviceRegistry.")
+	if src.ServiceRegistry == nil {
+		return
+	}
+	if dst.ServiceRegistry == nil {
+		dst.ServiceRegistry = &ServiceRegistrySettings{}
+	}
+
+	dstVal := reflect.ValueOf(dst.ServiceRegistry).Elem()
+	srcVal := reflect.ValueOf(src.ServiceRegistry).Elem()
+	for i := 0; i < dstVal.NumField(); i++ {
+		if yamlName(dstVal.Type().Field(i)) == name {
+			dstVal.Field(i).Set(srcVal.Field(i))
+		}
+	}
+}
+
+// keepSection removes all the service registries from the configuration
+// except for the one in the provided section, if it is one.
+func keepSection(conf *Config, section string) {
+	if conf.ServiceRegistry == nil || !strings.HasPrefix(section, "serviceRegistry.") {
+		return
+	}
+
+	keep := strings.TrimPrefix(section, "serviceRegistry.")
+	v := reflect.ValueOf(conf.ServiceRegistry).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if yamlName(v.Type().Field(i)) != keep {
+			v.Field(i).Set(reflect.Zero(v.Field(i).Type()))
+		}
+	}
+}
+
+// setField sets the field in the provided path to the provided value,
+// parsed like the values of environment variables.
+func setField(conf *Config, path, val string) error {
+	v := reflect.ValueOf(conf).Elem()
+	for _, name := range strings.Split(path, ".") {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+
+		found := false
+		for i := 0; i < v.NumField(); i++ {
+			if yamlName(v.Type().Field(i)) == name {
+				v, found = v.Field(i), true
+				break
+			}
+		}
+		if !found {
+			return &FieldError{Path: path, Message: "unknown field"}
+		}
+	}
+
+	if err := setFromEnv(v, val); err != nil {
+		return &FieldError{Path: path, Message: err.Error()}
+	}
+
+	return nil
+}
+
+// fileOrigins returns the paths of the fields that are set in the yaml
+// file. Lists are considered as a whole.
+func fileOrigins(yamlFile []byte) map[string]Origin {
+	var raw interface{}
+	yaml.Unmarshal(yamlFile, &raw)
+
+	_origins := map[string]Origin{}
+	var walk func(raw interface{}, t reflect.Type, path string)
+	walk = func(raw interface{}, t reflect.Type, path string) {
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+
+		values, ok := raw.(map[interface{}]interface{})
+		if t.Kind() != reflect.Struct || !ok {
+			if len(path) > 0 {
+				_origins[path] = OriginFile
+			}
+			return
+		}
+
+		for i := 0; i < t.NumField(); i++ {
+			name := yamlName(t.Field(i))
+			if value, exists := values[name]; exists && value != nil {
+				walk(value, t.Field(i).Type, joinPath(path, name))
+			}
+		}
+	}
+	walk(raw, reflect.TypeOf(Config{}), "")
+
+	return _origins
+}
+
+func yamlName(field reflect.StructField) string {
+	return strings.Split(field.Tag.Get("yaml"), ",")[0]
+}
+
+func joinPath(path, name string) string {
+	if len(path) == 0 {
+		return name
+	}
+
+	return path + "." + name
+}
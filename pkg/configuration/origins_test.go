@@ -0,0 +1,190 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package configuration
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileOrigins(t *testing.T) {
+	a := assert.New(t)
+
+	res := fileOrigins([]byte(`adaptor: localhost
+metadataKeys:
+  - key
+serviceRegistry:
+  awsCloudMap:
+    accounts:
+      - roleARN: arn
+  etcd:
+    credentials:
+      username: user
+    tls:
+`))
+	a.Equal(map[string]Origin{
+		"adaptor":                              OriginFile,
+		"metadataKeys":                         OriginFile,
+		"serviceRegistry.awsCloudMap.accounts": OriginFile,
+		"serviceRegistry.etcd.credentials.username": OriginFile,
+	}, res)
+
+	a.Empty(fileOrigins([]byte("")))
+}
+
+func TestResolve(t *testing.T) {
+	a := assert.New(t)
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+	prevConf, prevOrigins := conf, origins
+	defer func() {
+		conf, origins = prevConf, prevOrigins
+	}()
+
+	newCmd := func() *cobra.Command {
+		root := &cobra.Command{Use: "root"}
+		root.PersistentFlags().String("adaptor-api", "localhost:80/cnwan", "")
+		root.PersistentFlags().Int("rate-limit-burst", 1, "")
+		root.PersistentFlags().Int("max-events-per-request", 0, "")
+		BindFlag(root.PersistentFlags(), "adaptor-api", "adaptor")
+		BindFlag(root.PersistentFlags(), "rate-limit-burst", "delivery.rateLimitBurst")
+		BindFlag(root.PersistentFlags(), "max-events-per-request", "delivery.maxEventsPerRequest")
+
+		child := &cobra.Command{
+			Use:         "child",
+			Annotations: map[string]string{SectionAnnotation: "serviceRegistry.etcd"},
+		}
+		child.Flags().StringSlice("endpoints", []string{"localhost:2379"}, "")
+		child.Flags().String("prefix", "/", "")
+		child.Flags().String("password", "", "")
+		BindFlag(child.Flags(), "endpoints", ".endpoints")
+		BindFlag(child.Flags(), "prefix", ".prefix")
+		BindFlag(child.Flags(), "password", ".credentials.password")
+		root.AddCommand(child)
+		return child
+	}
+
+	cases := []struct {
+		conf       *Config
+		origins    map[string]Origin
+		args       []string
+		expConf    *Config
+		expOrigins map[string]Origin
+	}{
+		{
+			expConf: &Config{
+				Adaptor:         "localhost:80/cnwan",
+				Delivery:        &DeliverySettings{RateLimitBurst: 1},
+				ServiceRegistry: &ServiceRegistrySettings{Etcd: &EtcdConfig{Endpoints: []string{"localhost:2379"}, Prefix: "/"}},
+			},
+			expOrigins: map[string]Origin{
+				"adaptor":                        OriginDefault,
+				"delivery.rateLimitBurst":        OriginDefault,
+				"serviceRegistry.etcd.endpoints": OriginDefault,
+				"serviceRegistry.etcd.prefix":    OriginDefault,
+			},
+		},
+		{
+			conf: &Config{
+				Adaptor: "example.com",
+				ServiceRegistry: &ServiceRegistrySettings{
+					Etcd:        &EtcdConfig{Prefix: "/prefix"},
+					AWSCloudMap: &CloudMapConfig{Region: "us-west-2"},
+				},
+			},
+			origins: map[string]Origin{
+				"adaptor":                            OriginFile,
+				"serviceRegistry.etcd.prefix":        OriginEnv,
+				"serviceRegistry.awsCloudMap.region": OriginFile,
+			},
+			args: []string{"--adaptor-api", "other.com", "--endpoints", "one:2379,two:2379", "--password", "pass"},
+			expConf: &Config{
+				Adaptor:      "other.com",
+				MetadataKeys: []string{},
+				Delivery:     &DeliverySettings{RateLimitBurst: 1},
+				ServiceRegistry: &ServiceRegistrySettings{
+					Etcd: &EtcdConfig{
+						Endpoints:   []string{"one:2379", "two:2379"},
+						Prefix:      "/prefix",
+						Credentials: &EtcdCredentials{Password: "pass"},
+					},
+				},
+			},
+			expOrigins: map[string]Origin{
+				"adaptor":                                   OriginFlag,
+				"delivery.rateLimitBurst":                   OriginDefault,
+				"serviceRegistry.etcd.endpoints":            OriginFlag,
+				"serviceRegistry.etcd.prefix":               OriginEnv,
+				"serviceRegistry.etcd.credentials.password": OriginFlag,
+				"serviceRegistry.awsCloudMap.region":        OriginFile,
+			},
+		},
+	}
+
+	for i, currCase := range cases {
+		conf, origins = currCase.conf, currCase.origins
+		cmd := newCmd()
+		if !a.NoError(cmd.ParseFlags(currCase.args)) {
+			failed(i)
+		}
+
+		res, resOrigins, err := Resolve(cmd, nil)
+		if !a.NoError(err) || !a.Equal(currCase.expConf, res) || !a.Equal(currCase.expOrigins, resOrigins) {
+			failed(i)
+		}
+	}
+
+	// The configuration is not modified
+	a.Equal(&Config{
+		Adaptor: "example.com",
+		ServiceRegistry: &ServiceRegistrySettings{
+			Etcd:        &EtcdConfig{Prefix: "/prefix"},
+			AWSCloudMap: &CloudMapConfig{Region: "us-west-2"},
+		},
+	}, conf)
+
+	// Sections are resolved by their functions, if any
+	conf, origins = &Config{ServiceRegistry: &ServiceRegistrySettings{Etcd: &EtcdConfig{Prefix: "/prefix"}}}, map[string]Origin{"serviceRegistry.etcd.prefix": OriginFile}
+	cmd := newCmd()
+	a.NoError(cmd.ParseFlags([]string{"--prefix", "/other"}))
+	res, resOrigins, err := Resolve(cmd, map[string]SectionFunc{
+		"serviceRegistry.etcd": func(cmd *cobra.Command, conf *Config) (*Config, map[string]Origin) {
+			return &Config{ServiceRegistry: &ServiceRegistrySettings{Etcd: &EtcdConfig{Prefix: "/resolved"}}},
+				map[string]Origin{"serviceRegistry.etcd.prefix": OriginDefault}
+		},
+	})
+	a.NoError(err)
+	a.Equal(&EtcdConfig{Prefix: "/resolved"}, res.ServiceRegistry.Etcd)
+	a.Equal(OriginDefault, resOrigins["serviceRegistry.etcd.prefix"])
+	a.NotContains(resOrigins, "serviceRegistry.etcd.endpoints")
+}
+
+func TestSetField(t *testing.T) {
+	a := assert.New(t)
+
+	c := &Config{}
+	a.NoError(setField(c, "serviceRegistry.etcd.tls.insecureSkipVerify", "true"))
+	a.Equal(&Config{ServiceRegistry: &ServiceRegistrySettings{Etcd: &EtcdConfig{TLS: &EtcdTLSConfig{InsecureSkipVerify: true}}}}, c)
+	a.EqualError(setField(c, "serviceRegistry.etcd.tls.unknown", "true"), "serviceRegistry.etcd.tls.unknown: unknown field")
+	a.Error(setField(c, "leaderElection.ttl", "ten"))
+}
@@ -0,0 +1,91 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package configuration
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+const (
+	redacted string = "<redacted>"
+)
+
+// WriteWithOrigins writes the configuration to w as yaml, with where the
+// value of each field comes from as a comment. Passwords are redacted.
+func WriteWithOrigins(w io.Writer, conf *Config, origins map[string]Origin) error {
+	node, err := toNode(reflect.ValueOf(conf).Elem(), "", origins)
+	if err != nil {
+		return err
+	}
+
+	enc := yamlv3.NewEncoder(w)
+	enc.SetIndent(2)
+	defer enc.Close()
+	return enc.Encode(node)
+}
+
+func toNode(v reflect.Value, path string, origins map[string]Origin) (*yamlv3.Node, error) {
+	node := &yamlv3.Node{Kind: yamlv3.MappingNode}
+
+	for i := 0; i < v.NumField(); i++ {
+		field, fieldVal := v.Type().Field(i), v.Field(i)
+		name := yamlName(field)
+		fieldPath := joinPath(path, name)
+		if fieldVal.IsZero() || (fieldVal.Kind() == reflect.Slice && fieldVal.Len() == 0) {
+			continue
+		}
+
+		keyNode := &yamlv3.Node{Kind: yamlv3.ScalarNode, Value: name}
+		valNode := &yamlv3.Node{}
+
+		switch {
+		case field.Type.Kind() == reflect.Ptr && field.Type.Elem().Kind() == reflect.Struct:
+			_node, err := toNode(fieldVal.Elem(), fieldPath, origins)
+			if err != nil {
+				return nil, err
+			}
+			if len(_node.Content) == 0 {
+				continue
+			}
+			valNode = _node
+		case field.Type.Kind() == reflect.Slice:
+			if err := valNode.Encode(fieldVal.Interface()); err != nil {
+				return nil, fmt.Errorf("%s: %w", fieldPath, err)
+			}
+			keyNode.LineComment = string(origins[fieldPath])
+		default:
+			val := fieldVal.Interface()
+			if name == "password" {
+				val = redacted
+			}
+			if err := valNode.Encode(val); err != nil {
+				return nil, fmt.Errorf("%s: %w", fieldPath, err)
+			}
+			valNode.LineComment = string(origins[fieldPath])
+		}
+
+		node.Content = append(node.Content, keyNode, valNode)
+	}
+
+	return node, nil
+}
@@ -0,0 +1,61 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package configuration
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteWithOrigins(t *testing.T) {
+	a := assert.New(t)
+	c := &Config{
+		Adaptor:      "localhost:8080",
+		MetadataKeys: []string{"key"},
+		ServiceRegistry: &ServiceRegistrySettings{
+			AWSCloudMap: &CloudMapConfig{Namespaces: []string{}},
+			Etcd: &EtcdConfig{
+				Prefix:      "/",
+				Credentials: &EtcdCredentials{Username: "user", Password: "pass"},
+			},
+		},
+	}
+	origins := map[string]Origin{
+		"adaptor":                     OriginFlag,
+		"metadataKeys":                OriginFile,
+		"serviceRegistry.etcd.prefix": OriginDefault,
+		"serviceRegistry.etcd.credentials.username": OriginEnv,
+		"serviceRegistry.etcd.credentials.password": OriginEnv,
+	}
+
+	out := &bytes.Buffer{}
+	a.NoError(WriteWithOrigins(out, c, origins))
+	a.Equal(`adaptor: localhost:8080 # flag
+metadataKeys: # file
+  - key
+serviceRegistry:
+  etcd:
+    credentials:
+      username: user # env
+      password: <redacted> # env
+    prefix: / # default
+`, out.String())
+}
@@ -24,12 +24,108 @@ type Config struct {
 	DebugMode bool `yaml:"debugMode,omitempty"`
 	// Adaptor specifies the adaptor configuration
 	Adaptor string `yaml:"adaptor,omitempty"`
+	// EventsVersion is the format of the events sent to the adaptor,
+	// i.e. v1 (legacy) or v2
+	EventsVersion string `yaml:"eventsVersion,omitempty"`
+	// Delivery contains settings about how events are sent to the adaptor
+	Delivery *DeliverySettings `yaml:"delivery,omitempty"`
+	// LeaderElection contains settings about the leader election among
+	// more replicas
+	LeaderElection *LeaderElectionSettings `yaml:"leaderElection,omitempty"`
 	// MetadataKeys is the key to look for in a service's metadata
 	MetadataKeys []string `yaml:"metadataKeys"`
+	// ExtraMetadata contains which metadata of services are included in
+	// events, in addition to the metadata keys
+	ExtraMetadata *ExtraMetadataSettings `yaml:"extraMetadata,omitempty"`
+	// Filters are expressions on the values of the metadata that services
+	// must all satisfy to be sent to the adaptor, i.e.
+	// traffic-profile in (video, voip)
+	Filters []string `yaml:"filters,omitempty"`
+	// FilterExpression is an expression on the whole service that services
+	// must satisfy to be sent to the adaptor, i.e.
+	// inCIDR(address, "10.0.0.0/8") && port == 443
+	FilterExpression string `yaml:"filterExpression,omitempty"`
+	// Transforms are changes to the metadata of services, applied in
+	// order before filters, i.e. to use the same keys for all service
+	// registries
+	Transforms []Transform `yaml:"transforms,omitempty"`
 	// ServiceRegistry settings about the service registry to use
 	ServiceRegistry *ServiceRegistrySettings `yaml:"serviceRegistry"`
 }
 
+// ExtraMetadataSettings contains which metadata of services are included
+// in events, in addition to the metadata keys.
+type ExtraMetadataSettings struct {
+	// All specifies whether to include all metadata of services
+	All bool `yaml:"all,omitempty"`
+	// Include are other keys to include, if services have them
+	Include []string `yaml:"include,omitempty"`
+	// Exclude are keys never to include, unless they are among the
+	// metadata keys
+	Exclude []string `yaml:"exclude,omitempty"`
+}
+
+// Transform contains changes to apply to the metadata of services, to
+// normalize them before they are sent to the adaptor.
+type Transform struct {
+	// Source is the service registry whose services are changed, i.e.
+	// etcd. If empty, the services of all registries are changed
+	Source string `yaml:"source,omitempty"`
+	// RenameKeys maps the keys to rename to their new name
+	RenameKeys map[string]string `yaml:"renameKeys,omitempty"`
+	// MapValues maps a key, after renaming it, to a lookup table of its
+	// values
+	MapValues map[string]map[string]string `yaml:"mapValues,omitempty"`
+	// AddMetadata contains static metadata to add to services
+	AddMetadata map[string]string `yaml:"addMetadata,omitempty"`
+}
+
+// DeliverySettings contains settings about how events are sent to the
+// adaptor. A zero value for any of the limits means no limit.
+type DeliverySettings struct {
+	// MaxEventsPerRequest is the maximum number of events sent in a
+	// single request
+	MaxEventsPerRequest int `yaml:"maxEventsPerRequest,omitempty"`
+	// MaxBytesPerRequest is the maximum size, in bytes, of the events sent
+	// in a single request
+	MaxBytesPerRequest int `yaml:"maxBytesPerRequest,omitempty"`
+	// RateLimit is the maximum number of requests per second
+	RateLimit float64 `yaml:"rateLimit,omitempty"`
+	// RateLimitBurst is the number of requests that can be sent at once
+	// before the rate limit kicks in
+	RateLimitBurst int `yaml:"rateLimitBurst,omitempty"`
+	// DrainTimeout is the number of seconds to wait for the events in the
+	// queue to be sent when exiting
+	DrainTimeout int `yaml:"drainTimeout,omitempty"`
+	// PendingEventsFile is the path of the file where events that could
+	// not be sent when exiting are saved, to be sent on next run
+	PendingEventsFile string `yaml:"pendingEventsFile,omitempty"`
+}
+
+// LeaderElectionSettings contains settings about the leader election
+// among more replicas of the program, of which only the leader sends
+// events to the adaptor.
+type LeaderElectionSettings struct {
+	// Enabled specifies whether to take part in the leader election
+	Enabled bool `yaml:"enabled,omitempty"`
+	// ID identifies this replica among the others
+	ID string `yaml:"id,omitempty"`
+	// Key is the prefix of the etcd keys used for the election
+	Key string `yaml:"key,omitempty"`
+	// TTL is the number of seconds after which the leader is considered
+	// dead if it doesn't renew its lease
+	TTL int `yaml:"ttl,omitempty"`
+	// Endpoints of the etcd cluster used for the election
+	Endpoints []string `yaml:"endpoints,omitempty"`
+	// Username to authenticate to etcd
+	Username string `yaml:"username,omitempty"`
+	// Password to authenticate to etcd
+	Password string `yaml:"password,omitempty"`
+	// PasswordFile is the path of a file containing the password, i.e. a
+	// mounted secret, to use instead of Password
+	PasswordFile string `yaml:"passwordFile,omitempty"`
+}
+
 // ServiceRegistrySettings contains information
 type ServiceRegistrySettings struct {
 	// GCPServiceDirectory is the field with configuration about service
@@ -37,6 +133,8 @@ type ServiceRegistrySettings struct {
 	GCPServiceDirectory *ServiceDirectoryConfig `yaml:"gcpServiceDirectory,omitempty"`
 	// AWSCloudMap contains configuration about AWS CloudMap
 	AWSCloudMap *CloudMapConfig `yaml:"awsCloudMap,omitempty"`
+	// Etcd contains configuration about etcd
+	Etcd *EtcdConfig `yaml:"etcd,omitempty"`
 }
 
 // ServiceDirectoryConfig contains Service Directory configuration.
@@ -49,16 +147,110 @@ type ServiceDirectoryConfig struct {
 	ProjectID string `yaml:"projectID"`
 	// Region where to look for
 	Region string `yaml:"region"`
+	// Locations are the projects and regions where to look for, in the
+	// form of project/region or just project for all its regions. If
+	// provided, ProjectID and Region are ignored
+	Locations []string `yaml:"locations,omitempty"`
 	// ServiceAccountPath is the path of the service account JSON
 	ServiceAccountPath string `yaml:"serviceAccountPath"`
+	// MetadataPrecedence is which of endpoint or service wins when a key is
+	// in the annotations of both, i.e. endpoint or service
+	MetadataPrecedence string `yaml:"metadataPrecedence,omitempty"`
+}
+
+// CloudMapAccount is an AWS account where to look for services, by assuming
+// a role in it.
+type CloudMapAccount struct {
+	// RoleARN is the ARN of the role to assume
+	RoleARN string `yaml:"roleARN"`
+	// ExternalID is the external id to use when assuming the role
+	ExternalID string `yaml:"externalID,omitempty"`
+	// Regions where to look for in this account. If empty, the regions of
+	// the Cloud Map configuration are used
+	Regions []string `yaml:"regions,omitempty"`
 }
 
 // CloudMapConfig contans data need to connect to AWS Cloud Map correctly.
 type CloudMapConfig struct {
 	// Region where to look for
 	Region string `yaml:"region,omitempty"`
+	// Regions where to look for, if more than one. If provided, Region is
+	// ignored
+	Regions []string `yaml:"regions,omitempty"`
+	// Accounts are other accounts where to look for, by assuming a role in
+	// each of them
+	Accounts []CloudMapAccount `yaml:"accounts,omitempty"`
 	// CredentialsPath is the path where to find the AWS credentials.
 	CredentialsPath string `yaml:"credentialsPath,omitempty"`
+	// Profile is the name of the profile to use from the credentials and
+	// config files
+	Profile string `yaml:"profile,omitempty"`
+	// RoleARN is the ARN of the role to assume
+	RoleARN string `yaml:"roleARN,omitempty"`
+	// ExternalID is the external id to use when assuming the role
+	ExternalID string `yaml:"externalID,omitempty"`
+	// RoleSessionName is the session name to use when assuming the role
+	RoleSessionName string `yaml:"roleSessionName,omitempty"`
+	// WebIdentityTokenFile is the path of the web identity token file to
+	// use to assume the role
+	WebIdentityTokenFile string `yaml:"webIdentityTokenFile,omitempty"`
+	// EndpointURL is the URL of Cloud Map, in case it is not the default
+	// one
+	EndpointURL string `yaml:"endpointURL,omitempty"`
 	// PollInterval is the number of seconds between two consecutive polls
 	PollInterval int `yaml:"pollInterval,omitempty"`
+	// Namespaces contains IDs or names of the only namespaces to watch
+	Namespaces []string `yaml:"namespaces,omitempty"`
+	// ExcludeNamespaces contains IDs or names of the namespaces to ignore
+	ExcludeNamespaces []string `yaml:"excludeNamespaces,omitempty"`
+	// HealthStatus is how the health status of instances is handled,
+	// i.e. ignore, exclude or metadata
+	HealthStatus string `yaml:"healthStatus,omitempty"`
+	// MetadataSource is where metadata is read from, i.e. attributes,
+	// tags or both
+	MetadataSource string `yaml:"metadataSource,omitempty"`
+	// MetadataPrecedence is which of instance or service wins when
+	// metadata is read from both, i.e. instance or service
+	MetadataPrecedence string `yaml:"metadataPrecedence,omitempty"`
+}
+
+// EtcdConfig contains data needed to connect to etcd correctly.
+// Its fields are the same as the CLI flags, although the latter can override
+// them.
+type EtcdConfig struct {
+	// Endpoints of the etcd nodes, in the form of host:port
+	Endpoints []string `yaml:"endpoints,omitempty"`
+	// Credentials to authenticate to etcd, if authentication mode is
+	// enabled
+	Credentials *EtcdCredentials `yaml:"credentials,omitempty"`
+	// Prefix where the service registry objects are stored
+	Prefix string `yaml:"prefix,omitempty"`
+	// TLS contains settings to connect to etcd through TLS
+	TLS *EtcdTLSConfig `yaml:"tls,omitempty"`
+}
+
+// EtcdCredentials contains the username and password to authenticate to
+// etcd.
+type EtcdCredentials struct {
+	// Username to authenticate as
+	Username string `yaml:"username,omitempty"`
+	// Password for this username
+	Password string `yaml:"password,omitempty"`
+	// PasswordFile is the path of a file containing the password, i.e. a
+	// mounted secret, to use instead of Password
+	PasswordFile string `yaml:"passwordFile,omitempty"`
+}
+
+// EtcdTLSConfig contains settings to connect to etcd through TLS.
+type EtcdTLSConfig struct {
+	// CAFile is the path of the CA bundle used to verify the certificates
+	// of the etcd nodes
+	CAFile string `yaml:"caFile,omitempty"`
+	// CertFile is the path of the client certificate
+	CertFile string `yaml:"certFile,omitempty"`
+	// KeyFile is the path of the key of the client certificate
+	KeyFile string `yaml:"keyFile,omitempty"`
+	// InsecureSkipVerify specifies whether to skip the verification of
+	// the certificates of the etcd nodes
+	InsecureSkipVerify bool `yaml:"insecureSkipVerify,omitempty"`
 }
@@ -0,0 +1,310 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package configuration
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/filter"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/services"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/transform"
+	"gopkg.in/yaml.v2"
+)
+
+// FieldError is a problem with a field of the configuration.
+type FieldError struct {
+	// Path of the field, i.e. serviceRegistry.etcd.prefix
+	Path string
+	// Message describes the problem
+	Message string
+}
+
+// Error returns the path of the field followed by the problem.
+func (f *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", f.Path, f.Message)
+}
+
+// ValidationError contains all the problems found in a configuration.
+type ValidationError struct {
+	// Errors found, in the same order as the fields
+	Errors []*FieldError
+}
+
+// Error returns all the problems, separated by a semicolon.
+func (v *ValidationError) Error() string {
+	errs := make([]string, len(v.Errors))
+	for i, err := range v.Errors {
+		errs[i] = err.Error()
+	}
+
+	return "invalid configuration: " + strings.Join(errs, "; ")
+}
+
+type validator struct {
+	errs []*FieldError
+}
+
+func (v *validator) add(path, format string, args ...interface{}) {
+	v.errs = append(v.errs, &FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) nonNegative(path string, value float64) {
+	if value < 0 {
+		v.add(path, "cannot be negative")
+	}
+}
+
+func (v *validator) oneOf(path, value string, allowed ...string) {
+	if len(value) == 0 {
+		return
+	}
+
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+
+	v.add(path, "unsupported value %q, must be one of %s", value, strings.Join(allowed, ", "))
+}
+
+func (v *validator) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+
+	return &ValidationError{Errors: v.errs}
+}
+
+// checkUnknownFields returns the paths of all the fields in the yaml file
+// that are not part of the configuration.
+func checkUnknownFields(yamlFile []byte) ([]*FieldError, error) {
+	var raw interface{}
+	if err := yaml.Unmarshal(yamlFile, &raw); err != nil {
+		return nil, err
+	}
+
+	v := &validator{}
+	v.unknownFields(raw, reflect.TypeOf(Config{}), "")
+	return v.errs, nil
+}
+
+func (v *validator) unknownFields(raw interface{}, t reflect.Type, path string) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		values, ok := raw.(map[interface{}]interface{})
+		if !ok {
+			// Wrong types are reported when decoding.
+			return
+		}
+
+		fields := map[string]reflect.Type{}
+		for i := 0; i < t.NumField(); i++ {
+			name := yamlName(t.Field(i))
+			fields[name] = t.Field(i).Type
+		}
+
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, fmt.Sprint(key))
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			fieldPath := key
+			if len(path) > 0 {
+				fieldPath = path + "." + key
+			}
+
+			fieldType, exists := fields[key]
+			if !exists {
+				v.add(fieldPath, "unknown field")
+				continue
+			}
+			v.unknownFields(values[key], fieldType, fieldPath)
+		}
+	case reflect.Slice:
+		values, ok := raw.([]interface{})
+		if !ok {
+			return
+		}
+
+		for i, value := range values {
+			v.unknownFields(value, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+}
+
+// Validate checks that the values of the configuration are valid and
+// returns a *ValidationError with all the problems found, if any.
+// Fields that are not set are not checked, as they can still be provided
+// with flags.
+func (c *Config) Validate() error {
+	v := &validator{}
+	v.validate(c)
+	return v.err()
+}
+
+func (v *validator) validate(c *Config) {
+
+	if len(c.Adaptor) > 0 {
+		if _, err := url.ParseRequestURI("http://" + c.Adaptor); err != nil {
+			v.add("adaptor", "invalid endpoint: %s", err)
+		}
+	}
+
+	if len(c.EventsVersion) > 0 && !services.IsValidEventsVersion(c.EventsVersion) {
+		v.add("eventsVersion", "unsupported value %q, must be one of %s, %s", c.EventsVersion, services.EventsV1, services.EventsV2)
+	}
+
+	for i, key := range c.MetadataKeys {
+		if len(strings.TrimSpace(key)) == 0 {
+			v.add(fmt.Sprintf("metadataKeys[%d]", i), "cannot be empty")
+		}
+	}
+
+	if em := c.ExtraMetadata; em != nil {
+		for i, key := range em.Include {
+			if len(strings.TrimSpace(key)) == 0 {
+				v.add(fmt.Sprintf("extraMetadata.include[%d]", i), "cannot be empty")
+			}
+		}
+		for i, key := range em.Exclude {
+			if len(strings.TrimSpace(key)) == 0 {
+				v.add(fmt.Sprintf("extraMetadata.exclude[%d]", i), "cannot be empty")
+			}
+		}
+	}
+
+	for i, expr := range c.Filters {
+		if _, err := filter.New([]string{expr}, ""); err != nil {
+			v.add(fmt.Sprintf("filters[%d]", i), "%s", err)
+		}
+	}
+
+	if _, err := filter.Compile(c.FilterExpression); err != nil {
+		v.add("filterExpression", "%s", err)
+	}
+
+	for i, t := range c.Transforms {
+		path := fmt.Sprintf("transforms[%d]", i)
+		v.oneOf(path+".source", t.Source, "cloudmap", "etcd", "servicedirectory")
+		rule := transform.Rule{RenameKeys: t.RenameKeys, MapValues: t.MapValues, AddMetadata: t.AddMetadata}
+		if _, err := transform.New([]transform.Rule{rule}); err != nil {
+			v.add(path, "%s", errors.Unwrap(err))
+		}
+	}
+
+	if d := c.Delivery; d != nil {
+		v.nonNegative("delivery.maxEventsPerRequest", float64(d.MaxEventsPerRequest))
+		v.nonNegative("delivery.maxBytesPerRequest", float64(d.MaxBytesPerRequest))
+		v.nonNegative("delivery.rateLimit", d.RateLimit)
+		v.nonNegative("delivery.rateLimitBurst", float64(d.RateLimitBurst))
+		v.nonNegative("delivery.drainTimeout", float64(d.DrainTimeout))
+	}
+
+	if le := c.LeaderElection; le != nil {
+		v.nonNegative("leaderElection.ttl", float64(le.TTL))
+		if len(le.Password) > 0 && len(le.Username) == 0 {
+			v.add("leaderElection.password", "provided but no username")
+		}
+	}
+
+	if c.ServiceRegistry != nil {
+		v.validateServiceDirectory(c.ServiceRegistry.GCPServiceDirectory)
+		v.validateCloudMap(c.ServiceRegistry.AWSCloudMap)
+		v.validateEtcd(c.ServiceRegistry.Etcd)
+	}
+}
+
+func (v *validator) validateServiceDirectory(sd *ServiceDirectoryConfig) {
+	if sd == nil {
+		return
+	}
+	const path = "serviceRegistry.gcpServiceDirectory"
+
+	v.nonNegative(path+".pollInterval", float64(sd.PollingInterval))
+	if len(sd.Locations) > 0 && (len(sd.ProjectID) > 0 || len(sd.Region) > 0) {
+		v.add(path+".locations", "cannot be used together with projectID and region")
+	}
+
+	for i, loc := range sd.Locations {
+		parts := strings.Split(loc, "/")
+		if len(parts) > 2 || len(parts[0]) == 0 || (len(parts) == 2 && len(parts[1]) == 0) {
+			v.add(fmt.Sprintf("%s.locations[%d]", path, i), "invalid location %q, must be project/region or project", loc)
+		}
+	}
+
+	v.oneOf(path+".metadataPrecedence", sd.MetadataPrecedence, "endpoint", "service")
+}
+
+func (v *validator) validateCloudMap(cm *CloudMapConfig) {
+	if cm == nil {
+		return
+	}
+	const path = "serviceRegistry.awsCloudMap"
+
+	v.nonNegative(path+".pollInterval", float64(cm.PollInterval))
+	if len(cm.Regions) > 0 && len(cm.Region) > 0 {
+		v.add(path+".regions", "cannot be used together with region")
+	}
+
+	for i, acc := range cm.Accounts {
+		if len(acc.RoleARN) == 0 {
+			v.add(fmt.Sprintf("%s.accounts[%d].roleARN", path, i), "is required")
+		}
+	}
+
+	if len(cm.ExternalID) > 0 && len(cm.WebIdentityTokenFile) > 0 {
+		v.add(path+".externalID", "cannot be used together with webIdentityTokenFile")
+	}
+
+	if len(cm.EndpointURL) > 0 {
+		if u, err := url.ParseRequestURI(cm.EndpointURL); err != nil || len(u.Host) == 0 {
+			v.add(path+".endpointURL", "invalid URL %q", cm.EndpointURL)
+		}
+	}
+
+	v.oneOf(path+".healthStatus", cm.HealthStatus, "ignore", "exclude", "metadata")
+	v.oneOf(path+".metadataSource", cm.MetadataSource, "attributes", "tags", "both")
+	v.oneOf(path+".metadataPrecedence", cm.MetadataPrecedence, "instance", "service")
+}
+
+func (v *validator) validateEtcd(etcd *EtcdConfig) {
+	if etcd == nil {
+		return
+	}
+	const path = "serviceRegistry.etcd"
+
+	for i, endp := range etcd.Endpoints {
+		if len(strings.TrimSpace(endp)) == 0 {
+			v.add(fmt.Sprintf("%s.endpoints[%d]", path, i), "cannot be empty")
+		}
+	}
+
+}
@@ -0,0 +1,259 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckUnknownFields(t *testing.T) {
+	a := assert.New(t)
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+
+	cases := []struct {
+		content string
+		expErrs []*FieldError
+	}{
+		{
+			content: "adaptor: localhost\nserviceRegistry:\n  etcd:\n    prefix: /\n",
+		},
+		{
+			content: "adaptr: localhost\nserviceRegistry:\n  etcd:\n    prefx: /\n    tls:\n      caFile: ca\n",
+			expErrs: []*FieldError{
+				{Path: "adaptr", Message: "unknown field"},
+				{Path: "serviceRegistry.etcd.prefx", Message: "unknown field"},
+			},
+		},
+		{
+			content: "serviceRegistry:\n  awsCloudMap:\n    accounts:\n      - roleARN: arn\n      - rolearn: arn\n",
+			expErrs: []*FieldError{
+				{Path: "serviceRegistry.awsCloudMap.accounts[1].rolearn", Message: "unknown field"},
+			},
+		},
+	}
+
+	for i, currCase := range cases {
+		errs, err := checkUnknownFields([]byte(currCase.content))
+		if !a.NoError(err) || !a.Equal(currCase.expErrs, errs) {
+			failed(i)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	a := assert.New(t)
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+
+	cases := []struct {
+		conf    *Config
+		expErrs []string
+	}{
+		{
+			conf: &Config{
+				Adaptor:       "localhost:8080/cnwan",
+				EventsVersion: "v1",
+				MetadataKeys:  []string{"key"},
+				Transforms:    []Transform{{Source: "etcd", RenameKeys: map[string]string{"cnwan.io/key": "key"}}, {AddMetadata: map[string]string{"site": "eu-west"}}},
+				Delivery:      &DeliverySettings{RateLimit: 2},
+				ServiceRegistry: &ServiceRegistrySettings{
+					GCPServiceDirectory: &ServiceDirectoryConfig{Locations: []string{"project/region", "project"}},
+					AWSCloudMap:         &CloudMapConfig{Regions: []string{"us-west-2"}, EndpointURL: "http://localhost:4566"},
+					Etcd:                &EtcdConfig{Endpoints: []string{"localhost:2379"}},
+				},
+			},
+		},
+		{
+			conf: &Config{
+				Adaptor:          "local host",
+				EventsVersion:    "v3",
+				MetadataKeys:     []string{""},
+				ExtraMetadata:    &ExtraMetadataSettings{Include: []string{"site", " "}, Exclude: []string{""}},
+				Filters:          []string{"key in (a, b)", "key in ()"},
+				FilterExpression: "port == \"443\"",
+				Transforms:       []Transform{{Source: "consul", AddMetadata: map[string]string{"": "eu-west"}}},
+				Delivery:         &DeliverySettings{MaxEventsPerRequest: -1, RateLimit: -1},
+				LeaderElection:   &LeaderElectionSettings{TTL: -1, Password: "pass"},
+			},
+			expErrs: []string{
+				`adaptor: invalid endpoint: parse "http://local host": invalid character " " in host name`,
+				`eventsVersion: unsupported value "v3", must be one of v1, v2`,
+				"metadataKeys[0]: cannot be empty",
+				"extraMetadata.include[1]: cannot be empty",
+				"extraMetadata.exclude[0]: cannot be empty",
+				`filters[1]: invalid filter "key in ()": no values provided`,
+				"filterExpression: found no matching overload for '_==_' applied to '(int, string)' at line 1, column 6",
+				`transforms[0].source: unsupported value "consul", must be one of cloudmap, etcd, servicedirectory`,
+				"transforms[0]: metadata with an empty key cannot be added",
+				"delivery.maxEventsPerRequest: cannot be negative",
+				"delivery.rateLimit: cannot be negative",
+				"leaderElection.ttl: cannot be negative",
+				"leaderElection.password: provided but no username",
+			},
+		},
+		{
+			conf: &Config{
+				ServiceRegistry: &ServiceRegistrySettings{
+					GCPServiceDirectory: &ServiceDirectoryConfig{
+						PollingInterval:    -5,
+						ProjectID:          "project",
+						Locations:          []string{"project/", "a/b/c"},
+						MetadataPrecedence: "instance",
+					},
+					AWSCloudMap: &CloudMapConfig{
+						Region:               "us-west-2",
+						Regions:              []string{"us-west-2"},
+						Accounts:             []CloudMapAccount{{ExternalID: "id"}},
+						ExternalID:           "id",
+						WebIdentityTokenFile: "token",
+						EndpointURL:          "localhost:4566",
+						HealthStatus:         "all",
+						MetadataSource:       "labels",
+						MetadataPrecedence:   "endpoint",
+					},
+					Etcd: &EtcdConfig{Endpoints: []string{" "}},
+				},
+			},
+			expErrs: []string{
+				"serviceRegistry.gcpServiceDirectory.pollInterval: cannot be negative",
+				"serviceRegistry.gcpServiceDirectory.locations: cannot be used together with projectID and region",
+				`serviceRegistry.gcpServiceDirectory.locations[0]: invalid location "project/", must be project/region or project`,
+				`serviceRegistry.gcpServiceDirectory.locations[1]: invalid location "a/b/c", must be project/region or project`,
+				`serviceRegistry.gcpServiceDirectory.metadataPrecedence: unsupported value "instance", must be one of endpoint, service`,
+				"serviceRegistry.awsCloudMap.regions: cannot be used together with region",
+				"serviceRegistry.awsCloudMap.accounts[0].roleARN: is required",
+				"serviceRegistry.awsCloudMap.externalID: cannot be used together with webIdentityTokenFile",
+				`serviceRegistry.awsCloudMap.endpointURL: invalid URL "localhost:4566"`,
+				`serviceRegistry.awsCloudMap.healthStatus: unsupported value "all", must be one of ignore, exclude, metadata`,
+				`serviceRegistry.awsCloudMap.metadataSource: unsupported value "labels", must be one of attributes, tags, both`,
+				`serviceRegistry.awsCloudMap.metadataPrecedence: unsupported value "endpoint", must be one of instance, service`,
+				"serviceRegistry.etcd.endpoints[0]: cannot be empty",
+			},
+		},
+	}
+
+	for i, currCase := range cases {
+		err := currCase.conf.Validate()
+		if len(currCase.expErrs) == 0 {
+			if !a.NoError(err) {
+				failed(i)
+			}
+			continue
+		}
+
+		valErr, ok := err.(*ValidationError)
+		if !a.True(ok) {
+			failed(i)
+		}
+
+		errs := []string{}
+		for _, fieldErr := range valErr.Errors {
+			errs = append(errs, fieldErr.Error())
+		}
+		if !a.Equal(currCase.expErrs, errs) {
+			failed(i)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	a := assert.New(t)
+
+	_, _, err := parse([]byte("adaptor: localhost\nadaptor: localhost:8080\n"))
+	a.Error(err)
+
+	_, _, err = parse([]byte("serviceRegistry:\n  awsCloudMap:\n    pollInterval: ten\n"))
+	a.Error(err)
+
+	_, _, err = parse([]byte("serviceRegistry:\n  awsCloudMap:\n    pollIntreval: 10\n"))
+	a.EqualError(err, "invalid configuration: serviceRegistry.awsCloudMap.pollIntreval: unknown field")
+
+	_, _, err = parse([]byte("eventsVersion: v3\nadaptr: localhost\n"))
+	a.EqualError(err, `invalid configuration: adaptr: unknown field; eventsVersion: unsupported value "v3", must be one of v1, v2`)
+
+	_, _, err = parse([]byte("adaptor: localhost\n  - wrong\n"))
+	a.Error(err)
+}
+
+func TestExampleConfig(t *testing.T) {
+	a := assert.New(t)
+
+	_, err := Load("../../examples/config/config.yaml")
+	a.NoError(err)
+}
+
+// TestSchema checks that the JSON schema of the configuration has exactly
+// the same fields of Config.
+func TestSchema(t *testing.T) {
+	a := assert.New(t)
+	content, err := ioutil.ReadFile("../../examples/config/config.schema.json")
+	if !a.NoError(err) {
+		return
+	}
+
+	var schema map[string]interface{}
+	if !a.NoError(json.Unmarshal(content, &schema)) {
+		return
+	}
+
+	var check func(schema map[string]interface{}, t reflect.Type, path string)
+	check = func(schema map[string]interface{}, t reflect.Type, path string) {
+		switch t.Kind() {
+		case reflect.Ptr:
+			check(schema, t.Elem(), path)
+		case reflect.Slice:
+			if items, ok := schema["items"].(map[string]interface{}); a.True(ok, path) {
+				check(items, t.Elem(), path+"[]")
+			}
+		case reflect.Struct:
+			a.Equal(false, schema["additionalProperties"], path)
+			props, _ := schema["properties"].(map[string]interface{})
+
+			fields := []string{}
+			for i := 0; i < t.NumField(); i++ {
+				name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+				fields = append(fields, name)
+				if prop, ok := props[name].(map[string]interface{}); a.True(ok, path+"."+name) {
+					check(prop, t.Field(i).Type, path+"."+name)
+				}
+			}
+
+			schemaFields := []string{}
+			for name := range props {
+				schemaFields = append(schemaFields, name)
+			}
+			sort.Strings(fields)
+			sort.Strings(schemaFields)
+			a.Equal(fields, schemaFields, path)
+		}
+	}
+
+	check(schema, reflect.TypeOf(Config{}), "")
+}
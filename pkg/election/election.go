@@ -0,0 +1,197 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package election
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+const (
+	// DefaultKey is the default prefix of the keys used for the election.
+	DefaultKey string = "/cnwan-reader/leader"
+	// DefaultTTL is the default number of seconds after which the leader is
+	// considered dead if it doesn't renew its lease.
+	DefaultTTL int = 15
+
+	retryInterval time.Duration = 5 * time.Second
+	dialTimeout   time.Duration = 5 * time.Second
+)
+
+// Options contains settings about the leader election.
+type Options struct {
+	// ID identifies this replica among the others, i.e. the pod name.
+	ID string
+	// Key is the prefix of the keys used for the election. All replicas
+	// must use the same one.
+	Key string
+	// TTL is the number of seconds after which the leader is considered
+	// dead if it doesn't renew its lease.
+	TTL int
+	// Endpoints of the etcd cluster used for the election.
+	Endpoints []string
+	// Username to authenticate to etcd, if any.
+	Username string
+	// Password to authenticate to etcd, if any.
+	Password string
+}
+
+// Validate returns an error if any of the options is not valid.
+func (o *Options) Validate() error {
+	if len(o.ID) == 0 {
+		return fmt.Errorf("no leader election id provided")
+	}
+
+	if len(o.Key) == 0 {
+		return fmt.Errorf("no leader election key provided")
+	}
+
+	if o.TTL <= 0 {
+		return fmt.Errorf("invalid leader election ttl: %d", o.TTL)
+	}
+
+	if (len(o.Username) > 0) != (len(o.Password) > 0) {
+		return fmt.Errorf("leader election username and password must be both set or both empty")
+	}
+
+	return nil
+}
+
+// NewClient returns a new etcd client that connects to the endpoints
+// included in the options.
+func NewClient(opts *Options) (*clientv3.Client, error) {
+	if len(opts.Endpoints) == 0 {
+		return nil, fmt.Errorf("no leader election endpoints provided")
+	}
+
+	return clientv3.New(clientv3.Config{
+		Endpoints:   opts.Endpoints,
+		Username:    opts.Username,
+		Password:    opts.Password,
+		DialTimeout: dialTimeout,
+	})
+}
+
+// Elector takes part in the election on behalf of this replica.
+type Elector struct {
+	cli     *clientv3.Client
+	opts    Options
+	leader  int32
+	elected chan struct{}
+}
+
+// New returns a new Elector that uses the provided etcd client.
+// The election only starts when Run is called.
+func New(cli *clientv3.Client, opts Options) *Elector {
+	return &Elector{
+		cli:     cli,
+		opts:    opts,
+		elected: make(chan struct{}, 1),
+	}
+}
+
+// IsLeader returns true if this replica is currently the leader.
+func (e *Elector) IsLeader() bool {
+	return atomic.LoadInt32(&e.leader) == 1
+}
+
+// Elected returns a channel that receives a value every time this replica
+// becomes the leader, so that it can send the whole current state to the
+// adaptor, as it may have missed events while it was a follower.
+func (e *Elector) Elected() <-chan struct{} {
+	return e.elected
+}
+
+// Run takes part in the election until the context is done, campaigning
+// again every time the leadership is lost. When the context is done, the
+// leadership is released so that another replica can take over.
+//
+// This function blocks and is supposed to be run in a separate goroutine.
+func (e *Elector) Run(ctx context.Context) {
+	l := log.With().Str("func", "election.Run").Str("id", e.opts.ID).Logger()
+
+	for {
+		if err := e.campaign(ctx); err != nil {
+			l.Err(err).Msg("error while campaigning for leadership, retrying...")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// campaign waits for this replica to become the leader and returns when the
+// leadership is lost or the context is done.
+func (e *Elector) campaign(ctx context.Context) error {
+	l := log.With().Str("func", "election.campaign").Str("id", e.opts.ID).Logger()
+
+	// The session is not bound to ctx, because it must still be usable to
+	// release the leadership after ctx is done.
+	sess, err := concurrency.NewSession(e.cli, concurrency.WithTTL(e.opts.TTL))
+	if err != nil {
+		return fmt.Errorf("could not create session: %w", err)
+	}
+	defer sess.Close()
+
+	// Stop campaigning if the lease expires while waiting
+	campCtx, campCanc := context.WithCancel(ctx)
+	defer campCanc()
+	go func() {
+		select {
+		case <-sess.Done():
+			campCanc()
+		case <-campCtx.Done():
+		}
+	}()
+
+	l.Info().Msg("campaigning for leadership...")
+	election := concurrency.NewElection(sess, e.opts.Key)
+	if err := election.Campaign(campCtx, e.opts.ID); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		return fmt.Errorf("could not campaign: %w", err)
+	}
+
+	l.Info().Msg("elected as leader")
+	atomic.StoreInt32(&e.leader, 1)
+	defer atomic.StoreInt32(&e.leader, 0)
+	select {
+	case e.elected <- struct{}{}:
+	default:
+	}
+
+	select {
+	case <-ctx.Done():
+		l.Info().Msg("releasing leadership...")
+		return nil
+	case <-sess.Done():
+		return fmt.Errorf("leadership lost")
+	}
+}
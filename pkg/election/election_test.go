@@ -0,0 +1,155 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package election
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/queue"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeLeadership struct {
+	leader bool
+}
+
+func (f *fakeLeadership) IsLeader() bool {
+	return f.leader
+}
+
+type fakeQueue struct {
+	enqueued int
+	last     map[string]*openapi.Event
+}
+
+func (f *fakeQueue) Enqueue(events map[string]*openapi.Event) {
+	f.enqueued++
+	f.last = events
+}
+
+func (f *fakeQueue) Close(_ context.Context) []queue.PendingEvent {
+	return []queue.PendingEvent{}
+}
+
+func TestLeaderQueue(t *testing.T) {
+	a := assert.New(t)
+	events := map[string]*openapi.Event{"one": {Event: "create"}}
+
+	fq := &fakeQueue{}
+	leadership := &fakeLeadership{}
+	q := &LeaderQueue{Queue: fq, leadership: leadership, missed: map[string]*openapi.Event{}}
+
+	q.Enqueue(events)
+	a.Zero(fq.enqueued)
+
+	leadership.leader = true
+	q.Enqueue(events)
+	a.Equal(1, fq.enqueued)
+
+	leadership.leader = false
+	q.Enqueue(events)
+	a.Equal(1, fq.enqueued)
+}
+
+func TestLeaderQueueResync(t *testing.T) {
+	a := assert.New(t)
+
+	fq := &fakeQueue{}
+	leadership := &fakeLeadership{}
+	q := &LeaderQueue{Queue: fq, leadership: leadership, missed: map[string]*openapi.Event{}}
+
+	// Nothing to send
+	leadership.leader = true
+	q.Resync()
+	a.Zero(fq.enqueued)
+
+	// Services deleted while this is a follower are sent when it becomes
+	// the leader, unless they have been created again in the meantime.
+	leadership.leader = false
+	q.Enqueue(map[string]*openapi.Event{"one": {Event: "delete"}, "two": {Event: "delete"}})
+	q.Enqueue(map[string]*openapi.Event{"two": {Event: "create"}, "three": {Event: "update"}})
+	q.Resync()
+	a.Zero(fq.enqueued)
+
+	leadership.leader = true
+	q.Resync()
+	a.Equal(1, fq.enqueued)
+	a.Equal(map[string]*openapi.Event{"one": {Event: "delete"}}, fq.last)
+
+	// They are only sent once
+	q.Resync()
+	a.Equal(1, fq.enqueued)
+
+	// Deletes are not kept while this is the leader
+	q.Enqueue(map[string]*openapi.Event{"two": {Event: "delete"}})
+	a.Equal(2, fq.enqueued)
+	q.Resync()
+	a.Equal(2, fq.enqueued)
+}
+
+func TestOptionsValidate(t *testing.T) {
+	a := assert.New(t)
+	valid := func() *Options {
+		return &Options{ID: "reader-1", Key: DefaultKey, TTL: DefaultTTL}
+	}
+
+	cases := []struct {
+		opts   func(*Options)
+		expErr bool
+	}{
+		{
+			opts: func(o *Options) {},
+		},
+		{
+			opts:   func(o *Options) { o.ID = "" },
+			expErr: true,
+		},
+		{
+			opts:   func(o *Options) { o.Key = "" },
+			expErr: true,
+		},
+		{
+			opts:   func(o *Options) { o.TTL = 0 },
+			expErr: true,
+		},
+		{
+			opts:   func(o *Options) { o.Username = "user" },
+			expErr: true,
+		},
+		{
+			opts: func(o *Options) { o.Username, o.Password = "user", "pass" },
+		},
+	}
+
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+
+	for i, currCase := range cases {
+		opts := valid()
+		currCase.opts(opts)
+
+		if err := opts.Validate(); !a.Equal(currCase.expErr, err != nil) {
+			failed(i)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package election
+
+import (
+	"sync"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/queue"
+)
+
+// leadership tells whether this replica is the leader.
+type leadership interface {
+	IsLeader() bool
+}
+
+// LeaderQueue is a queue that only enqueues events while this replica is
+// the leader. Delete events discarded while this replica is a follower are
+// kept, so that they can be sent when it becomes the leader: the services
+// they refer to are not part of the current state anymore, so they would
+// be missed otherwise.
+type LeaderQueue struct {
+	queue.Queue
+	leadership leadership
+	lock       sync.Mutex
+	// missed contains the delete events discarded while this replica was
+	// a follower, for services that have not been created again since.
+	missed map[string]*openapi.Event
+}
+
+// Queue returns a queue that only enqueues events to q while this replica
+// is the leader, and discards them otherwise.
+func Queue(q queue.Queue, e *Elector) *LeaderQueue {
+	return &LeaderQueue{Queue: q, leadership: e, missed: map[string]*openapi.Event{}}
+}
+
+// Enqueue enqueues the events if this replica is the leader, and keeps
+// track of the delete events that are discarded otherwise.
+func (l *LeaderQueue) Enqueue(events map[string]*openapi.Event) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.leadership.IsLeader() {
+		l.Queue.Enqueue(events)
+		return
+	}
+
+	for key, ev := range events {
+		if ev.Event == "delete" {
+			l.missed[key] = ev
+		} else {
+			delete(l.missed, key)
+		}
+	}
+}
+
+// Resync enqueues the delete events discarded while this replica was a
+// follower, if it is the leader now. It is supposed to be called every time
+// this replica becomes the leader, along with sending the current state.
+func (l *LeaderQueue) Resync() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if !l.leadership.IsLeader() || len(l.missed) == 0 {
+		return
+	}
+
+	l.Queue.Enqueue(l.missed)
+	l.missed = map[string]*openapi.Event{}
+}
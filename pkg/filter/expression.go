@@ -0,0 +1,248 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package filter
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter/functions"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+const (
+	// maxExpressionLength is the maximum length of an expression.
+	maxExpressionLength int = 4096
+	// inCIDRFunction is the name of the function that checks whether an
+	// address is in a network, which is not part of CEL.
+	inCIDRFunction string = "inCIDR"
+)
+
+// Expression is a CEL expression on the services, i.e.
+// inCIDR(address, "10.0.0.0/8") && port == 443. A nil Expression matches
+// everything.
+type Expression struct {
+	src     string
+	program cel.Program
+}
+
+// Compile parses the provided expression and checks its types, returning
+// nil if the expression is empty.
+//
+// Expressions are written in CEL and can use the variables source, name,
+// address, serviceNamespace and serviceName, which are strings, port, which
+// is an int, and metadata, which maps keys to their values, i.e.
+// metadata["traffic-profile"]. The namespace is not named namespace, as
+// that is a reserved word in CEL. In addition to the operators and functions
+// of CEL, inCIDR(ip, cidr) returns true if ip is an address in the cidr
+// network. Macros, such as all and exists, are not available, so that
+// expressions cannot loop.
+//
+// Regular expressions and networks that are provided as strings are
+// checked here as well.
+func Compile(src string) (*Expression, error) {
+	if len(strings.TrimSpace(src)) == 0 {
+		return nil, nil
+	}
+	if len(src) > maxExpressionLength {
+		return nil, fmt.Errorf("expression is longer than %d characters", maxExpressionLength)
+	}
+
+	env, err := cel.NewEnv(
+		cel.ClearMacros(),
+		cel.HomogeneousAggregateLiterals(),
+		cel.Declarations(
+			decls.NewVar("source", decls.String),
+			decls.NewVar("name", decls.String),
+			decls.NewVar("address", decls.String),
+			decls.NewVar("port", decls.Int),
+			decls.NewVar("serviceNamespace", decls.String),
+			decls.NewVar("serviceName", decls.String),
+			decls.NewVar("metadata", decls.NewMapType(decls.String, decls.String)),
+			decls.NewFunction(inCIDRFunction,
+				decls.NewOverload("inCIDR_string_string", []*exprpb.Type{decls.String, decls.String}, decls.Bool)),
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ast, iss := env.Compile(src)
+	if iss.Err() != nil {
+		return nil, issuesError(iss)
+	}
+	if ast.ResultType().GetPrimitive() != exprpb.Type_BOOL {
+		return nil, fmt.Errorf("expression returns %s instead of bool", cel.FormatType(ast.ResultType()))
+	}
+	if err := checkLiterals(ast.Expr()); err != nil {
+		return nil, err
+	}
+
+	program, err := env.Program(ast, cel.Functions(&functions.Overload{
+		Operator: inCIDRFunction,
+		Binary:   inCIDR,
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Expression{src: src, program: program}, nil
+}
+
+// Match returns true if the service of the provided event satisfies the
+// expression. Services for which the expression cannot be evaluated, i.e.
+// because they don't have a metadata key it reads, don't match it.
+func (e *Expression) Match(ev *openapi.Event) bool {
+	if e == nil {
+		return true
+	}
+
+	out, _, err := e.program.Eval(map[string]interface{}{
+		"source":           ev.Source,
+		"name":             ev.Service.Name,
+		"address":          ev.Service.Address,
+		"port":             int64(ev.Service.Port),
+		"serviceNamespace": ev.Service.Namespace,
+		"serviceName":      ev.Service.ServiceName,
+		"metadata":         metadataMap(ev.Service.Metadata),
+	})
+	if err != nil {
+		return false
+	}
+
+	res, ok := out.Value().(bool)
+	return ok && res
+}
+
+// String returns the source of the expression.
+func (e *Expression) String() string {
+	if e == nil {
+		return ""
+	}
+
+	return e.src
+}
+
+// issuesError returns the problems found by CEL on a single line, i.e.
+// to be reported by config validate.
+func issuesError(iss *cel.Issues) error {
+	msgs := make([]string, len(iss.Errors()))
+	for i, e := range iss.Errors() {
+		msgs[i] = fmt.Sprintf("%s at line %d, column %d", e.Message, e.Location.Line(), e.Location.Column()+1)
+	}
+
+	return fmt.Errorf("%s", strings.Join(msgs, "; "))
+}
+
+// checkLiterals returns an error if a regular expression or a network
+// provided as a string to matches or inCIDR is not valid, so that it is
+// reported when the expression is compiled rather than ignored when it is
+// evaluated.
+func checkLiterals(expr *exprpb.Expr) error {
+	switch kind := expr.ExprKind.(type) {
+	case *exprpb.Expr_SelectExpr:
+		return checkLiterals(kind.SelectExpr.Operand)
+	case *exprpb.Expr_ListExpr:
+		return checkAllLiterals(kind.ListExpr.Elements)
+	case *exprpb.Expr_StructExpr:
+		for _, entry := range kind.StructExpr.Entries {
+			if err := checkAllLiterals([]*exprpb.Expr{entry.GetMapKey(), entry.Value}); err != nil {
+				return err
+			}
+		}
+	case *exprpb.Expr_CallExpr:
+		call := kind.CallExpr
+		if err := checkAllLiterals(append([]*exprpb.Expr{call.Target}, call.Args...)); err != nil {
+			return err
+		}
+		if len(call.Args) == 0 {
+			return nil
+		}
+
+		// The last argument is the regular expression of
+		// s.matches(regex) and the network of inCIDR(ip, cidr).
+		lit, isString := call.Args[len(call.Args)-1].GetConstExpr().GetConstantKind().(*exprpb.Constant_StringValue)
+		if !isString {
+			return nil
+		}
+
+		switch call.Function {
+		case "matches":
+			if _, err := regexp.Compile(lit.StringValue); err != nil {
+				return fmt.Errorf("invalid regular expression: %w", err)
+			}
+		case inCIDRFunction:
+			if _, _, err := net.ParseCIDR(lit.StringValue); err != nil {
+				return fmt.Errorf("invalid CIDR: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func checkAllLiterals(exprs []*exprpb.Expr) error {
+	for _, expr := range exprs {
+		if expr == nil {
+			continue
+		}
+
+		if err := checkLiterals(expr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// inCIDR returns true if ip is an address in the cidr network.
+func inCIDR(ip, cidr ref.Val) ref.Val {
+	ipStr, ok := ip.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(ip)
+	}
+	cidrStr, ok := cidr.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(cidr)
+	}
+
+	_, network, err := net.ParseCIDR(string(cidrStr))
+	if err != nil {
+		return types.NewErr("invalid CIDR: %s", err)
+	}
+
+	addr := net.ParseIP(string(ipStr))
+	return types.Bool(addr != nil && network.Contains(addr))
+}
+
+func metadataMap(metadata []openapi.Metadata) map[string]string {
+	m := make(map[string]string, len(metadata))
+	for _, md := range metadata {
+		m[md.Key] = md.Value
+	}
+
+	return m
+}
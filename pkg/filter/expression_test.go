@@ -0,0 +1,166 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompile(t *testing.T) {
+	a := assert.New(t)
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+
+	cases := []struct {
+		src    string
+		expNil bool
+		expErr string
+	}{
+		{src: " ", expNil: true},
+		{src: `inCIDR(address, "10.0.0.0/8") && port == 443 && metadata["profile"] != 'best-effort'`},
+		{src: `source in ["etcd", "cloudmap"] || !("profile" in metadata)`},
+		{src: `name.matches("^api-\\d+$") && (port >= 8000 || serviceName.startsWith("web"))`},
+		{src: `inCIDR(address, metadata["site"])`},
+		{src: `port`, expErr: "expression returns int instead of bool"},
+		{src: `metadata`, expErr: "expression returns map(string, string) instead of bool"},
+		{src: `port == "443"`, expErr: "found no matching overload for '_==_' applied to '(int, string)' at line 1, column 6"},
+		{src: `port == 443.0`, expErr: "found no matching overload for '_==_' applied to '(int, double)'"},
+		{src: `port in ["a", "b"]`, expErr: "found no matching overload for '@in' applied to '(int, list(string))'"},
+		{src: `port in ["a", 1]`, expErr: "expected type 'string' but found 'int'"},
+		{src: `true || 1`, expErr: "found no matching overload for '_||_' applied to '(bool, int)'"},
+		{src: `1 < 2 < 3`, expErr: "found no matching overload for '_<_' applied to '(bool, int)'"},
+		{src: `zone == "a"`, expErr: "undeclared reference to 'zone'"},
+		{src: `port.startsWith("4")`, expErr: "found no matching overload for 'startsWith' applied to 'int.(string)'"},
+		{src: `["a"].exists(x, x == name)`, expErr: "undeclared reference to 'exists'"},
+		{src: `name.matches("(")`, expErr: "invalid regular expression: error parsing regexp: missing closing ): `(`"},
+		{src: `[name.matches("(")] == [true]`, expErr: "invalid regular expression"},
+		{src: `inCIDR(address, "10.0.0.0")`, expErr: "invalid CIDR: invalid CIDR address: 10.0.0.0"},
+		{src: `inCIDR(address)`, expErr: "found no matching overload for 'inCIDR' applied to '(string)'"},
+		{src: "port == 443 &&\n  port < \"80\"", expErr: "found no matching overload for '_<_' applied to '(int, string)' at line 2, column 8"},
+		{src: `port == 443 &&`, expErr: "Syntax error: mismatched input '<EOF>'"},
+		{src: `port == 443 443`, expErr: "Syntax error: extraneous input '443' expecting <EOF>"},
+		{src: `name == "api`, expErr: "Syntax error: token recognition error at: '\"api' at line 1, column 9; "},
+		{src: strings.Repeat(" ", maxExpressionLength) + "true", expErr: "expression is longer than 4096 characters"},
+	}
+
+	for i, currCase := range cases {
+		res, err := Compile(currCase.src)
+		if len(currCase.expErr) > 0 {
+			if !a.Error(err) || !a.Contains(err.Error(), currCase.expErr) {
+				failed(i)
+			}
+			continue
+		}
+		if !a.NoError(err) || !a.Equal(currCase.expNil, res == nil) {
+			failed(i)
+		}
+	}
+}
+
+func TestExpressionMatch(t *testing.T) {
+	a := assert.New(t)
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+	ev := &openapi.Event{
+		Source: "etcd",
+		Service: openapi.Service{
+			Name:        "api-1",
+			Address:     "10.1.2.3",
+			Port:        443,
+			Namespace:   "prod",
+			ServiceName: "api",
+			Metadata:    []openapi.Metadata{{Key: "profile", Value: "video"}, {Key: "site", Value: "eu-west"}},
+		},
+	}
+
+	cases := []struct {
+		src       string
+		expResult bool
+	}{
+		{src: "", expResult: true},
+		{src: `inCIDR(address, "10.0.0.0/8") && port == 443 && metadata["profile"] != "best-effort"`, expResult: true},
+		{src: `inCIDR(address, "192.168.0.0/16")`},
+		{src: `inCIDR(name, "10.0.0.0/8")`},
+		{src: `inCIDR(address, metadata["site"])`},
+		{src: `source == "etcd" && serviceNamespace == 'prod' && serviceName == "api"`, expResult: true},
+		{src: `port > 80 && port <= 443 && !(port < 443)`, expResult: true},
+		{src: `name >= "api" && name < "b"`, expResult: true},
+		{src: `metadata["profile"] in ["video", "voip"]`, expResult: true},
+		{src: `port in [80, 8080]`},
+		{src: `"site" in metadata && !("env" in metadata)`, expResult: true},
+		{src: `name.startsWith("api") && name.endsWith("-1") && address.contains(".2.")`, expResult: true},
+		{src: `name.matches("^api-\\d+$")`, expResult: true},
+		{src: `name.matches(r"^api-\d+$")`, expResult: true},
+		{src: `name.matches(metadata["site"])`},
+		{src: `metadata["env"] == "prod"`},
+		{src: `metadata["env"] != "prod"`},
+		{src: `metadata["env"] == "prod" || port == 443`, expResult: true},
+		{src: `port == 443 || metadata["env"] == "prod"`, expResult: true},
+		{src: `metadata["env"] == "prod" && port == 80`},
+		{src: `true && false || true`, expResult: true},
+		{src: `false && true || !true`},
+		{src: `inCIDR(address, "10.1.0.0/16") && !inCIDR(address, "10.1.3.0/24")`, expResult: true},
+		{src: `inCIDR(address, metadata["site"]) || true`, expResult: true},
+		{src: `metadata["profile"] + "-" + metadata["site"] == "video-eu-west"`, expResult: true},
+	}
+
+	for i, currCase := range cases {
+		expr, err := Compile(currCase.src)
+		if !a.NoError(err) || !a.Equal(currCase.expResult, expr.Match(ev)) {
+			failed(i)
+		}
+	}
+}
+
+func TestMatchEvent(t *testing.T) {
+	a := assert.New(t)
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+	ev := &openapi.Event{
+		Source:  "cloudmap",
+		Service: openapi.Service{Address: "10.1.2.3", Port: 443, Metadata: []openapi.Metadata{{Key: "profile", Value: "video"}}},
+	}
+
+	cases := []struct {
+		exprs      []string
+		expression string
+		expResult  bool
+	}{
+		{expResult: true},
+		{exprs: []string{"profile = video"}, expression: "port == 443", expResult: true},
+		{exprs: []string{"profile = voip"}, expression: "port == 443"},
+		{exprs: []string{"profile = video"}, expression: "port == 80"},
+		{expression: `source == "cloudmap"`, expResult: true},
+	}
+
+	for i, currCase := range cases {
+		f, err := New(currCase.exprs, currCase.expression)
+		if !a.NoError(err) || !a.Equal(currCase.expResult, f.MatchEvent(ev)) {
+			failed(i)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
+)
+
+const (
+	opExists    string = "exists"
+	opNotExists string = "!"
+	opEquals    string = "="
+	opNotEquals string = "!="
+	opIn        string = "in"
+	opNotIn     string = "notin"
+	opMatches   string = "=~"
+	opNotMatch  string = "!~"
+)
+
+var (
+	keyPattern   = `[a-zA-Z0-9._/-]+`
+	existsRegex  = regexp.MustCompile(`^(!?)\s*(` + keyPattern + `)$`)
+	setRegex     = regexp.MustCompile(`^(` + keyPattern + `)\s+(in|notin)\s*\((.*)\)$`)
+	compareRegex = regexp.MustCompile(`^(` + keyPattern + `)\s*(==|=~|!=|!~|=)\s*(.*)$`)
+)
+
+// Filter selects services by the values of their metadata and by an
+// optional expression on the whole service. A nil Filter matches
+// everything.
+type Filter struct {
+	exprs        []string
+	requirements []*requirement
+	expression   *Expression
+}
+
+// requirement is a single expression of a filter.
+type requirement struct {
+	key    string
+	op     string
+	values map[string]bool
+	regex  *regexp.Regexp
+}
+
+// New returns a filter that matches services whose metadata satisfy all
+// the provided expressions and that satisfy expression, which is compiled
+// with Compile, or nil if no expression is provided.
+//
+// Each expression is one of:
+//
+//	key                   the key exists
+//	!key                  the key doesn't exist
+//	key = value           the value of the key is value (also ==)
+//	key != value          the key doesn't exist or its value is not value
+//	key in (a, b)         the value of the key is one of a and b
+//	key notin (a, b)      the key doesn't exist or its value is none of them
+//	key =~ regex          the value of the key matches the regular expression
+//	key !~ regex          the key doesn't exist or its value doesn't match it
+//
+// Values can be enclosed in single or double quotes, i.e. to include
+// commas or parentheses. Regular expressions must match the whole value.
+func New(exprs []string, expression string) (*Filter, error) {
+	compiled, err := Compile(expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter expression: %w", err)
+	}
+
+	if len(exprs) == 0 && compiled == nil {
+		return nil, nil
+	}
+
+	f := &Filter{exprs: exprs, expression: compiled}
+	for _, expr := range exprs {
+		req, err := parse(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter %q: %w", expr, err)
+		}
+		f.requirements = append(f.requirements, req)
+	}
+
+	return f, nil
+}
+
+func parse(expr string) (*requirement, error) {
+	expr = strings.TrimSpace(expr)
+
+	if m := existsRegex.FindStringSubmatch(expr); m != nil {
+		op := opExists
+		if len(m[1]) > 0 {
+			op = opNotExists
+		}
+		return &requirement{key: m[2], op: op}, nil
+	}
+
+	if m := setRegex.FindStringSubmatch(expr); m != nil {
+		values := map[string]bool{}
+		for _, val := range splitValues(m[3]) {
+			values[unquote(val)] = true
+		}
+		if len(values) == 0 {
+			return nil, fmt.Errorf("no values provided")
+		}
+		return &requirement{key: m[1], op: m[2], values: values}, nil
+	}
+
+	if m := compareRegex.FindStringSubmatch(expr); m != nil {
+		req := &requirement{key: m[1], op: m[2]}
+		val := unquote(strings.TrimSpace(m[3]))
+
+		switch req.op {
+		case "==", opEquals:
+			req.op, req.values = opEquals, map[string]bool{val: true}
+		case opNotEquals:
+			req.values = map[string]bool{val: true}
+		default:
+			regex, err := regexp.Compile("^(?:" + val + ")$")
+			if err != nil {
+				return nil, err
+			}
+			req.regex = regex
+		}
+		return req, nil
+	}
+
+	return nil, fmt.Errorf("unrecognized expression")
+}
+
+// splitValues splits the values of a set on the commas that are not
+// enclosed in quotes.
+func splitValues(values string) []string {
+	split := []string{}
+	quote, start := rune(0), 0
+
+	for i, r := range values {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == ',':
+			split = append(split, values[start:i])
+			start = i + 1
+		}
+	}
+	split = append(split, values[start:])
+
+	trimmed := []string{}
+	for _, val := range split {
+		if val = strings.TrimSpace(val); len(val) > 0 {
+			trimmed = append(trimmed, val)
+		}
+	}
+
+	return trimmed
+}
+
+func unquote(val string) string {
+	if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') && val[len(val)-1] == val[0] {
+		return val[1 : len(val)-1]
+	}
+
+	return val
+}
+
+// Match returns true if the provided metadata satisfy all the metadata
+// expressions of the filter.
+func (f *Filter) Match(metadata map[string]string) bool {
+	if f == nil {
+		return true
+	}
+
+	for _, req := range f.requirements {
+		if !req.match(metadata) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// MatchEvent returns true if the service of the provided event satisfies
+// both the metadata expressions and the expression of the filter.
+func (f *Filter) MatchEvent(ev *openapi.Event) bool {
+	if f == nil {
+		return true
+	}
+
+	return f.Match(metadataMap(ev.Service.Metadata)) && f.expression.Match(ev)
+}
+
+func (r *requirement) match(metadata map[string]string) bool {
+	val, exists := metadata[r.key]
+
+	switch r.op {
+	case opExists:
+		return exists
+	case opNotExists:
+		return !exists
+	case opEquals, opIn:
+		return exists && r.values[val]
+	case opNotEquals, opNotIn:
+		return !exists || !r.values[val]
+	case opMatches:
+		return exists && r.regex.MatchString(val)
+	case opNotMatch:
+		return !exists || !r.regex.MatchString(val)
+	}
+
+	return false
+}
+
+// String returns the expressions of the filter.
+func (f *Filter) String() string {
+	if f == nil {
+		return ""
+	}
+
+	exprs := f.exprs
+	if f.expression != nil {
+		exprs = append(append([]string{}, exprs...), f.expression.String())
+	}
+
+	return strings.Join(exprs, "; ")
+}
@@ -0,0 +1,133 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package filter
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	a := assert.New(t)
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+
+	cases := []struct {
+		exprs  []string
+		expRes []*requirement
+		expErr bool
+	}{
+		{},
+		{
+			exprs: []string{"traffic-profile", " ! cnwan.io/skip", "profile in (video, 'voip, hd' ,)", "profile notin (test)"},
+			expRes: []*requirement{
+				{key: "traffic-profile", op: opExists},
+				{key: "cnwan.io/skip", op: opNotExists},
+				{key: "profile", op: opIn, values: map[string]bool{"video": true, "voip, hd": true}},
+				{key: "profile", op: opNotIn, values: map[string]bool{"test": true}},
+			},
+		},
+		{
+			exprs: []string{"profile=video", "profile == \"hd video\"", "profile != test", "profile="},
+			expRes: []*requirement{
+				{key: "profile", op: opEquals, values: map[string]bool{"video": true}},
+				{key: "profile", op: opEquals, values: map[string]bool{"hd video": true}},
+				{key: "profile", op: opNotEquals, values: map[string]bool{"test": true}},
+				{key: "profile", op: opEquals, values: map[string]bool{"": true}},
+			},
+		},
+		{
+			exprs:  []string{"profile in ()"},
+			expErr: true,
+		},
+		{
+			exprs:  []string{"profile =~ ("},
+			expErr: true,
+		},
+		{
+			exprs:  []string{"profile is video"},
+			expErr: true,
+		},
+	}
+
+	for i, currCase := range cases {
+		res, err := New(currCase.exprs, "")
+		if currCase.expErr {
+			if !a.Error(err) {
+				failed(i)
+			}
+			continue
+		}
+
+		if !a.NoError(err) {
+			failed(i)
+		}
+		if len(currCase.exprs) == 0 {
+			if !a.Nil(res) {
+				failed(i)
+			}
+			continue
+		}
+		if !a.Equal(currCase.expRes, res.requirements) {
+			failed(i)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	a := assert.New(t)
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+	video := map[string]string{"profile": "video", "env": "prod"}
+	noProfile := map[string]string{"env": "prod"}
+
+	cases := []struct {
+		exprs     []string
+		metadata  map[string]string
+		expResult bool
+	}{
+		{metadata: noProfile, expResult: true},
+		{exprs: []string{"profile"}, metadata: video, expResult: true},
+		{exprs: []string{"profile"}, metadata: noProfile},
+		{exprs: []string{"!profile"}, metadata: noProfile, expResult: true},
+		{exprs: []string{"profile = video"}, metadata: video, expResult: true},
+		{exprs: []string{"profile = voip"}, metadata: video},
+		{exprs: []string{"profile != voip"}, metadata: video, expResult: true},
+		{exprs: []string{"profile != voip"}, metadata: noProfile, expResult: true},
+		{exprs: []string{"profile in (video, voip)"}, metadata: video, expResult: true},
+		{exprs: []string{"profile in (video, voip)"}, metadata: noProfile},
+		{exprs: []string{"profile notin (video, voip)"}, metadata: video},
+		{exprs: []string{"profile =~ vid.*"}, metadata: video, expResult: true},
+		{exprs: []string{"profile =~ vid"}, metadata: video},
+		{exprs: []string{"profile !~ voip|hd"}, metadata: video, expResult: true},
+		{exprs: []string{"profile in (video)", "env = test"}, metadata: video},
+		{exprs: []string{"profile in (video)", "env = prod"}, metadata: video, expResult: true},
+	}
+
+	for i, currCase := range cases {
+		f, err := New(currCase.exprs, "")
+		if !a.NoError(err) || !a.Equal(currCase.expResult, f.Match(currCase.metadata)) {
+			failed(i)
+		}
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"strings"
 
 	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/configuration"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/services"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 )
@@ -52,6 +53,36 @@ func GetMetadataKeysFromCmdFlags(cmd *cobra.Command) ([]string, error) {
 	}
 }
 
+// GetExtraMetadataFromFlags returns which metadata to include in events in
+// addition to the metadata keys, from --all-metadata, --include-metadata
+// and --exclude-metadata flags, or from the configuration file for the ones
+// that are not provided. It returns nil if only the metadata keys must be
+// included.
+func GetExtraMetadataFromFlags(cmd *cobra.Command) *services.ExtraMetadata {
+	extra := &services.ExtraMetadata{}
+	if conf := configuration.GetConfigFile(); conf != nil && conf.ExtraMetadata != nil {
+		extra.All = conf.ExtraMetadata.All
+		extra.Include = conf.ExtraMetadata.Include
+		extra.Exclude = conf.ExtraMetadata.Exclude
+	}
+
+	if cmd.Flags().Changed("all-metadata") {
+		extra.All, _ = cmd.Flags().GetBool("all-metadata")
+	}
+	if cmd.Flags().Changed("include-metadata") {
+		extra.Include, _ = cmd.Flags().GetStringSlice("include-metadata")
+	}
+	if cmd.Flags().Changed("exclude-metadata") {
+		extra.Exclude, _ = cmd.Flags().GetStringSlice("exclude-metadata")
+	}
+
+	if !extra.All && len(extra.Include) == 0 {
+		return nil
+	}
+
+	return extra
+}
+
 // MapContainsKeys returns true if the subject map contains target keys
 func MapContainsKeys(subject map[string]string, targets []string) bool {
 	foundKeys := 0
@@ -89,6 +120,26 @@ func GetAdaptorEndpointFromFlags(cmd *cobra.Command) (string, error) {
 	return endp, nil
 }
 
+// GetEventsVersionFromFlags gets the value of --events-version or returns
+// an error in case it is not a supported version.
+func GetEventsVersionFromFlags(cmd *cobra.Command) (string, error) {
+	version := services.DefaultEventsVersion
+
+	if cmd.Flags().Changed("events-version") {
+		version, _ = cmd.Flags().GetString("events-version")
+	} else {
+		if conf := configuration.GetConfigFile(); conf != nil && len(conf.EventsVersion) > 0 {
+			version = conf.EventsVersion
+		}
+	}
+
+	if !services.IsValidEventsVersion(version) {
+		return "", fmt.Errorf("unsupported events version: %s", version)
+	}
+
+	return version, nil
+}
+
 // GetDebugModeFromFlags gets the value of --debug flag
 func GetDebugModeFromFlags(cmd *cobra.Command) bool {
 	if cmd.Flags().Changed("debug") {
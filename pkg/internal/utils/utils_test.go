@@ -23,6 +23,8 @@ import (
 	"os"
 	"testing"
 
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/services"
+	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -62,6 +64,77 @@ func TestMapContainsKeys(t *testing.T) {
 	}
 }
 
+func TestGetExtraMetadataFromFlags(t *testing.T) {
+	a := assert.New(t)
+	cases := []struct {
+		args []string
+		exp  *services.ExtraMetadata
+	}{
+		{},
+		{
+			args: []string{"--exclude-metadata", "owner"},
+		},
+		{
+			args: []string{"--all-metadata", "--exclude-metadata", "owner"},
+			exp:  &services.ExtraMetadata{All: true, Exclude: []string{"owner"}},
+		},
+		{
+			args: []string{"--include-metadata", "site,env"},
+			exp:  &services.ExtraMetadata{Include: []string{"site", "env"}},
+		},
+	}
+
+	fail := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+	for i, currCase := range cases {
+		cmd := &cobra.Command{}
+		cmd.Flags().Bool("all-metadata", false, "")
+		cmd.Flags().StringSlice("include-metadata", []string{}, "")
+		cmd.Flags().StringSlice("exclude-metadata", []string{}, "")
+		cmd.ParseFlags(currCase.args)
+
+		if !a.Equal(currCase.exp, GetExtraMetadataFromFlags(cmd)) {
+			fail(i)
+		}
+	}
+}
+
+func TestGetEventsVersionFromFlags(t *testing.T) {
+	a := assert.New(t)
+	cases := []struct {
+		args   []string
+		expRes string
+		expErr bool
+	}{
+		{
+			expRes: services.DefaultEventsVersion,
+		},
+		{
+			args:   []string{"--events-version", "v1"},
+			expRes: "v1",
+		},
+		{
+			args:   []string{"--events-version", "v9"},
+			expErr: true,
+		},
+	}
+
+	fail := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+	for i, currCase := range cases {
+		cmd := &cobra.Command{}
+		cmd.Flags().String("events-version", services.DefaultEventsVersion, "")
+		cmd.ParseFlags(currCase.args)
+
+		res, err := GetEventsVersionFromFlags(cmd)
+		if !a.Equal(currCase.expErr, err != nil) || !a.Equal(currCase.expRes, res) {
+			fail(i)
+		}
+	}
+}
+
 func TestSanitizeLocalhost(t *testing.T) {
 	a := assert.New(t)
 
@@ -21,15 +21,29 @@
  *
  * The CN-WAN Reader implements the [service discovery](https://en.wikipedia.org/wiki/Service_discovery) pattern by connecting to a service registry and observing changes in registered services/endpoints. Detected changes are then processed and sent as events to the API endpoints defined below.  Events are **sent** to the following endpoints, thus any program interested in receiving them must generate the *server* code from this OpenAPI specification and define their own logic in the generated code.  By default, the CN-WAN Reader expects the server that will receive events to operate on port `80` and receive events on `/cnwan/events`, but if your server uses a different port/endpoint you can override this value on the generated server code with the one your server is using. Once done, when launching the CN-WAN Reader specify the correct endpoint by providing it as a command line argument, e.g. with `--adaptor-api localhost:9909` events will be sent on `localhost:9909/events`, and with `--adaptor-api example.com/another/path` events will be sent to `example.com/another/path/events`.  As a final note, please take in mind that this specification can also serve as a reference/guide for the creation of an adaptor.   As a matter of fact, your adaptor can even provided its own OpenAPI which includes the endpoints described here with different descriptions and different meanings for the response codes, or it can even include other endpoints as well. But as long as formats, returned response code and the endpoints of this specification match the ones on your adaptor's specification, compatibility with CN-WAN Reader is guaranteed.
  *
- * API version: 1.0.0 beta
+ * API version: 2.0.0
  * Contact: cnwan@cisco.com
  * Generated by: OpenAPI Generator (https://openapi-generator.tech)
  */
 
 package openapi
 
+import (
+	"time"
+)
+
 // Event struct for Event
 type Event struct {
+	// Unique identifier of this event. Only included in API v2.
+	Id string `json:"id,omitempty"`
+	// Monotonically increasing number assigned by the CN-WAN Reader instance that detected this event. Only included in API v2.
+	Sequence int64 `json:"sequence,omitempty"`
+	// The time when the event was detected. Only included in API v2.
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	// The name of the service registry where the event was detected. Only included in API v2.
+	Source string `json:"source,omitempty"`
+	// The revision of the object in the service registry, if the service registry supports it (i.e. etcd). Only included in API v2.
+	Revision int64 `json:"revision,omitempty"`
 	// The event that occurred
 	Event   string  `json:"event,omitempty"`
 	Service Service `json:"service"`
@@ -0,0 +1,262 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/configuration"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/election"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/filter"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/internal/utils"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/queue"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/shutdown"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/transform"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// GetFilterFromFlags returns the filter from --filter and
+// --filter-expression flags, or from the configuration file if not
+// provided. It returns nil if no filter is provided.
+func GetFilterFromFlags(cmd *cobra.Command) (*filter.Filter, error) {
+	exprs, expression := []string{}, ""
+	conf := configuration.GetConfigFile()
+
+	if cmd.Flags().Changed("filter") {
+		exprs, _ = cmd.Flags().GetStringArray("filter")
+	} else if conf != nil {
+		exprs = conf.Filters
+	}
+
+	if cmd.Flags().Changed("filter-expression") {
+		expression, _ = cmd.Flags().GetString("filter-expression")
+	} else if conf != nil {
+		expression = conf.FilterExpression
+	}
+
+	return filter.New(exprs, expression)
+}
+
+// GetTransformerFromConfig returns the transformer of the metadata of
+// services from the configuration file. It returns nil if no transform is
+// provided.
+func GetTransformerFromConfig() (*transform.Transformer, error) {
+	conf := configuration.GetConfigFile()
+	if conf == nil {
+		return nil, nil
+	}
+
+	rules := make([]transform.Rule, len(conf.Transforms))
+	for i, t := range conf.Transforms {
+		rules[i] = transform.Rule{
+			Source:      t.Source,
+			RenameKeys:  t.RenameKeys,
+			MapValues:   t.MapValues,
+			AddMetadata: t.AddMetadata,
+		}
+	}
+
+	return transform.New(rules)
+}
+
+// GetQueueOptionsFromFlags gets the values of the flags that define how
+// events are sent to the adaptor, i.e. --max-events-per-request,
+// --max-bytes-per-request, --rate-limit and --rate-limit-burst, or returns
+// an error in case they are not valid.
+func GetQueueOptionsFromFlags(cmd *cobra.Command) (*queue.Options, error) {
+	opts := &queue.Options{}
+	delivery := &configuration.DeliverySettings{}
+	if conf := configuration.GetConfigFile(); conf != nil && conf.Delivery != nil {
+		delivery = conf.Delivery
+	}
+
+	opts.MaxEventsPerRequest = delivery.MaxEventsPerRequest
+	if cmd.Flags().Changed("max-events-per-request") {
+		opts.MaxEventsPerRequest, _ = cmd.Flags().GetInt("max-events-per-request")
+	}
+
+	opts.MaxBytesPerRequest = delivery.MaxBytesPerRequest
+	if cmd.Flags().Changed("max-bytes-per-request") {
+		opts.MaxBytesPerRequest, _ = cmd.Flags().GetInt("max-bytes-per-request")
+	}
+
+	opts.RateLimit = delivery.RateLimit
+	if cmd.Flags().Changed("rate-limit") {
+		opts.RateLimit, _ = cmd.Flags().GetFloat64("rate-limit")
+	}
+
+	opts.RateLimitBurst = delivery.RateLimitBurst
+	if cmd.Flags().Changed("rate-limit-burst") {
+		opts.RateLimitBurst, _ = cmd.Flags().GetInt("rate-limit-burst")
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	return opts, nil
+}
+
+// GetShutdownOptionsFromFlags gets the values of --drain-timeout and
+// --pending-events-file or returns an error in case they are not valid.
+func GetShutdownOptionsFromFlags(cmd *cobra.Command) (*shutdown.Options, error) {
+	drainTimeout := shutdown.DefaultDrainTimeout
+	pendingFile := ""
+	if conf := configuration.GetConfigFile(); conf != nil && conf.Delivery != nil {
+		if conf.Delivery.DrainTimeout > 0 {
+			drainTimeout = conf.Delivery.DrainTimeout
+		}
+		pendingFile = conf.Delivery.PendingEventsFile
+	}
+
+	if cmd.Flags().Changed("drain-timeout") {
+		drainTimeout, _ = cmd.Flags().GetInt("drain-timeout")
+	}
+	if cmd.Flags().Changed("pending-events-file") {
+		pendingFile, _ = cmd.Flags().GetString("pending-events-file")
+	}
+
+	if drainTimeout < 0 {
+		return nil, fmt.Errorf("invalid drain timeout: %d", drainTimeout)
+	}
+
+	return &shutdown.Options{
+		DrainTimeout:      time.Duration(drainTimeout) * time.Second,
+		PendingEventsFile: pendingFile,
+	}, nil
+}
+
+// GetElectionOptionsFromFlags gets the values of the flags about the leader
+// election, i.e. --leader-election, --leader-election-id,
+// --leader-election-key, --leader-election-ttl and
+// --leader-election-endpoints, or returns an error in case they are not
+// valid. If the leader election is not enabled, nil is returned.
+//
+// Endpoints are not validated here, as some commands can use their own
+// etcd client for the election.
+func GetElectionOptionsFromFlags(cmd *cobra.Command) (*election.Options, error) {
+	settings := &configuration.LeaderElectionSettings{}
+	if conf := configuration.GetConfigFile(); conf != nil && conf.LeaderElection != nil {
+		settings = conf.LeaderElection
+	}
+
+	enabled := settings.Enabled
+	if cmd.Flags().Changed("leader-election") {
+		enabled, _ = cmd.Flags().GetBool("leader-election")
+	}
+	if !enabled {
+		return nil, nil
+	}
+
+	opts := &election.Options{
+		ID:        settings.ID,
+		Key:       settings.Key,
+		TTL:       settings.TTL,
+		Endpoints: settings.Endpoints,
+		Username:  settings.Username,
+		Password:  settings.Password,
+	}
+
+	if cmd.Flags().Changed("leader-election-id") || len(opts.ID) == 0 {
+		opts.ID, _ = cmd.Flags().GetString("leader-election-id")
+	}
+	if len(opts.ID) == 0 {
+		opts.ID, _ = os.Hostname()
+	}
+
+	if cmd.Flags().Changed("leader-election-key") || len(opts.Key) == 0 {
+		opts.Key, _ = cmd.Flags().GetString("leader-election-key")
+	}
+
+	if cmd.Flags().Changed("leader-election-ttl") || opts.TTL == 0 {
+		opts.TTL, _ = cmd.Flags().GetInt("leader-election-ttl")
+	}
+
+	if cmd.Flags().Changed("leader-election-endpoints") {
+		opts.Endpoints, _ = cmd.Flags().GetStringSlice("leader-election-endpoints")
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	return opts, nil
+}
+
+// WatchConfigFile returns a channel that receives the new configuration
+// every time the configuration file changes or SIGHUP is received, until
+// ctx is done, along with the adaptor, events version, filter and
+// transformer to use. Flags still override the configuration file.
+func WatchConfigFile(ctx context.Context, cmd *cobra.Command) <-chan Reload {
+	return watchConfigFile(ctx, cmd, configuration.Watch(ctx, configuration.DefaultWatchInterval))
+}
+
+// watchConfigFile sends a Reload to the returned channel for every new
+// configuration received from changes, skipping the ones that are not
+// valid, until ctx is done.
+func watchConfigFile(ctx context.Context, cmd *cobra.Command, changes <-chan *configuration.Config) <-chan Reload {
+	reloads := make(chan Reload)
+
+	go func() {
+		for {
+			var conf *configuration.Config
+			select {
+			case <-ctx.Done():
+				return
+			case conf = <-changes:
+			}
+
+			adaptor, err := utils.GetAdaptorEndpointFromFlags(cmd)
+			if err != nil {
+				log.Err(err).Msg("invalid adaptor in new configuration, ignoring it")
+				continue
+			}
+
+			eventsVersion, err := utils.GetEventsVersionFromFlags(cmd)
+			if err != nil {
+				log.Err(err).Msg("invalid events version in new configuration, ignoring it")
+				continue
+			}
+
+			flt, err := GetFilterFromFlags(cmd)
+			if err != nil {
+				log.Err(err).Msg("invalid filters in new configuration, ignoring it")
+				continue
+			}
+
+			transformer, err := GetTransformerFromConfig()
+			if err != nil {
+				log.Err(err).Msg("invalid transforms in new configuration, ignoring it")
+				continue
+			}
+
+			select {
+			case reloads <- Reload{Adaptor: adaptor, EventsVersion: eventsVersion, Filter: flt, Transformer: transformer, Config: conf}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return reloads
+}
@@ -0,0 +1,202 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/configuration"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/election"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/queue"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/shutdown"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+// newFlagsCommand returns a command with the same flags of the root
+// command, parsed from args.
+func newFlagsCommand(args ...string) *cobra.Command {
+	cmd := &cobra.Command{Use: "test"}
+	cmd.Flags().String("conf", "", "")
+	cmd.Flags().String("adaptor-api", "localhost:80/cnwan", "")
+	cmd.Flags().String("events-version", "v2", "")
+	cmd.Flags().StringArray("filter", []string{}, "")
+	cmd.Flags().String("filter-expression", "", "")
+	cmd.Flags().Int("max-events-per-request", 0, "")
+	cmd.Flags().Int("max-bytes-per-request", 0, "")
+	cmd.Flags().Float64("rate-limit", 0, "")
+	cmd.Flags().Int("rate-limit-burst", 1, "")
+	cmd.Flags().Int("drain-timeout", shutdown.DefaultDrainTimeout, "")
+	cmd.Flags().String("pending-events-file", "", "")
+	cmd.Flags().Bool("leader-election", false, "")
+	cmd.Flags().String("leader-election-id", "", "")
+	cmd.Flags().String("leader-election-key", election.DefaultKey, "")
+	cmd.Flags().Int("leader-election-ttl", election.DefaultTTL, "")
+	cmd.Flags().StringSlice("leader-election-endpoints", []string{}, "")
+	cmd.ParseFlags(args)
+	return cmd
+}
+
+// TestOptionsFromFlags tests all the functions that read the configuration
+// file, as it can only be parsed once.
+func TestOptionsFromFlags(t *testing.T) {
+	a := assert.New(t)
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+	dir, err := ioutil.TempDir("", "cnwan-reader-pipeline")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "config.yaml")
+	a.NoError(ioutil.WriteFile(filePath, []byte(`adaptor: example.com/cnwan
+eventsVersion: v1
+filters:
+- profile in (video, voip)
+filterExpression: port == 443
+transforms:
+- addMetadata:
+    site: eu-west
+delivery:
+  maxEventsPerRequest: 10
+  rateLimit: 2
+  rateLimitBurst: 3
+  drainTimeout: 20
+  pendingEventsFile: /pending.json
+leaderElection:
+  enabled: true
+  id: first
+  endpoints:
+  - localhost:2379
+`), 0644))
+	a.NoError(configuration.ParseConfigurationFile(newFlagsCommand("--conf", filePath)))
+
+	// Filters
+	filterCases := []struct {
+		args   []string
+		exp    string
+		expErr bool
+	}{
+		{exp: "profile in (video, voip); port == 443"},
+		{args: []string{"--filter", "env = prod", "--filter", "site"}, exp: "env = prod; site; port == 443"},
+		{args: []string{"--filter-expression", ""}, exp: "profile in (video, voip)"},
+		{args: []string{"--filter-expression", `port == "443"`}, expErr: true},
+	}
+	for i, currCase := range filterCases {
+		flt, err := GetFilterFromFlags(newFlagsCommand(currCase.args...))
+		if !a.Equal(currCase.expErr, err != nil) || (err == nil && !a.Equal(currCase.exp, flt.String())) {
+			failed(i)
+		}
+	}
+
+	// Transforms
+	transformer, err := GetTransformerFromConfig()
+	a.NoError(err)
+	a.Equal([]openapi.Metadata{{Key: "site", Value: "eu-west"}}, transformer.Apply("etcd", []openapi.Metadata{}))
+
+	// Queue
+	queueCases := []struct {
+		args   []string
+		exp    *queue.Options
+		expErr bool
+	}{
+		{exp: &queue.Options{MaxEventsPerRequest: 10, RateLimit: 2, RateLimitBurst: 3}},
+		{
+			args: []string{"--max-events-per-request", "0", "--max-bytes-per-request", "100", "--rate-limit", "5", "--rate-limit-burst", "1"},
+			exp:  &queue.Options{MaxBytesPerRequest: 100, RateLimit: 5, RateLimitBurst: 1},
+		},
+		{args: []string{"--max-events-per-request", "-1"}, expErr: true},
+	}
+	for i, currCase := range queueCases {
+		opts, err := GetQueueOptionsFromFlags(newFlagsCommand(currCase.args...))
+		if !a.Equal(currCase.expErr, err != nil) || !a.Equal(currCase.exp, opts) {
+			failed(i)
+		}
+	}
+
+	// Shutdown
+	shutdownCases := []struct {
+		args   []string
+		exp    *shutdown.Options
+		expErr bool
+	}{
+		{exp: &shutdown.Options{DrainTimeout: 20 * time.Second, PendingEventsFile: "/pending.json"}},
+		{
+			args: []string{"--drain-timeout", "0", "--pending-events-file", ""},
+			exp:  &shutdown.Options{},
+		},
+		{args: []string{"--drain-timeout", "-1"}, expErr: true},
+	}
+	for i, currCase := range shutdownCases {
+		opts, err := GetShutdownOptionsFromFlags(newFlagsCommand(currCase.args...))
+		if !a.Equal(currCase.expErr, err != nil) || !a.Equal(currCase.exp, opts) {
+			failed(i)
+		}
+	}
+
+	// Leader election
+	electionCases := []struct {
+		args   []string
+		exp    *election.Options
+		expErr bool
+	}{
+		{exp: &election.Options{ID: "first", Key: election.DefaultKey, TTL: election.DefaultTTL, Endpoints: []string{"localhost:2379"}}},
+		{
+			args: []string{"--leader-election-id", "second", "--leader-election-key", "/leader", "--leader-election-ttl", "5", "--leader-election-endpoints", "etcd:2379"},
+			exp:  &election.Options{ID: "second", Key: "/leader", TTL: 5, Endpoints: []string{"etcd:2379"}},
+		},
+		{args: []string{"--leader-election=false"}},
+		{args: []string{"--leader-election-ttl", "0"}, expErr: true},
+	}
+	for i, currCase := range electionCases {
+		opts, err := GetElectionOptionsFromFlags(newFlagsCommand(currCase.args...))
+		if !a.Equal(currCase.expErr, err != nil) || !a.Equal(currCase.exp, opts) {
+			failed(i)
+		}
+	}
+
+	// Reload
+	ctx, canc := context.WithCancel(context.Background())
+	defer canc()
+	changes := make(chan *configuration.Config)
+	conf := configuration.GetConfigFile()
+	reloads := watchConfigFile(ctx, newFlagsCommand("--events-version", "v2"), changes)
+	changes <- conf
+	reload := <-reloads
+	a.Equal("example.com/cnwan", reload.Adaptor)
+	a.Equal("v2", reload.EventsVersion)
+	a.Equal("profile in (video, voip); port == 443", reload.Filter.String())
+	a.NotNil(reload.Transformer)
+	a.Same(conf, reload.Config)
+
+	// Invalid configurations are skipped
+	reloads = watchConfigFile(ctx, newFlagsCommand("--events-version", "v9"), changes)
+	changes <- conf
+	select {
+	case <-reloads:
+		a.Fail("invalid configuration was not skipped")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
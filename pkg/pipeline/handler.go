@@ -0,0 +1,67 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package pipeline
+
+import (
+	"context"
+	"sync"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/services"
+	"github.com/rs/zerolog/log"
+)
+
+// switchHandler is a handler that can be replaced with another one while
+// events are being sent, i.e. when the adaptor changes.
+type switchHandler struct {
+	lock          sync.RWMutex
+	handler       services.Handler
+	adaptor       string
+	eventsVersion string
+}
+
+// Send sends the events with the current handler.
+func (s *switchHandler) Send(events []openapi.Event) error {
+	s.lock.RLock()
+	handler := s.handler
+	s.lock.RUnlock()
+
+	return handler.Send(events)
+}
+
+// set replaces the current handler with one for the provided adaptor and
+// events version, if any of them is different. Events that are already
+// being sent are still sent with the previous one.
+func (s *switchHandler) set(ctx context.Context, adaptor, eventsVersion string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if adaptor == s.adaptor && eventsVersion == s.eventsVersion {
+		return nil
+	}
+
+	handler, err := services.NewHandler(ctx, adaptor, eventsVersion)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Str("adaptor", adaptor).Str("events-version", eventsVersion).Msg("switching adaptor...")
+	s.handler, s.adaptor, s.eventsVersion = handler, adaptor, eventsVersion
+	return nil
+}
@@ -0,0 +1,304 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/configuration"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/election"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/filter"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/queue"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/services"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/shutdown"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/transform"
+	"github.com/rs/zerolog/log"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// Source is a source of events, i.e. a service registry.
+type Source interface {
+	// Name returns the name of the source, which is also used to tag the
+	// events that come from it.
+	Name() string
+	// Run enqueues events to q until ctx is done or an error occurs.
+	//
+	// Every time a value is received from resync, events may have been
+	// missed and the whole current state must be enqueued again.
+	//
+	// This function blocks and returns nil if it stopped because ctx is
+	// done.
+	Run(ctx context.Context, q queue.Queue, resync <-chan struct{}) error
+}
+
+// Reloader is implemented by sources that can apply a new configuration
+// without being restarted.
+type Reloader interface {
+	// Reload applies the provided configuration, starting from the next
+	// time the source reads its service registry. Services that enter or
+	// leave the filters because of it are sent as create or delete
+	// events, respectively.
+	//
+	// If an error is returned, the source keeps the current configuration.
+	Reload(conf *configuration.Config) error
+}
+
+// Reload contains a new configuration to apply without restarting.
+type Reload struct {
+	// Adaptor is the new endpoint of the adaptor.
+	Adaptor string
+	// EventsVersion is the new format of the events sent to the adaptor.
+	EventsVersion string
+	// Filter is the new filter of the services to send.
+	Filter *filter.Filter
+	// Transformer is the new transformer of the metadata of services.
+	Transformer *transform.Transformer
+	// Config is the new configuration, which is passed to the sources
+	// that implement Reloader.
+	Config *configuration.Config
+}
+
+// Options contains settings about how events are sent to the adaptor.
+type Options struct {
+	// Adaptor is the endpoint of the adaptor, where events are sent to.
+	Adaptor string
+	// EventsVersion is the format of the events sent to the adaptor.
+	EventsVersion string
+	// Filter selects the services whose events are sent to the adaptor.
+	// If nil, all of them are sent.
+	Filter *filter.Filter
+	// Transformer changes the metadata of services before they are
+	// filtered. If nil, metadata are sent as they are.
+	Transformer *transform.Transformer
+	// Queue contains settings about how events are sent.
+	Queue *queue.Options
+	// Shutdown contains settings about how to shut down. If nil, the
+	// default drain timeout is used and pending events are discarded.
+	Shutdown *shutdown.Options
+	// Election contains settings about the leader election. If nil, the
+	// leader election is disabled.
+	Election *election.Options
+	// ElectionClient is the etcd client to use for the leader election.
+	// If nil, a new one is created from Election and closed when done.
+	ElectionClient *clientv3.Client
+	// Reload receives a value every time the configuration changes. If
+	// nil, the configuration is never reloaded.
+	Reload <-chan Reload
+}
+
+// Run runs all the sources at the same time and sends their events to
+// the adaptor through the same queue, until SIGINT or SIGTERM is received
+// or a source stops because of an error. It then stops all sources and
+// waits for the events still in the queue to be sent.
+//
+// When there is more than one source, the keys of the events are prefixed
+// with the name of their source, so that events for different sources
+// are never coalesced together. The metadata of services are transformed
+// before being filtered. Events of services that don't match the
+// filter are not sent, and services that enter or leave it are sent as
+// create or delete events, respectively.
+//
+// An error is returned if it was not possible to start, otherwise the
+// exit code that the program should use is returned.
+func Run(sources []Source, opts Options) (int, error) {
+	if len(sources) == 0 {
+		return 0, fmt.Errorf("no sources provided")
+	}
+
+	// Sources are stopped as soon as exit is requested, while events still
+	// need to be sent after that.
+	srcCtx, srcCanc := context.WithCancel(context.Background())
+	defer srcCanc()
+	sendCtx, sendCanc := context.WithCancel(context.Background())
+	defer sendCanc()
+
+	servsHandler, err := services.NewHandler(sendCtx, opts.Adaptor, opts.EventsVersion)
+	if err != nil {
+		return 0, fmt.Errorf("error while trying to connect to the adaptor: %w", err)
+	}
+	handler := &switchHandler{
+		handler:       servsHandler,
+		adaptor:       opts.Adaptor,
+		eventsVersion: opts.EventsVersion,
+	}
+	sendQueue := queue.New(sendCtx, handler, opts.Queue)
+
+	if opts.Shutdown == nil {
+		opts.Shutdown = &shutdown.Options{
+			DrainTimeout: time.Duration(shutdown.DefaultDrainTimeout) * time.Second,
+		}
+	}
+	resyncs := make([]chan struct{}, len(sources))
+	for i := range resyncs {
+		resyncs[i] = make(chan struct{}, 1)
+	}
+
+	// The election goes on until all events have been sent, so that no
+	// other replica takes over in the meantime.
+	electionDone := make(chan struct{})
+	if opts.Election != nil {
+		cli := opts.ElectionClient
+		if cli == nil {
+			cli, err = election.NewClient(opts.Election)
+			if err != nil {
+				return 0, fmt.Errorf("error while trying to connect to etcd for the leader election: %w", err)
+			}
+		}
+
+		elector := election.New(cli, *opts.Election)
+		leaderQueue := election.Queue(sendQueue, elector)
+		sendQueue = leaderQueue
+		go fanOut(sendCtx, elector.Elected(), resyncs, leaderQueue.Resync)
+		go func() {
+			elector.Run(sendCtx)
+			if cli != opts.ElectionClient {
+				cli.Close()
+			}
+			close(electionDone)
+		}()
+	} else {
+		close(electionDone)
+	}
+
+	filterQueue := newFilterQueue(sendQueue, opts.Filter)
+	if len(opts.Shutdown.PendingEventsFile) > 0 {
+		restorePending(filterQueue, opts.Shutdown.PendingEventsFile)
+	}
+	transformQueues := make([]*transformQueue, len(sources))
+
+	var wg sync.WaitGroup
+	failed := make(chan struct{}, len(sources))
+	for i, src := range sources {
+		var q queue.Queue = filterQueue
+		if len(sources) > 1 {
+			q = &sourceQueue{Queue: filterQueue, source: src.Name()}
+		}
+		transformQueues[i] = newTransformQueue(q, src.Name(), opts.Transformer)
+		q = transformQueues[i]
+
+		wg.Add(1)
+		go func(src Source, q queue.Queue, resync <-chan struct{}) {
+			defer wg.Done()
+
+			if err := src.Run(srcCtx, q, resync); err != nil && srcCtx.Err() == nil {
+				log.Err(err).Str("source", src.Name()).Msg("source stopped because of an error")
+				failed <- struct{}{}
+			}
+		}(src, q, resyncs[i])
+	}
+
+	// Graceful shutdown
+	sig, stopNotify := shutdown.NotifySignal()
+	defer stopNotify()
+
+	sourceFailed := false
+	for exit := false; !exit; {
+		select {
+		case s := <-sig:
+			fmt.Println()
+			log.Info().Str("signal", s.String()).Msg("exit requested")
+			exit = true
+		case <-failed:
+			sourceFailed = true
+			log.Info().Msg("exiting...")
+			exit = true
+		case r := <-opts.Reload:
+			reload(sendCtx, r, handler, transformQueues, filterQueue, sources)
+		}
+	}
+
+	// Stop getting new data first, then send what's left
+	srcCanc()
+	wg.Wait()
+
+	exitCode := shutdown.Drain(sendQueue, sendCanc, *opts.Shutdown)
+	sendCanc()
+	<-electionDone
+
+	if sourceFailed && exitCode == shutdown.ExitOK {
+		exitCode = shutdown.ExitSourceFailed
+	}
+
+	return exitCode, nil
+}
+
+// restorePending enqueues the events saved on a previous run to q, which
+// is the first queue shared by all sources, so that they are filtered and
+// only sent while this replica is the leader, like any other event. They
+// are not transformed again, as they were already transformed when saved.
+func restorePending(q *filterQueue, path string) {
+	restored, err := queue.Restore(restoredQueue{q}, path)
+	if err != nil {
+		log.Err(err).Str("file", path).Msg("could not restore events from previous run")
+	}
+	if restored > 0 {
+		log.Info().Int("events", restored).Msg("restored events from previous run")
+	}
+}
+
+// fanOut sends a value to all the provided channels every time a value is
+// received from in, until ctx is done, after calling onReceive if not nil.
+// Values are not sent to channels that already have one waiting to be
+// received.
+func fanOut(ctx context.Context, in <-chan struct{}, out []chan struct{}, onReceive func()) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-in:
+			if onReceive != nil {
+				onReceive()
+			}
+			for _, ch := range out {
+				select {
+				case ch <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// reload applies the new configuration to the handler, to the transformers,
+// to the filter and to the sources that implement Reloader.
+func reload(ctx context.Context, r Reload, handler *switchHandler, transformQueues []*transformQueue, filterQueue *filterQueue, sources []Source) {
+	if err := handler.set(ctx, r.Adaptor, r.EventsVersion); err != nil {
+		log.Err(err).Msg("could not apply new adaptor settings, keeping the current ones")
+	}
+	for _, tq := range transformQueues {
+		tq.setTransformer(r.Transformer)
+	}
+	filterQueue.setFilter(r.Filter)
+
+	for _, src := range sources {
+		reloader, ok := src.(Reloader)
+		if !ok {
+			continue
+		}
+
+		if err := reloader.Reload(r.Config); err != nil {
+			log.Err(err).Str("source", src.Name()).Msg("could not apply new configuration, keeping the current one")
+		}
+	}
+
+	log.Info().Msg("configuration reloaded")
+}
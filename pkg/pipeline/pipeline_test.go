@@ -0,0 +1,432 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/configuration"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/election"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/filter"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/queue"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/services"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/shutdown"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/transform"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeQueue struct {
+	enqueued map[string]*openapi.Event
+}
+
+func (f *fakeQueue) Enqueue(events map[string]*openapi.Event) {
+	for key, ev := range events {
+		f.enqueued[key] = ev
+	}
+}
+
+func (f *fakeQueue) Close(_ context.Context) []queue.PendingEvent {
+	return []queue.PendingEvent{}
+}
+
+type fakeSource struct {
+	name   string
+	events map[string]*openapi.Event
+	err    error
+}
+
+func (f *fakeSource) Name() string {
+	return f.name
+}
+
+func (f *fakeSource) Run(ctx context.Context, q queue.Queue, _ <-chan struct{}) error {
+	q.Enqueue(f.events)
+	if f.err != nil {
+		return f.err
+	}
+
+	<-ctx.Done()
+	return nil
+}
+
+type fakeReloader struct {
+	fakeSource
+	reloaded *configuration.Config
+	err      error
+}
+
+func (f *fakeReloader) Reload(conf *configuration.Config) error {
+	if f.err != nil {
+		return f.err
+	}
+
+	f.reloaded = conf
+	return nil
+}
+
+func TestReload(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	h, _ := services.NewHandler(ctx, "localhost/cnwan", "v2")
+	handler := &switchHandler{handler: h, adaptor: "localhost/cnwan", eventsVersion: "v2"}
+	ok := &fakeReloader{fakeSource: fakeSource{name: "ok"}}
+	ko := &fakeReloader{fakeSource: fakeSource{name: "ko"}, err: errors.New("whatever")}
+	conf := &configuration.Config{Adaptor: "example.com/cnwan"}
+	fq := newFilterQueue(&fakeQueue{enqueued: map[string]*openapi.Event{}}, nil)
+	tq := newTransformQueue(fq, "ok", nil)
+	flt, _ := filter.New([]string{"key"}, "")
+	tr, _ := transform.New([]transform.Rule{{AddMetadata: map[string]string{"site": "eu-west"}}})
+
+	// Sources are reloaded even if the adaptor is not valid
+	reload(ctx, Reload{Adaptor: "example.com/cnwan", EventsVersion: "v3", Filter: flt, Transformer: tr, Config: conf}, handler, []*transformQueue{tq}, fq, []Source{&fakeSource{name: "none"}, ok, ko})
+	a.Same(h, handler.handler)
+	a.Equal("localhost/cnwan", handler.adaptor)
+	a.Equal(conf, ok.reloaded)
+	a.Nil(ko.reloaded)
+	a.Same(flt, fq.filter)
+	a.Same(tr, tq.transformer)
+
+	reload(ctx, Reload{Adaptor: "localhost/cnwan", EventsVersion: "v2", Config: conf}, handler, []*transformQueue{tq}, fq, nil)
+	a.Same(h, handler.handler)
+	a.Nil(fq.filter)
+	a.Nil(tq.transformer)
+
+	reload(ctx, Reload{Adaptor: "example.com/cnwan", EventsVersion: "v1", Config: conf}, handler, nil, fq, nil)
+	a.NotSame(h, handler.handler)
+	a.Equal("example.com/cnwan", handler.adaptor)
+	a.Equal("v1", handler.eventsVersion)
+}
+
+func TestSourceQueue(t *testing.T) {
+	a := assert.New(t)
+	fq := &fakeQueue{enqueued: map[string]*openapi.Event{}}
+	q := &sourceQueue{Queue: fq, source: "cloudmap"}
+
+	one, two := &openapi.Event{Event: "create"}, &openapi.Event{Event: "delete"}
+	q.Enqueue(map[string]*openapi.Event{
+		"ns/serv/endp": one,
+		"/key/":        two,
+	})
+	a.Equal(map[string]*openapi.Event{
+		"cloudmap/ns/serv/endp": one,
+		"cloudmap//key/":        two,
+	}, fq.enqueued)
+}
+
+func TestTransformQueue(t *testing.T) {
+	a := assert.New(t)
+	fq := &fakeQueue{enqueued: map[string]*openapi.Event{}}
+	tr, _ := transform.New([]transform.Rule{
+		{Source: "etcd", RenameKeys: map[string]string{"cnwan.io/profile": "profile"}},
+		{Source: "cloudmap", AddMetadata: map[string]string{"site": "us-east"}},
+	})
+	q := newTransformQueue(fq, "etcd", tr)
+	event := func(event string, metadata ...openapi.Metadata) *openapi.Event {
+		return &openapi.Event{
+			Event:   event,
+			Source:  "etcd",
+			Service: openapi.Service{Name: "serv", Metadata: metadata},
+		}
+	}
+	enqueue := func(events map[string]*openapi.Event) map[string]*openapi.Event {
+		fq.enqueued = map[string]*openapi.Event{}
+		q.Enqueue(events)
+		return fq.enqueued
+	}
+
+	// The events of the source are changed, but not the original ones
+	orig := event("create", openapi.Metadata{Key: "cnwan.io/profile", Value: "vid"})
+	a.Equal(map[string]*openapi.Event{
+		"one": event("create", openapi.Metadata{Key: "profile", Value: "vid"}),
+	}, enqueue(map[string]*openapi.Event{"one": orig}))
+	a.Equal("cnwan.io/profile", orig.Service.Metadata[0].Key)
+
+	// Updates that don't change the transformed service are not sent
+	a.Empty(enqueue(map[string]*openapi.Event{
+		"one": event("update", openapi.Metadata{Key: "cnwan.io/profile", Value: "vid"}),
+	}))
+	a.Equal(map[string]*openapi.Event{
+		"one": event("update", openapi.Metadata{Key: "profile", Value: "video"}),
+	}, enqueue(map[string]*openapi.Event{
+		"one": event("update", openapi.Metadata{Key: "cnwan.io/profile", Value: "video"}),
+	}))
+
+	// Changing the transformer sends the services that change as updates
+	enqueue(map[string]*openapi.Event{
+		"two": event("create", openapi.Metadata{Key: "profile", Value: "voip"}),
+	})
+	fq.enqueued = map[string]*openapi.Event{}
+	tr, _ = transform.New([]transform.Rule{{MapValues: map[string]map[string]string{"cnwan.io/profile": {"video": "hd"}}}})
+	q.setTransformer(tr)
+	a.Len(fq.enqueued, 1)
+	a.Equal("update", fq.enqueued["one"].Event)
+	a.Equal("etcd", fq.enqueued["one"].Source)
+	a.NotEmpty(fq.enqueued["one"].Id)
+	a.Equal([]openapi.Metadata{{Key: "cnwan.io/profile", Value: "hd"}}, fq.enqueued["one"].Service.Metadata)
+
+	// Deleted services are sent transformed
+	a.Equal(map[string]*openapi.Event{
+		"one": event("delete", openapi.Metadata{Key: "cnwan.io/profile", Value: "hd"}),
+	}, enqueue(map[string]*openapi.Event{
+		"one": event("delete", openapi.Metadata{Key: "cnwan.io/profile", Value: "video"}),
+	}))
+	fq.enqueued = map[string]*openapi.Event{}
+	q.setTransformer(nil)
+	a.Empty(fq.enqueued)
+}
+
+func TestFilterQueue(t *testing.T) {
+	a := assert.New(t)
+	fq := &fakeQueue{enqueued: map[string]*openapi.Event{}}
+	flt, _ := filter.New([]string{"profile in (video, voip)"}, "")
+	q := newFilterQueue(fq, flt)
+	event := func(event, profile string) *openapi.Event {
+		return &openapi.Event{
+			Event:   event,
+			Source:  "etcd",
+			Service: openapi.Service{Name: "serv", Metadata: []openapi.Metadata{{Key: "profile", Value: profile}}},
+		}
+	}
+	enqueue := func(events map[string]*openapi.Event) map[string]*openapi.Event {
+		fq.enqueued = map[string]*openapi.Event{}
+		q.Enqueue(events)
+		return fq.enqueued
+	}
+
+	// Only services that match are sent
+	a.Equal(map[string]*openapi.Event{"one": event("create", "video")}, enqueue(map[string]*openapi.Event{
+		"one": event("create", "video"),
+		"two": event("create", "test"),
+	}))
+
+	// Services that enter the filter are created, the ones that leave it
+	// are deleted with their previous state
+	a.Equal(map[string]*openapi.Event{
+		"one": event("delete", "video"),
+		"two": event("create", "voip"),
+	}, enqueue(map[string]*openapi.Event{
+		"one": event("update", "test"),
+		"two": event("update", "voip"),
+	}))
+
+	a.Equal(map[string]*openapi.Event{"two": event("update", "video")}, enqueue(map[string]*openapi.Event{
+		"one": event("update", "dev"),
+		"two": event("update", "video"),
+	}))
+
+	// Deleted services are only sent if they matched
+	a.Empty(enqueue(map[string]*openapi.Event{"one": event("delete", "dev")}))
+	a.Equal(map[string]*openapi.Event{"two": event("delete", "video")}, enqueue(map[string]*openapi.Event{
+		"two": event("delete", "video"),
+	}))
+
+	// Changing the filter sends the services that enter or leave it
+	enqueue(map[string]*openapi.Event{
+		"one": event("create", "dev"),
+		"two": event("create", "video"),
+	})
+	fq.enqueued = map[string]*openapi.Event{}
+	q.setFilter(nil)
+	a.Len(fq.enqueued, 1)
+	a.Equal("create", fq.enqueued["one"].Event)
+	a.NotEmpty(fq.enqueued["one"].Id)
+
+	fq.enqueued = map[string]*openapi.Event{}
+	flt, _ = filter.New([]string{"profile = dev"}, "")
+	q.setFilter(flt)
+	a.Len(fq.enqueued, 1)
+	a.Equal("delete", fq.enqueued["two"].Event)
+	a.Equal("etcd", fq.enqueued["two"].Source)
+
+	// Without a filter everything is sent as it is
+	fq.enqueued = map[string]*openapi.Event{}
+	q.setFilter(nil)
+	a.Equal(map[string]*openapi.Event{"three": event("update", "test")}, enqueue(map[string]*openapi.Event{
+		"three": event("update", "test"),
+	}))
+}
+
+func TestRestorePending(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "cnwan-reader-pipeline")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pending.json")
+	flt, _ := filter.New([]string{"profile in (video, voip)"}, "")
+	event := func(event, profile string) openapi.Event {
+		return openapi.Event{
+			Event:   event,
+			Service: openapi.Service{Name: "serv", Metadata: []openapi.Metadata{{Key: "profile", Value: profile}}},
+		}
+	}
+	pending := []queue.PendingEvent{
+		{Key: "one", Event: event("create", "video")},
+		{Key: "two", Event: event("create", "test")},
+		{Key: "three", Event: event("delete", "voip")},
+		{Key: "four", Event: event("delete", "test")},
+	}
+
+	// Restored events are filtered, including the deleted services
+	fq := &fakeQueue{enqueued: map[string]*openapi.Event{}}
+	a.NoError(queue.SavePending(path, pending))
+	restorePending(newFilterQueue(fq, flt), path)
+	a.Equal(map[string]*openapi.Event{"one": &pending[0].Event, "three": &pending[2].Event}, fq.enqueued)
+	_, err = os.Stat(path)
+	a.True(os.IsNotExist(err))
+
+	// Followers don't send them
+	fq = &fakeQueue{enqueued: map[string]*openapi.Event{}}
+	follower := election.Queue(fq, election.New(nil, election.Options{}))
+	a.NoError(queue.SavePending(path, pending))
+	restorePending(newFilterQueue(follower, flt), path)
+	follower.Resync()
+	a.Empty(fq.enqueued)
+}
+
+func TestFanOut(t *testing.T) {
+	a := assert.New(t)
+	ctx, canc := context.WithCancel(context.Background())
+	in := make(chan struct{})
+	out := []chan struct{}{make(chan struct{}, 1), make(chan struct{}, 1)}
+	done := make(chan struct{})
+	go func() {
+		fanOut(ctx, in, out, nil)
+		close(done)
+	}()
+
+	// The second value must not block, even if nobody received the first
+	in <- struct{}{}
+	in <- struct{}{}
+	canc()
+	<-done
+
+	for _, ch := range out {
+		a.Len(ch, 1)
+	}
+}
+
+func TestRun(t *testing.T) {
+	a := assert.New(t)
+	var (
+		lock     sync.Mutex
+		received []openapi.Event
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		events := []openapi.Event{}
+		json.NewDecoder(r.Body).Decode(&events)
+		lock.Lock()
+		received = append(received, events...)
+		lock.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+	adaptor := strings.TrimPrefix(srv.URL, "http://") + "/cnwan"
+
+	cases := []struct {
+		sources  []Source
+		opts     Options
+		expCode  int
+		expErr   bool
+		expCount int
+	}{
+		{
+			expErr: true,
+		},
+		{
+			sources: []Source{&fakeSource{name: "one"}},
+			expErr:  true,
+		},
+		{
+			sources: []Source{&fakeSource{name: "one"}},
+			opts:    Options{Adaptor: adaptor, EventsVersion: "v3"},
+			expErr:  true,
+		},
+		{
+			sources: []Source{
+				&fakeSource{
+					name:   "one",
+					events: map[string]*openapi.Event{"key": {Event: "create"}},
+					err:    errors.New("whatever"),
+				},
+			},
+			opts:     Options{Adaptor: adaptor},
+			expCode:  shutdown.ExitSourceFailed,
+			expCount: 1,
+		},
+		{
+			// The same key in different sources must not be coalesced
+			sources: []Source{
+				&fakeSource{
+					name:   "one",
+					events: map[string]*openapi.Event{"key": {Event: "create"}},
+				},
+				&fakeSource{
+					name:   "two",
+					events: map[string]*openapi.Event{"key": {Event: "create"}},
+					err:    errors.New("whatever"),
+				},
+			},
+			opts: Options{
+				Adaptor:  adaptor,
+				Shutdown: &shutdown.Options{DrainTimeout: 5 * time.Second},
+			},
+			expCode:  shutdown.ExitSourceFailed,
+			expCount: 2,
+		},
+	}
+
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+
+	for i, currCase := range cases {
+		received = nil
+		if currCase.opts.Queue == nil {
+			currCase.opts.Queue = &queue.Options{}
+		}
+
+		code, err := Run(currCase.sources, currCase.opts)
+		if !a.Equal(currCase.expErr, err != nil) || !a.Equal(currCase.expCode, code) {
+			failed(i)
+		}
+
+		lock.Lock()
+		count := len(received)
+		lock.Unlock()
+		if !a.Equal(currCase.expCount, count) {
+			failed(i)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package pipeline
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/filter"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/queue"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/services"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/transform"
+)
+
+// sourceQueue is a queue that prefixes the keys of the events with the
+// name of the source they come from, so that events from different
+// sources are never coalesced together.
+type sourceQueue struct {
+	queue.Queue
+	source string
+}
+
+// Enqueue enqueues the events with the name of the source as prefix of
+// their keys.
+func (s *sourceQueue) Enqueue(events map[string]*openapi.Event) {
+	prefixed := make(map[string]*openapi.Event, len(events))
+	for key, ev := range events {
+		prefixed[s.source+"/"+key] = ev
+	}
+
+	s.Queue.Enqueue(prefixed)
+}
+
+// transformQueue is a queue that changes the metadata of the services of
+// a source according to the transformer, before they are filtered. When
+// the transformer changes, services whose metadata change because of it
+// are sent again as update events.
+type transformQueue struct {
+	queue.Queue
+	lock        sync.Mutex
+	source      string
+	transformer *transform.Transformer
+	// last contains the last service received for each key, before and
+	// after being transformed.
+	last map[string]*transformedService
+}
+
+type transformedService struct {
+	original    openapi.Service
+	transformed openapi.Service
+}
+
+func newTransformQueue(q queue.Queue, source string, t *transform.Transformer) *transformQueue {
+	return &transformQueue{
+		Queue:       q,
+		source:      source,
+		transformer: t,
+		last:        map[string]*transformedService{},
+	}
+}
+
+// Enqueue enqueues the events with the metadata of their services
+// transformed. Update events that don't change the transformed services
+// are not enqueued.
+func (t *transformQueue) Enqueue(events map[string]*openapi.Event) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	transformed := map[string]*openapi.Event{}
+	for key, ev := range events {
+		tev := *ev
+		tev.Service.Metadata = t.transformer.Apply(t.source, ev.Service.Metadata)
+		prev, known := t.last[key]
+
+		if ev.Event == "delete" {
+			delete(t.last, key)
+			transformed[key] = &tev
+			continue
+		}
+
+		t.last[key] = &transformedService{original: ev.Service, transformed: tev.Service}
+		if ev.Event == "update" && known && reflect.DeepEqual(prev.transformed, tev.Service) {
+			continue
+		}
+		transformed[key] = &tev
+	}
+
+	if len(transformed) > 0 {
+		t.Queue.Enqueue(transformed)
+	}
+}
+
+// setTransformer replaces the transformer and sends the services whose
+// metadata change because of it as update events.
+func (t *transformQueue) setTransformer(tr *transform.Transformer) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.transformer = tr
+	events := map[string]*openapi.Event{}
+	for key, last := range t.last {
+		serv := last.original
+		serv.Metadata = tr.Apply(t.source, last.original.Metadata)
+		if reflect.DeepEqual(serv, last.transformed) {
+			continue
+		}
+
+		last.transformed = serv
+		events[key] = &openapi.Event{Event: "update", Service: serv}
+	}
+
+	if len(events) > 0 {
+		services.StampEvents(events, t.source)
+		t.Queue.Enqueue(events)
+	}
+}
+
+// filterQueue is a queue that only lets through the events of services
+// that match the filter. Services that enter or leave the filter, either
+// because their metadata changed or because the filter changed, are sent
+// as create or delete events, respectively.
+type filterQueue struct {
+	queue.Queue
+	lock   sync.Mutex
+	filter *filter.Filter
+	// last contains the last event received for each service and whether
+	// that service matches the filter.
+	last map[string]*filteredEvent
+}
+
+type filteredEvent struct {
+	event   openapi.Event
+	matches bool
+}
+
+func newFilterQueue(q queue.Queue, f *filter.Filter) *filterQueue {
+	return &filterQueue{
+		Queue:  q,
+		filter: f,
+		last:   map[string]*filteredEvent{},
+	}
+}
+
+// Enqueue enqueues the events of the services that match the filter, or
+// that stopped matching it.
+func (f *filterQueue) Enqueue(events map[string]*openapi.Event) {
+	f.enqueue(events, false)
+}
+
+// enqueue enqueues the events like Enqueue does. If restored is true, the
+// delete events of services that are not known are also enqueued if they
+// match the filter, as they were saved on a previous run.
+func (f *filterQueue) enqueue(events map[string]*openapi.Event, restored bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	filtered := map[string]*openapi.Event{}
+	for key, ev := range events {
+		prev, known := f.last[key]
+		matched := known && prev.matches
+
+		if ev.Event == "delete" {
+			delete(f.last, key)
+			if matched || (!known && restored && f.filter.MatchEvent(ev)) {
+				filtered[key] = ev
+			}
+			continue
+		}
+
+		matches := f.filter.MatchEvent(ev)
+		f.last[key] = &filteredEvent{event: *ev, matches: matches}
+
+		switch {
+		case f.filter == nil || (matches && matched):
+			filtered[key] = ev
+		case matches:
+			created := *ev
+			created.Event = "create"
+			filtered[key] = &created
+		case matched:
+			deleted := *ev
+			deleted.Event, deleted.Service = "delete", prev.event.Service
+			filtered[key] = &deleted
+		}
+	}
+
+	if len(filtered) > 0 {
+		f.Queue.Enqueue(filtered)
+	}
+}
+
+// restoredQueue is a queue that enqueues the events restored from a
+// previous run to the filterQueue.
+type restoredQueue struct {
+	*filterQueue
+}
+
+// Enqueue enqueues the restored events to the filterQueue.
+func (r restoredQueue) Enqueue(events map[string]*openapi.Event) {
+	r.enqueue(events, true)
+}
+
+// setFilter replaces the filter and sends the services that enter or
+// leave it as create or delete events, respectively.
+func (f *filterQueue) setFilter(flt *filter.Filter) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.filter = flt
+	bySource := map[string]map[string]*openapi.Event{}
+	for key, last := range f.last {
+		matches := flt.MatchEvent(&last.event)
+		if matches == last.matches {
+			continue
+		}
+		last.matches = matches
+
+		ev := last.event
+		ev.Event = "delete"
+		if matches {
+			ev.Event = "create"
+		}
+
+		if _, exists := bySource[ev.Source]; !exists {
+			bySource[ev.Source] = map[string]*openapi.Event{}
+		}
+		bySource[ev.Source][key] = &ev
+	}
+
+	for source, events := range bySource {
+		services.StampEvents(events, source)
+		f.Queue.Enqueue(events)
+	}
+}
@@ -21,6 +21,7 @@ package poller
 import (
 	"context"
 	"errors"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -34,19 +35,32 @@ type Poller interface {
 	Start() error
 	// SetPollFunction sets the function that must be called
 	SetPollFunction(fn)
+	// SetInterval changes the number of seconds between two consecutive
+	// polls, starting from the next one. It can also be called from the
+	// poll function.
+	SetInterval(interval int)
+	// Done returns a channel that is closed when the poller has stopped,
+	// i.e. after its context is done and the last poll has returned.
+	// If the poller was never started, the channel is never closed.
+	Done() <-chan struct{}
 }
 
 type funcPoller struct {
+	lock     sync.Mutex
 	interval time.Duration
+	reset    chan struct{}
 	mainCtx  context.Context
 	pollFunc fn
+	done     chan struct{}
 }
 
 // New returns a new instance of a poller
 func New(ctx context.Context, interval int) Poller {
 	return &funcPoller{
 		interval: time.Duration(interval) * time.Second,
+		reset:    make(chan struct{}, 1),
 		mainCtx:  ctx,
+		done:     make(chan struct{}),
 	}
 }
 
@@ -54,6 +68,33 @@ func (p *funcPoller) SetPollFunction(function fn) {
 	p.pollFunc = function
 }
 
+// SetInterval changes the interval between two consecutive polls
+func (p *funcPoller) SetInterval(interval int) {
+	if interval <= 0 {
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.interval == time.Duration(interval)*time.Second {
+		return
+	}
+	p.interval = time.Duration(interval) * time.Second
+
+	// Never blocks, as this may be called from the poll function
+	select {
+	case p.reset <- struct{}{}:
+	default:
+	}
+}
+
+func (p *funcPoller) getInterval() time.Duration {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.interval
+}
+
 // Start starts the poller
 func (p *funcPoller) Start() error {
 	if p.pollFunc == nil {
@@ -68,15 +109,28 @@ func (p *funcPoller) Start() error {
 	return nil
 }
 
+// Done returns a channel that is closed when the poller has stopped
+func (p *funcPoller) Done() <-chan struct{} {
+	return p.done
+}
+
 func (p *funcPoller) poll() {
 	l := log.With().Str("func", "poller.funcPoller.poll").Logger()
-	ticker := time.NewTicker(p.interval)
+	ticker := time.NewTicker(p.getInterval())
+	defer close(p.done)
 
 	for {
 		// Which one happens first?
 		select {
 		case <-ticker.C:
-			go p.pollFunc()
+			// Not in a goroutine on purpose: two polls must never overlap,
+			// otherwise the changes they find may be sent out of order.
+			p.pollFunc()
+		case <-p.reset:
+			ticker.Stop()
+			interval := p.getInterval()
+			ticker = time.NewTicker(interval)
+			l.Info().Dur("interval", interval).Msg("poll interval changed")
 		case <-p.mainCtx.Done():
 			l.Info().Msg("stop requested")
 			ticker.Stop()
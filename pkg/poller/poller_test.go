@@ -44,9 +44,31 @@ func TestPoll(t *testing.T) {
 	time.Sleep(5 * time.Second)
 	cancel()
 
+	select {
+	case <-p.Done():
+	case <-time.After(time.Second):
+		assert.Fail(t, "poller did not stop")
+	}
+
 	// At this point, the registered function should havbe been executed
 	// 3 times: once at Start(), and twice during these 5 seconds
 	if d.count != 3 {
 		assert.Fail(t, "polled more than twice or 3 times")
 	}
 }
+
+func TestSetInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &fakeData{}
+	p := New(ctx, 60)
+	p.SetPollFunction(d.call)
+	p.Start()
+
+	p.SetInterval(1)
+	time.Sleep(2500 * time.Millisecond)
+	cancel()
+	<-p.Done()
+
+	// Once at Start(), and twice during these 2.5 seconds instead of never
+	assert.Equal(t, 3, d.count)
+}
@@ -0,0 +1,59 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package queue
+
+import "fmt"
+
+// Options contains settings about how the queue sends events to the
+// handler. A zero value for any of its fields means no limit.
+type Options struct {
+	// MaxEventsPerRequest is the maximum number of events that are sent
+	// in a single request.
+	MaxEventsPerRequest int
+	// MaxBytesPerRequest is the maximum size, in bytes, of the events
+	// sent in a single request. An event that is bigger than this is
+	// still sent, but alone.
+	MaxBytesPerRequest int
+	// RateLimit is the maximum number of requests per second.
+	RateLimit float64
+	// RateLimitBurst is the number of requests that can be sent at once
+	// before RateLimit kicks in. Values lower than 1 are treated as 1.
+	RateLimitBurst int
+}
+
+// Validate returns an error if any of the options is not valid.
+func (o *Options) Validate() error {
+	if o.MaxEventsPerRequest < 0 {
+		return fmt.Errorf("invalid max events per request: %d", o.MaxEventsPerRequest)
+	}
+
+	if o.MaxBytesPerRequest < 0 {
+		return fmt.Errorf("invalid max bytes per request: %d", o.MaxBytesPerRequest)
+	}
+
+	if o.RateLimit < 0 {
+		return fmt.Errorf("invalid rate limit: %v", o.RateLimit)
+	}
+
+	if o.RateLimitBurst < 0 {
+		return fmt.Errorf("invalid rate limit burst: %d", o.RateLimitBurst)
+	}
+
+	return nil
+}
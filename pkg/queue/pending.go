@@ -0,0 +1,79 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package queue
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
+)
+
+// SavePending writes the events that could not be sent to the file in
+// path, so that they can be sent on next run with Restore.
+func SavePending(path string, events []PendingEvent) error {
+	data, err := json.MarshalIndent(events, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// LoadPending reads the events that were saved with SavePending.
+// If the file does not exist, an empty list is returned.
+func LoadPending(path string) ([]PendingEvent, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []PendingEvent{}, nil
+		}
+
+		return nil, err
+	}
+
+	events := []PendingEvent{}
+	if err := json.Unmarshal(data, &events); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// Restore enqueues the events that were saved with SavePending in path,
+// in the same order they were saved, and removes the file.
+//
+// It returns the number of events that have been restored.
+func Restore(q Queue, path string) (int, error) {
+	events, err := LoadPending(path)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	for i := range events {
+		q.Enqueue(map[string]*openapi.Event{events[i].Key: &events[i].Event})
+	}
+
+	return len(events), os.Remove(path)
+}
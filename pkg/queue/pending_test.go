@@ -0,0 +1,81 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package queue
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
+	"github.com/stretchr/testify/assert"
+)
+
+type enqueueRecorder struct {
+	enqueued []map[string]*openapi.Event
+}
+
+func (e *enqueueRecorder) Enqueue(events map[string]*openapi.Event) {
+	e.enqueued = append(e.enqueued, events)
+}
+
+func (e *enqueueRecorder) Close(_ context.Context) []PendingEvent {
+	return []PendingEvent{}
+}
+
+func TestSaveLoadRestorePending(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "cnwan-reader-pending")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pending.json")
+
+	// Not existing file
+	loaded, err := LoadPending(path)
+	a.NoError(err)
+	a.Empty(loaded)
+
+	events := []PendingEvent{
+		{Key: "b", Event: openapi.Event{Id: "1", Sequence: 1, Event: "create", Service: openapi.Service{Name: "b"}}},
+		{Key: "a", Event: openapi.Event{Id: "2", Sequence: 2, Event: "delete", Service: openapi.Service{Name: "a"}}},
+	}
+	a.NoError(SavePending(path, events))
+
+	loaded, err = LoadPending(path)
+	a.NoError(err)
+	a.Equal(events, loaded)
+
+	q := &enqueueRecorder{}
+	restored, err := Restore(q, path)
+	a.NoError(err)
+	a.Equal(2, restored)
+	a.Len(q.enqueued, 2)
+	a.Equal(events[0].Event, *q.enqueued[0]["b"])
+	a.Equal(events[1].Event, *q.enqueued[1]["a"])
+
+	_, err = os.Stat(path)
+	a.True(os.IsNotExist(err))
+
+	// Invalid file
+	a.NoError(ioutil.WriteFile(path, []byte("{invalid"), 0600))
+	_, err = LoadPending(path)
+	a.Error(err)
+}
@@ -19,7 +19,10 @@
 package queue
 
 import (
+	"container/list"
 	"context"
+	"encoding/json"
+	"sort"
 	"sync"
 
 	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
@@ -29,69 +32,242 @@ import (
 
 // Queue contains data that will be sent to a handler
 type Queue interface {
-	// Enqueue intructs the queue that a new data must be sent on next request
+	// Enqueue intructs the queue that a new data must be sent on next request.
+	//
+	// It never blocks: events are sent in the same order they are enqueued
+	// and events for the same key that are still waiting to be sent are
+	// coalesced into a single one.
 	Enqueue(events map[string]*openapi.Event)
+	// Close stops the queue after trying to send all the events that are
+	// still waiting to be sent. Events enqueued after this is called are
+	// discarded.
+	//
+	// It returns the events that could not be sent, either because of an
+	// error or because ctx expired before they could be sent.
+	Close(ctx context.Context) []PendingEvent
+}
+
+// PendingEvent is an event that is waiting to be sent, along with the key
+// it was enqueued with.
+type PendingEvent struct {
+	// Key of the event
+	Key string `json:"key"`
+	// Event that must be sent
+	Event openapi.Event `json:"event"`
 }
 
 type senderWorkQueue struct {
-	mainCtx      context.Context
-	lock         sync.Mutex
-	wakeUp       chan int
-	queue        map[string]*openapi.Event
+	mainCtx context.Context
+	// workCtx is cancelled as soon as the queue is closed, so that the
+	// worker stops sending events on its own and waits to flush them.
+	workCtx  context.Context
+	stopWork context.CancelFunc
+	lock     sync.Mutex
+	wakeUp   chan struct{}
+	flush    chan context.Context
+	flushed  chan []PendingEvent
+	done     chan struct{}
+	closed   bool
+	// order contains the events waiting to be sent, in the same order as
+	// they have been enqueued.
+	order *list.List
+	// pending contains the position of each key in order.
+	pending      map[string]*list.Element
 	servsHandler services.Handler
+	opts         Options
+	limiter      *tokenBucket
 }
 
 // New returns a Queue that receives data and sends it in bulk whenever
-// possible
-func New(ctx context.Context, servsHandler services.Handler) Queue {
+// possible.
+//
+// opts defines how events are split in multiple requests and how often
+// they are sent: if nil, all events are sent in a single request as soon
+// as possible.
+func New(ctx context.Context, servsHandler services.Handler, opts *Options) Queue {
+	queue := newSenderWorkQueue(ctx, servsHandler, opts)
+	go queue.work()
+
+	return queue
+}
+
+func newSenderWorkQueue(ctx context.Context, servsHandler services.Handler, opts *Options) *senderWorkQueue {
+	workCtx, stopWork := context.WithCancel(ctx)
 	queue := &senderWorkQueue{
 		mainCtx:      ctx,
-		wakeUp:       make(chan int),
-		queue:        map[string]*openapi.Event{},
+		workCtx:      workCtx,
+		stopWork:     stopWork,
+		wakeUp:       make(chan struct{}, 1),
+		flush:        make(chan context.Context),
+		flushed:      make(chan []PendingEvent),
+		done:         make(chan struct{}),
+		order:        list.New(),
+		pending:      map[string]*list.Element{},
 		servsHandler: servsHandler,
 	}
 
-	go queue.work()
+	if opts != nil {
+		queue.opts = *opts
+	}
+	if queue.opts.RateLimit > 0 {
+		queue.limiter = newTokenBucket(queue.opts.RateLimit, queue.opts.RateLimitBurst)
+	}
 
 	return queue
 }
 
 // Enqueue intructs the queue that new data must be sent on next request
 func (s *senderWorkQueue) Enqueue(events map[string]*openapi.Event) {
-	wake := func() bool {
+	if len(events) == 0 {
+		return
+	}
+
+	closed := func() bool {
 		s.lock.Lock()
 		defer s.lock.Unlock()
 
-		shouldWakeUp := true
-		if len(s.queue) > 0 {
-			// There was already something in the queue. It means that the
-			// worker is already awake, no need to do it again.
-			shouldWakeUp = false
+		if s.closed {
+			return true
 		}
 
-		for key, event := range events {
-			s.queue[key] = event
+		for _, key := range sortEventsKeys(events) {
+			s.push(key, events[key])
 		}
 
-		return shouldWakeUp
+		return false
 	}()
+	if closed {
+		log.Warn().Str("func", "queue.senderWorkQueue.Enqueue").Int("discarded", len(events)).Msg("queue is closed: discarding events...")
+		return
+	}
+
+	// Wake up the consumer, without blocking: if there is already a
+	// wake up pending, the worker will find these events as well.
+	select {
+	case s.wakeUp <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops the queue after trying to send all the events that are
+// still waiting to be sent.
+func (s *senderWorkQueue) Close(ctx context.Context) []PendingEvent {
+	alreadyClosed := func() bool {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		wasClosed := s.closed
+		s.closed = true
+		return wasClosed
+	}()
+	if alreadyClosed {
+		return []PendingEvent{}
+	}
 
-	if wake {
-		// Wake up the consumer
-		// 0 is a dumb value
-		s.wakeUp <- 0
+	// Stop the worker from sending on its own
+	s.stopWork()
+
+	select {
+	case s.flush <- ctx:
+		return <-s.flushed
+	case <-s.done:
+		// The worker was already stopped by the main context: nothing
+		// can be sent anymore.
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		return s.pop()
 	}
 }
 
+// push puts the event in the queue, coalescing it with the one that is
+// already waiting to be sent for the same key, if any.
+//
+// This must be called while holding the lock.
+func (s *senderWorkQueue) push(key string, event *openapi.Event) {
+	if event == nil {
+		return
+	}
+
+	elem, exists := s.pending[key]
+	if !exists {
+		s.pending[key] = s.order.PushBack(&PendingEvent{Key: key, Event: *event})
+		return
+	}
+
+	queued := elem.Value.(*PendingEvent)
+	merged := coalesce(&queued.Event, event)
+	if merged == nil {
+		// They cancel each other out
+		s.order.Remove(elem)
+		delete(s.pending, key)
+		return
+	}
+
+	queued.Event = *merged
+}
+
+// pushFront puts back events that were taken from the queue but not sent,
+// before any other event, as they are older than them.
+//
+// This must be called while holding the lock.
+func (s *senderWorkQueue) pushFront(events []PendingEvent) {
+	for i := len(events) - 1; i >= 0; i-- {
+		older := events[i]
+
+		elem, exists := s.pending[older.Key]
+		if !exists {
+			s.pending[older.Key] = s.order.PushFront(&PendingEvent{Key: older.Key, Event: older.Event})
+			continue
+		}
+
+		newer := elem.Value.(*PendingEvent)
+		s.order.Remove(elem)
+		delete(s.pending, older.Key)
+
+		if merged := coalesce(&older.Event, &newer.Event); merged != nil {
+			s.pending[older.Key] = s.order.PushFront(&PendingEvent{Key: older.Key, Event: *merged})
+		}
+	}
+}
+
+// pop removes all the events from the queue and returns them in the
+// same order as they have been enqueued.
+//
+// This must be called while holding the lock.
+func (s *senderWorkQueue) pop() []PendingEvent {
+	events := make([]PendingEvent, 0, s.order.Len())
+	for elem := s.order.Front(); elem != nil; elem = elem.Next() {
+		events = append(events, *elem.Value.(*PendingEvent))
+	}
+
+	s.order.Init()
+	s.pending = map[string]*list.Element{}
+
+	return events
+}
+
 func (s *senderWorkQueue) work() {
 	l := log.With().Str("func", "queue.senderWorkQueue.work").Logger()
+	defer close(s.done)
 
 	for {
 		select {
 		case <-s.wakeUp:
 			l.Debug().Msg("worker woke up")
 			// I have been woken up. This means there's work to do
-			s.sendData()
+			_, notSent := s.sendData(s.workCtx)
+			if len(notSent) > 0 {
+				// We were interrupted: put them back so they can be
+				// flushed.
+				s.lock.Lock()
+				s.pushFront(notSent)
+				s.lock.Unlock()
+			}
+		case flushCtx := <-s.flush:
+			l.Info().Msg("flushing events...")
+			failed, notSent := s.sendData(flushCtx)
+			s.flushed <- append(failed, notSent...)
+			return
 		case <-s.mainCtx.Done():
 			l.Info().Msg("stop requested")
 			return
@@ -99,34 +275,182 @@ func (s *senderWorkQueue) work() {
 	}
 }
 
-func (s *senderWorkQueue) sendData() {
+// sendData takes all the events from the queue and sends them.
+//
+// It returns the events that were not sent because of an error and the
+// ones that were not sent at all because ctx expired.
+func (s *senderWorkQueue) sendData(ctx context.Context) (failed, notSent []PendingEvent) {
 	l := log.With().Str("func", "queue.senderWorkQueue.sendData").Logger()
 
-	data := func() []openapi.Event {
+	data := func() []PendingEvent {
 		s.lock.Lock()
 		defer s.lock.Unlock()
-		events := make([]openapi.Event, 0, len(s.queue))
 
 		// We copy the queue to an array so that we can directly send it,
 		// this way we release the lock immediately, so other components
 		// can enqueue new data while we're busy sending.
-		for _, event := range s.queue {
-			events = append(events, *event)
+		return s.pop()
+	}()
+
+	if len(data) == 0 {
+		// Everything that was enqueued has been coalesced or was already
+		// sent by a previous call.
+		return
+	}
+
+	// Batches are sent one after the other, so events for the same key
+	// are still delivered in order.
+	batches := splitBatches(data, s.opts.MaxEventsPerRequest, s.opts.MaxBytesPerRequest)
+	for i, batch := range batches {
+		l := l.With().Int("length", len(batch)).Int("batch", i+1).Int("batches", len(batches)).Logger()
+
+		if ctx.Err() == nil && s.limiter != nil {
+			// If this fails, it's because ctx is done: this is handled
+			// right below.
+			s.limiter.wait(ctx)
+		}
+		if ctx.Err() != nil {
+			l.Info().Err(ctx.Err()).Msg("stopped while waiting to send data")
+			for _, remaining := range batches[i:] {
+				notSent = append(notSent, remaining...)
+			}
+			return
 		}
 
-		// Empty the queue, so we don't resend these values again
-		s.queue = map[string]*openapi.Event{}
+		l.Info().Msg("sending data...")
+		if err := s.servsHandler.Send(toEvents(batch)); err != nil {
+			// The error is logged from the service handler
+			failed = append(failed, batch...)
+			continue
+		}
 
-		return events
-	}()
+		l.Info().Msg("events sent successfully")
+	}
 
-	l = l.With().Int("length", len(data)).Logger()
-	l.Info().Msg("sending data...")
+	return
+}
 
-	if err := s.servsHandler.Send((data)); err != nil {
-		// The error is logged from the service handler
-		return
+// splitBatches splits the events in batches that respect the maximum
+// number of events and bytes per request, preserving their order.
+// A value of 0 for maxEvents or maxBytes means no limit.
+func splitBatches(events []PendingEvent, maxEvents, maxBytes int) [][]PendingEvent {
+	if len(events) == 0 {
+		return [][]PendingEvent{}
+	}
+
+	if maxEvents <= 0 && maxBytes <= 0 {
+		return [][]PendingEvent{events}
+	}
+
+	l := log.With().Str("func", "queue.splitBatches").Logger()
+	batches := [][]PendingEvent{}
+	curr := []PendingEvent{}
+	// The brackets of the JSON array
+	currBytes := 2
+
+	for _, event := range events {
+		evBytes := 0
+		if maxBytes > 0 {
+			evBytes = eventSize(event.Event)
+			if evBytes+2 > maxBytes {
+				l.Warn().Str("name", event.Event.Service.Name).Int("bytes", evBytes).Msg("event is bigger than max bytes per request: it will be sent alone")
+			}
+		}
+
+		exceedsEvents := maxEvents > 0 && len(curr)+1 > maxEvents
+		// The comma separating this event from the previous one
+		exceedsBytes := maxBytes > 0 && len(curr) > 0 && currBytes+1+evBytes > maxBytes
+
+		if len(curr) > 0 && (exceedsEvents || exceedsBytes) {
+			batches = append(batches, curr)
+			curr = []PendingEvent{}
+			currBytes = 2
+		}
+
+		if len(curr) > 0 {
+			currBytes++
+		}
+		curr = append(curr, event)
+		currBytes += evBytes
+	}
+
+	return append(batches, curr)
+}
+
+// eventSize returns the size of the event once encoded to JSON.
+func eventSize(event openapi.Event) int {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return 0
+	}
+
+	return len(data)
+}
+
+func toEvents(pending []PendingEvent) []openapi.Event {
+	events := make([]openapi.Event, len(pending))
+	for i := range pending {
+		events[i] = pending[i].Event
 	}
 
-	l.Info().Msg("events sent successfully")
+	return events
+}
+
+// coalesce merges two events for the same key, where next happened after
+// prev and prev has not been sent yet.
+//
+// It returns nil if the two events cancel each other out, i.e. an object
+// was created and then deleted before the adaptor knew about it.
+func coalesce(prev, next *openapi.Event) *openapi.Event {
+	merged := *next
+
+	switch prev.Event {
+	case "create":
+		if next.Event == "delete" {
+			return nil
+		}
+
+		// The adaptor doesn't know about this yet, so it is still a
+		// creation, but with the most recent data.
+		merged.Event = "create"
+	case "update":
+		if next.Event != "delete" {
+			merged.Event = "update"
+		}
+	case "delete":
+		if next.Event != "delete" {
+			// The adaptor still knows about the old version, so this is
+			// an update for it.
+			merged.Event = "update"
+		}
+	}
+
+	return &merged
+}
+
+// sortEventsKeys returns the keys of the events sorted by their sequence
+// number, or by the key itself if they don't have one.
+func sortEventsKeys(events map[string]*openapi.Event) []string {
+	keys := make([]string, 0, len(events))
+	for key := range events {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		iSeq, jSeq := int64(0), int64(0)
+		if ev := events[keys[i]]; ev != nil {
+			iSeq = ev.Sequence
+		}
+		if ev := events[keys[j]]; ev != nil {
+			jSeq = ev.Sequence
+		}
+
+		if iSeq != jSeq {
+			return iSeq < jSeq
+		}
+
+		return keys[i] < keys[j]
+	})
+
+	return keys
 }
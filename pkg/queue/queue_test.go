@@ -20,6 +20,8 @@ package queue
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"testing"
 	"time"
 
@@ -88,10 +90,12 @@ func TestEnqueue(t *testing.T) {
 	ctx, canc := context.WithCancel(context.Background())
 	defer canc()
 
-	q := New(ctx, f)
-	go q.Enqueue(firstMap)
+	q := New(ctx, f, nil)
+	q.Enqueue(firstMap)
 	time.Sleep(2 * time.Second)
-	go q.Enqueue(secondMap)
+	// This must not block, even if the worker is still busy sending the
+	// first map.
+	q.Enqueue(secondMap)
 
 	// Block here, for the first call
 	firstCall := <-result
@@ -105,3 +109,308 @@ func TestEnqueue(t *testing.T) {
 		assert.Fail(t, "second call had not 3 items but", secondCall)
 	}
 }
+
+type recorderHandler struct {
+	sent    chan []openapi.Event
+	release chan bool
+	err     error
+}
+
+func (r *recorderHandler) Send(events []openapi.Event) error {
+	r.sent <- events
+	<-r.release
+	return r.err
+}
+
+func TestEnqueueOrderAndCoalescing(t *testing.T) {
+	a := assert.New(t)
+	ev := func(event, name string) *openapi.Event {
+		return &openapi.Event{Event: event, Service: openapi.Service{Name: name}}
+	}
+	names := func(events []openapi.Event) (res []string) {
+		for _, e := range events {
+			res = append(res, fmt.Sprintf("%s:%s", e.Event, e.Service.Name))
+		}
+		return
+	}
+
+	r := &recorderHandler{sent: make(chan []openapi.Event), release: make(chan bool)}
+	ctx, canc := context.WithCancel(context.Background())
+	defer canc()
+
+	q := New(ctx, r, nil)
+
+	// The worker picks this up and gets stuck on Send
+	q.Enqueue(map[string]*openapi.Event{"one": ev("create", "one-v1")})
+	a.Equal([]string{"create:one-v1"}, names(<-r.sent))
+
+	// While the worker is busy, enqueues must not block and must be
+	// coalesced.
+	done := make(chan bool)
+	go func() {
+		q.Enqueue(map[string]*openapi.Event{"one": ev("update", "one-v2")})
+		q.Enqueue(map[string]*openapi.Event{"two": ev("create", "two-v1")})
+		q.Enqueue(map[string]*openapi.Event{"three": ev("create", "three-v1")})
+		q.Enqueue(map[string]*openapi.Event{"two": ev("update", "two-v2")})
+		q.Enqueue(map[string]*openapi.Event{"three": ev("delete", "three-v1")})
+		q.Enqueue(map[string]*openapi.Event{"one": ev("update", "one-v3")})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		a.FailNow("enqueue blocked while the worker was busy")
+	}
+
+	r.release <- true
+	a.Equal([]string{"update:one-v3", "create:two-v2"}, names(<-r.sent))
+	r.release <- true
+}
+
+func TestCoalesce(t *testing.T) {
+	a := assert.New(t)
+	ev := func(event, name string) *openapi.Event {
+		return &openapi.Event{Event: event, Service: openapi.Service{Name: name}, Sequence: int64(len(name))}
+	}
+
+	cases := []struct {
+		prev   *openapi.Event
+		next   *openapi.Event
+		expRes *openapi.Event
+	}{
+		{
+			prev: ev("create", "old"),
+			next: ev("delete", "old"),
+		},
+		{
+			prev:   ev("create", "old"),
+			next:   ev("update", "newer"),
+			expRes: ev("create", "newer"),
+		},
+		{
+			prev:   ev("create", "old"),
+			next:   ev("create", "newer"),
+			expRes: ev("create", "newer"),
+		},
+		{
+			prev:   ev("update", "old"),
+			next:   ev("update", "newer"),
+			expRes: ev("update", "newer"),
+		},
+		{
+			prev:   ev("update", "old"),
+			next:   ev("delete", "newer"),
+			expRes: ev("delete", "newer"),
+		},
+		{
+			prev:   ev("delete", "old"),
+			next:   ev("create", "newer"),
+			expRes: ev("update", "newer"),
+		},
+		{
+			prev:   ev("delete", "old"),
+			next:   ev("delete", "newer"),
+			expRes: ev("delete", "newer"),
+		},
+	}
+
+	for i, currCase := range cases {
+		res := coalesce(currCase.prev, currCase.next)
+		if !a.Equal(currCase.expRes, res) {
+			a.FailNow("case failed", fmt.Sprintf("case %d", i))
+		}
+	}
+}
+
+func TestSortEventsKeys(t *testing.T) {
+	a := assert.New(t)
+
+	res := sortEventsKeys(map[string]*openapi.Event{
+		"a": {Sequence: 3},
+		"b": {Sequence: 1},
+		"c": {Sequence: 2},
+	})
+	a.Equal([]string{"b", "c", "a"}, res)
+
+	res = sortEventsKeys(map[string]*openapi.Event{
+		"c": {},
+		"a": {},
+		"b": {},
+	})
+	a.Equal([]string{"a", "b", "c"}, res)
+}
+
+func TestSplitBatches(t *testing.T) {
+	a := assert.New(t)
+	ev := func(name string) PendingEvent {
+		return PendingEvent{
+			Key:   name,
+			Event: openapi.Event{Event: "create", Service: openapi.Service{Name: name, Address: "10.10.10.10", Port: 80}},
+		}
+	}
+	names := func(batches [][]PendingEvent) (res [][]string) {
+		for _, batch := range batches {
+			names := []string{}
+			for _, e := range batch {
+				names = append(names, e.Key)
+			}
+			res = append(res, names)
+		}
+		return
+	}
+	events := []PendingEvent{ev("a"), ev("b"), ev("c"), ev("d"), ev("e")}
+	evSize := eventSize(ev("a").Event)
+
+	cases := []struct {
+		events    []PendingEvent
+		maxEvents int
+		maxBytes  int
+		expRes    [][]string
+	}{
+		{
+			events: []PendingEvent{},
+		},
+		{
+			events: events,
+			expRes: [][]string{{"a", "b", "c", "d", "e"}},
+		},
+		{
+			events:    events,
+			maxEvents: 2,
+			expRes:    [][]string{{"a", "b"}, {"c", "d"}, {"e"}},
+		},
+		{
+			events:   events,
+			maxBytes: 2 + evSize*3 + 2,
+			expRes:   [][]string{{"a", "b", "c"}, {"d", "e"}},
+		},
+		{
+			events:    events,
+			maxEvents: 2,
+			maxBytes:  2 + evSize*3 + 2,
+			expRes:    [][]string{{"a", "b"}, {"c", "d"}, {"e"}},
+		},
+		{
+			events:   events[:2],
+			maxBytes: 1,
+			expRes:   [][]string{{"a"}, {"b"}},
+		},
+	}
+
+	for i, currCase := range cases {
+		res := splitBatches(currCase.events, currCase.maxEvents, currCase.maxBytes)
+		if !a.Equal(currCase.expRes, names(res)) {
+			a.FailNow("case failed", fmt.Sprintf("case %d", i))
+		}
+	}
+}
+
+func TestSendDataInBatches(t *testing.T) {
+	a := assert.New(t)
+	r := &recorderHandler{sent: make(chan []openapi.Event, 10), release: make(chan bool, 10)}
+	for i := 0; i < 10; i++ {
+		r.release <- true
+	}
+
+	q := newSenderWorkQueue(context.Background(), r, &Options{MaxEventsPerRequest: 2})
+	q.push("c", &openapi.Event{Event: "create", Service: openapi.Service{Name: "c"}})
+	q.push("a", &openapi.Event{Event: "create", Service: openapi.Service{Name: "a"}})
+	q.push("b", &openapi.Event{Event: "create", Service: openapi.Service{Name: "b"}})
+
+	failed, notSent := q.sendData(context.Background())
+	close(r.sent)
+	a.Empty(failed)
+	a.Empty(notSent)
+
+	sent := []string{}
+	batches := 0
+	for batch := range r.sent {
+		batches++
+		for _, e := range batch {
+			sent = append(sent, e.Service.Name)
+		}
+	}
+
+	a.Equal(2, batches)
+	a.Equal([]string{"c", "a", "b"}, sent)
+}
+
+func TestClose(t *testing.T) {
+	a := assert.New(t)
+	ev := func(name string) map[string]*openapi.Event {
+		return map[string]*openapi.Event{name: {Event: "create", Service: openapi.Service{Name: name}}}
+	}
+	keys := func(pending []PendingEvent) (res []string) {
+		for _, p := range pending {
+			res = append(res, p.Key)
+		}
+		return
+	}
+
+	// Events in the queue are sent before closing
+	r := &recorderHandler{sent: make(chan []openapi.Event, 10), release: make(chan bool, 10)}
+	q := New(context.Background(), r, nil)
+	q.Enqueue(ev("one"))
+	<-r.sent
+	q.Enqueue(ev("two"))
+	q.Enqueue(ev("three"))
+
+	res := make(chan []PendingEvent)
+	go func() {
+		res <- q.Close(context.Background())
+	}()
+	for i := 0; i < 2; i++ {
+		r.release <- true
+	}
+	a.Empty(<-res)
+	a.Len(<-r.sent, 2)
+
+	// Events enqueued after closing are discarded
+	q.Enqueue(ev("four"))
+	a.Empty(q.Close(context.Background()))
+
+	// Events that could not be sent are returned in order
+	r = &recorderHandler{sent: make(chan []openapi.Event, 10), release: make(chan bool, 10), err: errors.New("error")}
+	q = New(context.Background(), r, nil)
+	q.Enqueue(ev("one"))
+	<-r.sent
+	q.Enqueue(ev("two"))
+	q.Enqueue(ev("three"))
+	r.release <- true
+	r.release <- true
+	a.Equal([]string{"two", "three"}, keys(q.Close(context.Background())))
+
+	// Events that could not be sent before the deadline are returned
+	r = &recorderHandler{sent: make(chan []openapi.Event, 10), release: make(chan bool, 10)}
+	q = New(context.Background(), r, &Options{MaxEventsPerRequest: 1, RateLimit: 0.001})
+	q.Enqueue(ev("one"))
+	<-r.sent
+	q.Enqueue(ev("two"))
+	r.release <- true
+	ctx, canc := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer canc()
+	a.Equal([]string{"two"}, keys(q.Close(ctx)))
+}
+
+func TestPushFront(t *testing.T) {
+	a := assert.New(t)
+	ev := func(event, name string) *openapi.Event {
+		return &openapi.Event{Event: event, Service: openapi.Service{Name: name}}
+	}
+
+	q := newSenderWorkQueue(context.Background(), &fakeHandler{}, nil)
+	q.push("two", ev("update", "two-v2"))
+	q.push("three", ev("create", "three-v1"))
+	q.pushFront([]PendingEvent{
+		{Key: "one", Event: *ev("create", "one-v1")},
+		{Key: "two", Event: *ev("create", "two-v1")},
+	})
+
+	res := q.pop()
+	a.Len(res, 3)
+	a.Equal("one", res[0].Key)
+	a.Equal("two", res[1].Key)
+	a.Equal(*ev("create", "two-v2"), res[1].Event)
+	a.Equal("three", res[2].Key)
+}
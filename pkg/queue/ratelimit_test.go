@@ -0,0 +1,71 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucketReserve(t *testing.T) {
+	a := assert.New(t)
+	now := time.Now()
+	tb := &tokenBucket{rate: 2, burst: 2, tokens: 2, last: now}
+
+	// The burst can be used immediately
+	a.Zero(tb.reserve(now))
+	a.Zero(tb.reserve(now))
+
+	// Then we need to wait for a new token: 2 per second
+	a.Equal(500*time.Millisecond, tb.reserve(now))
+	a.Equal(time.Second, tb.reserve(now))
+
+	// After a long time, the bucket is full again but never more than the
+	// burst
+	later := now.Add(time.Minute)
+	a.Zero(tb.reserve(later))
+	a.Zero(tb.reserve(later))
+	a.Equal(500*time.Millisecond, tb.reserve(later))
+}
+
+func TestTokenBucketWait(t *testing.T) {
+	a := assert.New(t)
+	tb := newTokenBucket(1, 0)
+
+	a.NoError(tb.wait(context.Background()))
+
+	// No tokens left: this must return as soon as the context is done
+	ctx, canc := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer canc()
+	a.Equal(context.DeadlineExceeded, tb.wait(ctx))
+}
+
+func TestOptionsValidate(t *testing.T) {
+	a := assert.New(t)
+
+	a.NoError((&Options{}).Validate())
+	a.NoError((&Options{MaxEventsPerRequest: 10, MaxBytesPerRequest: 1024, RateLimit: 0.5, RateLimitBurst: 2}).Validate())
+	a.Error((&Options{MaxEventsPerRequest: -1}).Validate())
+	a.Error((&Options{MaxBytesPerRequest: -1}).Validate())
+	a.Error((&Options{RateLimit: -1}).Validate())
+	a.Error((&Options{RateLimitBurst: -1}).Validate())
+}
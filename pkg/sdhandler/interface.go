@@ -18,10 +18,28 @@
 
 package sdhandler
 
-import "github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
+import (
+	"time"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
+)
+
+const (
+	// maxConcurrentRequests is the maximum number of services whose
+	// endpoints are listed at the same time.
+	maxConcurrentRequests int = 10
+	// callTimeout is the maximum time a single list operation, including
+	// all its pages, can take.
+	callTimeout time.Duration = 30 * time.Second
+)
 
 // Handler is in charge of getting data from service directory
 type Handler interface {
-	// GetServices loads services from service directory
-	GetServices() map[string]*openapi.Service
+	// GetServices loads services from service directory. An error is
+	// returned if any of them could not be loaded, so that a partial list
+	// is never mistaken for the current state.
+	GetServices() (map[string]*openapi.Service, error)
+	// Close closes the connections to service directory. The handler
+	// cannot be used anymore after that.
+	Close() error
 }
@@ -0,0 +1,158 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package sdhandler
+
+import (
+	"context"
+	"net/http"
+	"path"
+
+	sd "cloud.google.com/go/servicedirectory/apiv1"
+	"google.golang.org/api/iterator"
+	sdrest "google.golang.org/api/servicedirectory/v1"
+	sdpb "google.golang.org/genproto/googleapis/cloud/servicedirectory/v1"
+)
+
+// service is a service registered in Service Directory.
+type service struct {
+	name        string
+	annotations map[string]string
+}
+
+// endpoint is an endpoint registered in Service Directory.
+type endpoint struct {
+	name        string
+	address     string
+	port        int32
+	annotations map[string]string
+}
+
+// lister lists resources from Service Directory, going through all pages.
+type lister interface {
+	// listLocations returns the IDs of all the regions of project where
+	// Service Directory is available.
+	listLocations(ctx context.Context, project string) ([]string, error)
+	// listNamespaces returns the names of all namespaces in parent.
+	listNamespaces(ctx context.Context, parent string) ([]string, error)
+	// listServices returns the services of namespace.
+	listServices(ctx context.Context, namespace string) ([]*service, error)
+	// listEndpoints returns the endpoints of service that match filter.
+	listEndpoints(ctx context.Context, service, filter string) ([]*endpoint, error)
+}
+
+// registrationLister is a lister that uses the registration client of
+// Service Directory and, only to list locations, its REST client. Each call
+// is bound to callTimeout.
+type registrationLister struct {
+	cl         *sd.RegistrationClient
+	rest       *sdrest.APIService
+	httpClient *http.Client
+}
+
+// Close closes the connections of both clients.
+func (r *registrationLister) Close() error {
+	r.httpClient.CloseIdleConnections()
+	return r.cl.Close()
+}
+
+func (r *registrationLister) listLocations(ctx context.Context, project string) ([]string, error) {
+	ctx, canc := context.WithTimeout(ctx, callTimeout)
+	defer canc()
+
+	regions := []string{}
+	err := r.rest.Projects.Locations.List(path.Join("projects", project)).Pages(ctx, func(resp *sdrest.ListLocationsResponse) error {
+		for _, loc := range resp.Locations {
+			regions = append(regions, loc.LocationId)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return regions, nil
+}
+
+func (r *registrationLister) listNamespaces(ctx context.Context, parent string) ([]string, error) {
+	ctx, canc := context.WithTimeout(ctx, callTimeout)
+	defer canc()
+
+	nsList := []string{}
+	it := r.cl.ListNamespaces(ctx, &sdpb.ListNamespacesRequest{Parent: parent})
+	for {
+		resp, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		nsList = append(nsList, resp.Name)
+	}
+
+	return nsList, nil
+}
+
+func (r *registrationLister) listServices(ctx context.Context, namespace string) ([]*service, error) {
+	ctx, canc := context.WithTimeout(ctx, callTimeout)
+	defer canc()
+
+	servList := []*service{}
+	it := r.cl.ListServices(ctx, &sdpb.ListServicesRequest{Parent: namespace})
+	for {
+		resp, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		servList = append(servList, &service{name: resp.Name, annotations: resp.Annotations})
+	}
+
+	return servList, nil
+}
+
+func (r *registrationLister) listEndpoints(ctx context.Context, service, filter string) ([]*endpoint, error) {
+	ctx, canc := context.WithTimeout(ctx, callTimeout)
+	defer canc()
+
+	epList := []*endpoint{}
+	it := r.cl.ListEndpoints(ctx, &sdpb.ListEndpointsRequest{Parent: service, Filter: filter})
+	for {
+		resp, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		epList = append(epList, &endpoint{
+			name:        resp.Name,
+			address:     resp.Address,
+			port:        resp.Port,
+			annotations: resp.Annotations,
+		})
+	}
+
+	return epList, nil
+}
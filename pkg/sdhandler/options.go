@@ -0,0 +1,102 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package sdhandler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/services"
+)
+
+const (
+	// PrecedenceEndpoint means that annotations of the endpoint win over the
+	// ones of its service when a key is in both.
+	PrecedenceEndpoint string = "endpoint"
+	// PrecedenceService means that annotations of the service win over the
+	// ones of its endpoints when a key is in both.
+	PrecedenceService string = "service"
+)
+
+// Location is a project and a region where services are registered.
+type Location struct {
+	// Project is the ID of the Google Cloud project.
+	Project string
+	// Region is the region where services are registered. If empty, all
+	// regions of the project are scanned.
+	Region string
+}
+
+// ParseLocation parses a location in the form of project/region, or just
+// project for all regions of the project.
+func ParseLocation(loc string) (Location, error) {
+	parts := strings.Split(loc, "/")
+	switch {
+	case len(parts) == 1 && len(parts[0]) > 0:
+		return Location{Project: parts[0]}, nil
+	case len(parts) == 2 && len(parts[0]) > 0 && len(parts[1]) > 0:
+		return Location{Project: parts[0], Region: parts[1]}, nil
+	default:
+		return Location{}, fmt.Errorf("invalid location: %s", loc)
+	}
+}
+
+// Options contains data needed to connect to Service Directory and to read
+// services from it.
+type Options struct {
+	// Locations are the projects and regions to scan.
+	Locations []Location
+	// MetadataKey is the key that endpoints must have in their annotations,
+	// or in the ones of their service, to be reported.
+	MetadataKey string
+	// CredentialsPath is the path of the service account JSON file. If
+	// empty, Application Default Credentials are used.
+	CredentialsPath string
+	// MetadataPrecedence is which of endpoint or service wins when a key
+	// is in the annotations of both. Defaults to PrecedenceEndpoint.
+	MetadataPrecedence string
+	// ExtraMetadata contains which annotations are reported in addition
+	// to MetadataKey. If nil, only MetadataKey is reported.
+	ExtraMetadata *services.ExtraMetadata
+}
+
+// Validate returns an error if the options are not valid.
+func (o *Options) Validate() error {
+	if len(o.Locations) == 0 {
+		return fmt.Errorf("no locations provided")
+	}
+
+	for _, loc := range o.Locations {
+		if len(loc.Project) == 0 {
+			return fmt.Errorf("project not provided")
+		}
+	}
+
+	if len(o.MetadataKey) == 0 {
+		return fmt.Errorf("metadata key not provided")
+	}
+
+	switch o.MetadataPrecedence {
+	case "", PrecedenceEndpoint, PrecedenceService:
+	default:
+		return fmt.Errorf("invalid metadata precedence: %s", o.MetadataPrecedence)
+	}
+
+	return nil
+}
@@ -21,185 +21,308 @@ package sdhandler
 import (
 	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
 
-	sd "cloud.google.com/go/servicedirectory/apiv1beta1"
+	sd "cloud.google.com/go/servicedirectory/apiv1"
 	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/services"
 	"github.com/rs/zerolog/log"
-	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
-	sdpb "google.golang.org/genproto/googleapis/cloud/servicedirectory/v1beta1"
+	sdrest "google.golang.org/api/servicedirectory/v1"
+	htransport "google.golang.org/api/transport/http"
+)
+
+var (
+	// filterableKey matches metadata keys that can be safely used in a
+	// Service Directory filter.
+	filterableKey = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
 )
 
 type gcloudServDir struct {
-	metadataKey string
-	region      string
-	project     string
-	ctx         context.Context
-	cl          *sd.RegistrationClient
-	baseParent  string
+	metadataKey   string
+	extraMetadata *services.ExtraMetadata
+	precedence    string
+	ctx           context.Context
+	cl            lister
+	locations     []Location
 }
 
-// New returns a handler for gcloud service directory
-func New(ctx context.Context, region, metadataKey, project, credsPath string) (Handler, error) {
-	jsonBytes, err := ioutil.ReadFile(credsPath)
+// New returns a handler for gcloud service directory.
+func New(ctx context.Context, opts *Options) (Handler, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	clientOpts := []option.ClientOption{}
+	if len(opts.CredentialsPath) > 0 {
+		jsonBytes, err := ioutil.ReadFile(opts.CredentialsPath)
+		if err != nil {
+			return nil, err
+		}
+
+		clientOpts = append(clientOpts, option.WithCredentialsJSON(jsonBytes))
+	}
+
+	c, err := sd.NewRegistrationClient(ctx, clientOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// The HTTP client of the REST service is created here, so that its
+	// connections can be closed along with the handler.
+	hc, _, err := htransport.NewClient(ctx, append(clientOpts, option.WithScopes(sdrest.CloudPlatformScope))...)
 	if err != nil {
+		c.Close()
 		return nil, err
 	}
 
-	c, err := sd.NewRegistrationClient(ctx, option.WithCredentialsJSON(jsonBytes))
+	rest, err := sdrest.NewService(ctx, option.WithHTTPClient(hc))
 	if err != nil {
+		c.Close()
 		return nil, err
 	}
 
+	precedence := opts.MetadataPrecedence
+	if len(precedence) == 0 {
+		precedence = PrecedenceEndpoint
+	}
+
 	return &gcloudServDir{
-		region:      region,
-		project:     project,
-		metadataKey: metadataKey,
-		ctx:         ctx,
-		cl:          c,
-		baseParent:  path.Join("projects", project, "locations", region),
+		metadataKey:   opts.MetadataKey,
+		extraMetadata: opts.ExtraMetadata,
+		precedence:    precedence,
+		ctx:           ctx,
+		cl:            &registrationLister{cl: c, rest: rest, httpClient: hc},
+		locations:     opts.Locations,
 	}, nil
 }
 
+// Close closes the connections to service directory.
+func (g *gcloudServDir) Close() error {
+	if c, ok := g.cl.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
 // GetServices loads data from the service
-func (g *gcloudServDir) GetServices() map[string]*openapi.Service {
+func (g *gcloudServDir) GetServices() (map[string]*openapi.Service, error) {
 	l := log.With().Str("func", "Handler.GetServices").Logger()
-	maps := map[string]*openapi.Service{}
 
-	nsList, err := g.getNamespacesList()
+	parents, err := g.getParents()
 	if err != nil {
-		l.Error().Err(err).Msg("error while getting namespaces list")
+		return nil, err
 	}
 
-	for _, ns := range nsList {
-		l := l.With().Str("ns-name", ns.Name).Logger()
-
-		servList, err := g.getServicesList(ns.Name)
+	var (
+		lock     sync.Mutex
+		nsList   = []string{}
+		servList = []*service{}
+	)
+	err = forEach(len(parents), func(i int) error {
+		namespaces, err := g.cl.listNamespaces(g.ctx, parents[i])
 		if err != nil {
-			l.Warn().Err(err).Msg("error while getting services")
-			continue
+			return fmt.Errorf("error while getting namespaces of %s: %w", parents[i], err)
 		}
 
-		for _, serv := range servList {
-			l := l.With().Str("service-name", serv.Name).Logger()
+		lock.Lock()
+		nsList = append(nsList, namespaces...)
+		lock.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-			epList, err := g.getEndpointsList(serv.Name)
-			if err != nil {
-				l.Warn().Err(err).Msg("error while getting endpoints")
-				continue
-			}
+	err = forEach(len(nsList), func(i int) error {
+		servs, err := g.cl.listServices(g.ctx, nsList[i])
+		if err != nil {
+			return fmt.Errorf("error while getting services of namespace %s: %w", nsList[i], err)
+		}
 
-			for _, endpoint := range epList {
-				l := l.With().Str("endpoint-name", endpoint.Name).Str("endpoint-address", endpoint.Address).
-					Int32("endpoint-port", endpoint.Port).Logger()
+		lock.Lock()
+		servList = append(servList, servs...)
+		lock.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-				data := g.formatData(endpoint, serv.Metadata)
+	maps := map[string]*openapi.Service{}
+	err = forEach(len(servList), func(i int) error {
+		serv := servList[i]
+
+		// If the service doesn't have the key, only its endpoints
+		// that have it can be reported.
+		filter := ""
+		if _, exists := serv.annotations[g.metadataKey]; !exists {
+			filter = g.filter()
+		}
 
-				if data != nil {
-					l.Debug().Msg("endpoint has the required metadata key")
-					mapKey := fmt.Sprintf("%s_%d", data.Address, data.Port)
-					maps[mapKey] = data
-				}
-			}
+		epList, err := g.cl.listEndpoints(g.ctx, serv.name, filter)
+		if err != nil {
+			return fmt.Errorf("error while getting endpoints of service %s: %w", serv.name, err)
 		}
-	}
 
-	return maps
-}
+		lock.Lock()
+		defer lock.Unlock()
+		for _, endpoint := range epList {
+			data := g.formatData(serv, endpoint)
+			if data == nil {
+				continue
+			}
 
-func (g *gcloudServDir) getNamespacesList() ([]*sdpb.Namespace, error) {
-	req := &sdpb.ListNamespacesRequest{
-		Parent: g.baseParent,
-	}
-	nsList := []*sdpb.Namespace{}
+			l.Debug().Str("endpoint-name", endpoint.name).Str("endpoint-address", endpoint.address).
+				Int32("endpoint-port", endpoint.port).Msg("endpoint has the required metadata key")
+			// The name of the endpoint is unique across all projects
+			// and regions, while its address may not be.
+			maps[endpoint.name] = data
+		}
 
-	// -- Get the list
-	it := g.cl.ListNamespaces(g.ctx, req)
-	if it == nil {
-		return nsList, nil
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	for {
-		resp, err := it.Next()
-		if err == iterator.Done {
-			break
+	return maps, nil
+}
+
+// getParents returns the resource names of all the locations to scan, i.e.
+// projects/my-project/locations/us-west2, listing the regions of the
+// projects that need to be scanned entirely.
+func (g *gcloudServDir) getParents() ([]string, error) {
+	var (
+		lock    sync.Mutex
+		parents = []string{}
+	)
+
+	err := forEach(len(g.locations), func(i int) error {
+		loc := g.locations[i]
+		regions := []string{loc.Region}
+		if len(loc.Region) == 0 {
+			_regions, err := g.cl.listLocations(g.ctx, loc.Project)
+			if err != nil {
+				return fmt.Errorf("error while getting locations of project %s: %w", loc.Project, err)
+			}
+			regions = _regions
 		}
-		if err != nil {
-			return nil, err
+
+		lock.Lock()
+		defer lock.Unlock()
+		for _, region := range regions {
+			parents = append(parents, path.Join("projects", loc.Project, "locations", region))
 		}
 
-		nsList = append(nsList, resp)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return nsList, nil
+	sort.Strings(parents)
+	return parents, nil
 }
 
-func (g *gcloudServDir) getServicesList(nsName string) ([]*sdpb.Service, error) {
-	req := &sdpb.ListServicesRequest{
-		Parent: nsName,
-	}
-	servList := []*sdpb.Service{}
-
-	// -- Get the list
-	it := g.cl.ListServices(g.ctx, req)
-	if it == nil {
-		return servList, nil
+// forEach calls f for all indexes from 0 to n-1, with at most
+// maxConcurrentRequests calls running at the same time, and returns the
+// first error returned by f, if any.
+func forEach(n int, f func(i int) error) error {
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+		sem      = make(chan struct{}, maxConcurrentRequests)
+	)
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			if err := f(i); err != nil {
+				once.Do(func() { firstErr = err })
+			}
+		}(i)
 	}
 
-	for {
-		resp, err := it.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			return nil, err
-		}
+	wg.Wait()
+	return firstErr
+}
 
-		servList = append(servList, resp)
+// filter returns the filter to use when listing endpoints, so that only the
+// ones with the metadata key are returned. An empty string is returned if
+// the key cannot be used in a filter: in that case, endpoints are filtered
+// here instead.
+func (g *gcloudServDir) filter() string {
+	if !filterableKey.MatchString(g.metadataKey) {
+		return ""
 	}
 
-	return servList, nil
+	return fmt.Sprintf("annotations.%s", g.metadataKey)
 }
 
-func (g *gcloudServDir) getEndpointsList(serv string) ([]*sdpb.Endpoint, error) {
-	req := &sdpb.ListEndpointsRequest{
-		Parent: serv,
+// mergeAnnotations returns the annotations of the endpoint merged with the
+// ones of its service, according to the precedence.
+func (g *gcloudServDir) mergeAnnotations(serv *service, endpoint *endpoint) map[string]string {
+	first, second := serv.annotations, endpoint.annotations
+	if g.precedence == PrecedenceService {
+		first, second = second, first
 	}
-	endpointsList := []*sdpb.Endpoint{}
 
-	// -- Get the list
-	it := g.cl.ListEndpoints(g.ctx, req)
-	for {
-		resp, err := it.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			return nil, err
-		}
-
-		endpointsList = append(endpointsList, resp)
+	merged := map[string]string{}
+	for key, val := range first {
+		merged[key] = val
+	}
+	for key, val := range second {
+		merged[key] = val
 	}
 
-	return endpointsList, nil
+	return merged
 }
 
-func (g *gcloudServDir) formatData(endpoint *sdpb.Endpoint, serviceMetadata map[string]string) *openapi.Service {
-	metadataValue, exists := serviceMetadata[g.metadataKey]
-	if !exists {
+func (g *gcloudServDir) formatData(serv *service, endpoint *endpoint) *openapi.Service {
+	annotations := g.mergeAnnotations(serv, endpoint)
+	if _, exists := annotations[g.metadataKey]; !exists {
 		return nil
 	}
 
-	if len(endpoint.Address) == 0 {
+	if len(endpoint.address) == 0 {
 		return nil
 	}
 
 	return &openapi.Service{
-		Address:  endpoint.Address,
-		Name:     endpoint.Name,
-		Metadata: []openapi.Metadata{{Key: g.metadataKey, Value: metadataValue}},
-		Port:     endpoint.Port,
+		Address:     endpoint.address,
+		Name:        endpoint.name,
+		Metadata:    g.extraMetadata.Select(annotations, []string{g.metadataKey}),
+		Port:        endpoint.port,
+		Namespace:   resourceID(endpoint.name, "namespaces"),
+		ServiceName: resourceID(endpoint.name, "services"),
 	}
 }
+
+// resourceID returns the ID that follows kind in the provided resource name,
+// i.e. "my-ns" for kind "namespaces" in
+// "projects/my-project/locations/us-west2/namespaces/my-ns".
+func resourceID(name, kind string) string {
+	parts := strings.Split(name, "/")
+	for i := 0; i < len(parts)-1; i++ {
+		if parts[i] == kind {
+			return parts[i+1]
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,436 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package sdhandler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/services"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeLister struct {
+	_listLocations  func(project string) ([]string, error)
+	_listNamespaces func(parent string) ([]string, error)
+	_listServices   func(namespace string) ([]*service, error)
+	_listEndpoints  func(service, filter string) ([]*endpoint, error)
+	closed          bool
+}
+
+func (f *fakeLister) Close() error {
+	f.closed = true
+	return nil
+}
+
+func (f *fakeLister) listLocations(ctx context.Context, project string) ([]string, error) {
+	return f._listLocations(project)
+}
+
+func (f *fakeLister) listNamespaces(ctx context.Context, parent string) ([]string, error) {
+	return f._listNamespaces(parent)
+}
+
+func (f *fakeLister) listServices(ctx context.Context, namespace string) ([]*service, error) {
+	return f._listServices(namespace)
+}
+
+func (f *fakeLister) listEndpoints(ctx context.Context, service, filter string) ([]*endpoint, error) {
+	return f._listEndpoints(service, filter)
+}
+
+func TestClose(t *testing.T) {
+	a := assert.New(t)
+	cl := &fakeLister{}
+	g := &gcloudServDir{cl: cl}
+
+	a.NoError(g.Close())
+	a.True(cl.closed)
+}
+
+func TestGetServices(t *testing.T) {
+	a := assert.New(t)
+	parent := "projects/project/locations/region"
+	namespaces := func(parent string) ([]string, error) {
+		return []string{parent + "/namespaces/one", parent + "/namespaces/two"}, nil
+	}
+	listServices := func(namespace string) ([]*service, error) {
+		return []*service{
+			{name: namespace + "/services/with", annotations: map[string]string{"profile": "video"}},
+			{name: namespace + "/services/without", annotations: map[string]string{"another": "value"}},
+		}, nil
+	}
+	endpoints := func(service, filter string) ([]*endpoint, error) {
+		switch service {
+		case parent + "/namespaces/one/services/with":
+			if filter != "" {
+				return nil, fmt.Errorf("all endpoints should be listed")
+			}
+			return []*endpoint{
+				{name: service + "/endpoints/ep", address: "10.10.10.10", port: 80},
+				{name: service + "/endpoints/no-address", port: 80},
+			}, nil
+		case parent + "/namespaces/one/services/without":
+			if filter != "annotations.profile" {
+				return nil, fmt.Errorf("wrong filter: %s", filter)
+			}
+			return []*endpoint{
+				{name: service + "/endpoints/ep", address: "10.10.10.12", port: 80, annotations: map[string]string{"profile": "voip"}},
+			}, nil
+		case parent + "/namespaces/two/services/with":
+			return []*endpoint{
+				{name: service + "/endpoints/ep", address: "10.10.10.11", port: 8080, annotations: map[string]string{"profile": "gaming"}},
+			}, nil
+		}
+
+		return []*endpoint{}, nil
+	}
+	cases := []struct {
+		cl         *fakeLister
+		precedence string
+		extra      *services.ExtraMetadata
+		expRes     map[string]*openapi.Service
+		expErr     error
+	}{
+		{
+			cl: &fakeLister{
+				_listNamespaces: func(string) ([]string, error) {
+					return nil, fmt.Errorf("error")
+				},
+			},
+			expErr: fmt.Errorf("error while getting namespaces of %s: %w", parent, fmt.Errorf("error")),
+		},
+		{
+			cl: &fakeLister{
+				_listNamespaces: namespaces,
+				_listServices: func(namespace string) ([]*service, error) {
+					if namespace == parent+"/namespaces/two" {
+						return nil, fmt.Errorf("error")
+					}
+					return listServices(namespace)
+				},
+				_listEndpoints: endpoints,
+			},
+			expErr: fmt.Errorf("error while getting services of namespace %s/namespaces/two: %w", parent, fmt.Errorf("error")),
+		},
+		{
+			cl: &fakeLister{
+				_listNamespaces: namespaces,
+				_listServices:   listServices,
+				_listEndpoints: func(service, filter string) ([]*endpoint, error) {
+					if service == parent+"/namespaces/two/services/with" {
+						return nil, fmt.Errorf("error")
+					}
+					return endpoints(service, filter)
+				},
+			},
+			expErr: fmt.Errorf("error while getting endpoints of service %s/namespaces/two/services/with: %w", parent, fmt.Errorf("error")),
+		},
+		{
+			cl: &fakeLister{
+				_listNamespaces: namespaces,
+				_listServices:   listServices,
+				_listEndpoints:  endpoints,
+			},
+			precedence: PrecedenceEndpoint,
+			expRes: map[string]*openapi.Service{
+				parent + "/namespaces/one/services/with/endpoints/ep": {
+					Name:        parent + "/namespaces/one/services/with/endpoints/ep",
+					Address:     "10.10.10.10",
+					Port:        80,
+					Metadata:    []openapi.Metadata{{Key: "profile", Value: "video"}},
+					Namespace:   "one",
+					ServiceName: "with",
+				},
+				parent + "/namespaces/one/services/without/endpoints/ep": {
+					Name:        parent + "/namespaces/one/services/without/endpoints/ep",
+					Address:     "10.10.10.12",
+					Port:        80,
+					Metadata:    []openapi.Metadata{{Key: "profile", Value: "voip"}},
+					Namespace:   "one",
+					ServiceName: "without",
+				},
+				parent + "/namespaces/two/services/with/endpoints/ep": {
+					Name:        parent + "/namespaces/two/services/with/endpoints/ep",
+					Address:     "10.10.10.11",
+					Port:        8080,
+					Metadata:    []openapi.Metadata{{Key: "profile", Value: "gaming"}},
+					Namespace:   "two",
+					ServiceName: "with",
+				},
+			},
+		},
+		{
+			cl: &fakeLister{
+				_listNamespaces: namespaces,
+				_listServices:   listServices,
+				_listEndpoints:  endpoints,
+			},
+			precedence: PrecedenceService,
+			expRes: map[string]*openapi.Service{
+				parent + "/namespaces/one/services/with/endpoints/ep": {
+					Name:        parent + "/namespaces/one/services/with/endpoints/ep",
+					Address:     "10.10.10.10",
+					Port:        80,
+					Metadata:    []openapi.Metadata{{Key: "profile", Value: "video"}},
+					Namespace:   "one",
+					ServiceName: "with",
+				},
+				parent + "/namespaces/one/services/without/endpoints/ep": {
+					Name:        parent + "/namespaces/one/services/without/endpoints/ep",
+					Address:     "10.10.10.12",
+					Port:        80,
+					Metadata:    []openapi.Metadata{{Key: "profile", Value: "voip"}},
+					Namespace:   "one",
+					ServiceName: "without",
+				},
+				parent + "/namespaces/two/services/with/endpoints/ep": {
+					Name:        parent + "/namespaces/two/services/with/endpoints/ep",
+					Address:     "10.10.10.11",
+					Port:        8080,
+					Metadata:    []openapi.Metadata{{Key: "profile", Value: "video"}},
+					Namespace:   "two",
+					ServiceName: "with",
+				},
+			},
+		},
+		{
+			cl: &fakeLister{
+				_listNamespaces: namespaces,
+				_listServices:   listServices,
+				_listEndpoints:  endpoints,
+			},
+			extra: &services.ExtraMetadata{All: true},
+			expRes: map[string]*openapi.Service{
+				parent + "/namespaces/one/services/with/endpoints/ep": {
+					Name:        parent + "/namespaces/one/services/with/endpoints/ep",
+					Address:     "10.10.10.10",
+					Port:        80,
+					Metadata:    []openapi.Metadata{{Key: "profile", Value: "video"}},
+					Namespace:   "one",
+					ServiceName: "with",
+				},
+				parent + "/namespaces/one/services/without/endpoints/ep": {
+					Name:        parent + "/namespaces/one/services/without/endpoints/ep",
+					Address:     "10.10.10.12",
+					Port:        80,
+					Metadata:    []openapi.Metadata{{Key: "profile", Value: "voip"}, {Key: "another", Value: "value"}},
+					Namespace:   "one",
+					ServiceName: "without",
+				},
+				parent + "/namespaces/two/services/with/endpoints/ep": {
+					Name:        parent + "/namespaces/two/services/with/endpoints/ep",
+					Address:     "10.10.10.11",
+					Port:        8080,
+					Metadata:    []openapi.Metadata{{Key: "profile", Value: "gaming"}},
+					Namespace:   "two",
+					ServiceName: "with",
+				},
+			},
+		},
+	}
+
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+	for i, currCase := range cases {
+		g := &gcloudServDir{
+			metadataKey:   "profile",
+			extraMetadata: currCase.extra,
+			precedence:    currCase.precedence,
+			ctx:           context.Background(),
+			cl:            currCase.cl,
+			locations:     []Location{{Project: "project", Region: "region"}},
+		}
+		res, err := g.GetServices()
+		if !a.Equal(currCase.expRes, res) || !a.Equal(currCase.expErr, err) {
+			failed(i)
+		}
+	}
+}
+
+func TestGetServicesWithLocations(t *testing.T) {
+	a := assert.New(t)
+	g := &gcloudServDir{
+		metadataKey: "profile",
+		precedence:  PrecedenceEndpoint,
+		ctx:         context.Background(),
+		locations:   []Location{{Project: "one", Region: "us-west2"}, {Project: "two"}},
+		cl: &fakeLister{
+			_listLocations: func(project string) ([]string, error) {
+				if project != "two" {
+					return nil, fmt.Errorf("locations of %s should not be listed", project)
+				}
+				return []string{"us-west2", "europe-west1"}, nil
+			},
+			_listNamespaces: func(parent string) ([]string, error) {
+				return []string{parent + "/namespaces/ns"}, nil
+			},
+			_listServices: func(namespace string) ([]*service, error) {
+				return []*service{{name: namespace + "/services/serv", annotations: map[string]string{"profile": "video"}}}, nil
+			},
+			_listEndpoints: func(service, filter string) ([]*endpoint, error) {
+				// Same address everywhere
+				return []*endpoint{{name: service + "/endpoints/ep", address: "10.10.10.10", port: 80}}, nil
+			},
+		},
+	}
+
+	res, err := g.GetServices()
+	a.NoError(err)
+	a.Len(res, 3)
+	for _, parent := range []string{
+		"projects/one/locations/us-west2",
+		"projects/two/locations/us-west2",
+		"projects/two/locations/europe-west1",
+	} {
+		name := parent + "/namespaces/ns/services/serv/endpoints/ep"
+		a.Equal(&openapi.Service{
+			Name:        name,
+			Address:     "10.10.10.10",
+			Port:        80,
+			Metadata:    []openapi.Metadata{{Key: "profile", Value: "video"}},
+			Namespace:   "ns",
+			ServiceName: "serv",
+		}, res[name])
+	}
+
+	g.cl.(*fakeLister)._listLocations = func(string) ([]string, error) {
+		return nil, fmt.Errorf("error")
+	}
+	res, err = g.GetServices()
+	a.Nil(res)
+	a.Equal(fmt.Errorf("error while getting locations of project two: %w", fmt.Errorf("error")), err)
+}
+
+func TestParseLocation(t *testing.T) {
+	a := assert.New(t)
+	cases := []struct {
+		loc    string
+		expRes Location
+		expErr error
+	}{
+		{loc: "my-project/us-west2", expRes: Location{Project: "my-project", Region: "us-west2"}},
+		{loc: "my-project", expRes: Location{Project: "my-project"}},
+		{loc: "", expErr: fmt.Errorf("invalid location: ")},
+		{loc: "/us-west2", expErr: fmt.Errorf("invalid location: /us-west2")},
+		{loc: "my-project/", expErr: fmt.Errorf("invalid location: my-project/")},
+		{loc: "my-project/us-west2/other", expErr: fmt.Errorf("invalid location: my-project/us-west2/other")},
+	}
+
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+	for i, currCase := range cases {
+		res, err := ParseLocation(currCase.loc)
+		if !a.Equal(currCase.expRes, res) || !a.Equal(currCase.expErr, err) {
+			failed(i)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	a := assert.New(t)
+	cases := []struct {
+		key    string
+		expRes string
+	}{
+		{key: "traffic-profile", expRes: "annotations.traffic-profile"},
+		{key: "traffic_profile2", expRes: "annotations.traffic_profile2"},
+		{key: "cnwan.io/traffic-profile"},
+		{key: "profile OR name"},
+	}
+
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+	for i, currCase := range cases {
+		g := &gcloudServDir{metadataKey: currCase.key}
+		if !a.Equal(currCase.expRes, g.filter()) {
+			failed(i)
+		}
+	}
+}
+
+func TestResourceID(t *testing.T) {
+	a := assert.New(t)
+	name := "projects/my-project/locations/us-west2/namespaces/my-ns/services/my-serv/endpoints/my-ep"
+	cases := []struct {
+		name   string
+		kind   string
+		expRes string
+	}{
+		{name: name, kind: "namespaces", expRes: "my-ns"},
+		{name: name, kind: "services", expRes: "my-serv"},
+		{name: name, kind: "endpoints", expRes: "my-ep"},
+		{name: name, kind: "my-ep"},
+		{name: "projects/my-project/locations/us-west2", kind: "namespaces"},
+	}
+
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+	for i, currCase := range cases {
+		if !a.Equal(currCase.expRes, resourceID(currCase.name, currCase.kind)) {
+			failed(i)
+		}
+	}
+}
+
+func TestValidateOptions(t *testing.T) {
+	a := assert.New(t)
+	cases := []struct {
+		opts   *Options
+		expErr error
+	}{
+		{
+			opts:   &Options{},
+			expErr: fmt.Errorf("no locations provided"),
+		},
+		{
+			opts:   &Options{Locations: []Location{{Project: "project"}, {Region: "region"}}},
+			expErr: fmt.Errorf("project not provided"),
+		},
+		{
+			opts:   &Options{Locations: []Location{{Project: "project", Region: "region"}}},
+			expErr: fmt.Errorf("metadata key not provided"),
+		},
+		{
+			opts:   &Options{Locations: []Location{{Project: "project", Region: "region"}}, MetadataKey: "key", MetadataPrecedence: "invalid"},
+			expErr: fmt.Errorf("invalid metadata precedence: invalid"),
+		},
+		{
+			opts: &Options{Locations: []Location{{Project: "project", Region: "region"}}, MetadataKey: "key"},
+		},
+		{
+			opts: &Options{Locations: []Location{{Project: "project"}}, MetadataKey: "key", MetadataPrecedence: PrecedenceService},
+		},
+	}
+
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+	for i, currCase := range cases {
+		if !a.Equal(currCase.expErr, currCase.opts.Validate()) {
+			failed(i)
+		}
+	}
+}
@@ -31,11 +31,20 @@ type Datastore interface {
 	// them and their previous state (the one already existing in memory).
 	// It returns the differences in form of events.
 	GetEvents(services map[string]*openapi.Service) map[string]*openapi.Event
+	// SetTrackedKeys sets the only metadata keys whose changes are
+	// reported, along with changes to the other fields of services.
+	// Services whose other metadata changed are stored anyway, so that
+	// their next events include them. If nil, changes to all metadata are
+	// reported.
+	SetTrackedKeys(keys []string)
 }
 
 type servicesDatastore struct {
 	lock     sync.Mutex
 	services map[string]*openapi.Service
+	// trackedKeys are the only metadata keys whose changes are reported.
+	// If nil, changes to all metadata are reported.
+	trackedKeys []string
 }
 
 // NewDatastore returns a new services datastore
@@ -45,6 +54,15 @@ func NewDatastore() Datastore {
 	}
 }
 
+// SetTrackedKeys sets the only metadata keys whose changes are reported,
+// or all of them if nil.
+func (m *servicesDatastore) SetTrackedKeys(keys []string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.trackedKeys = keys
+}
+
 // GetEvents receives the current services and runs a difference between
 // them and their previous state (the one already existing in memory).
 // It returns the differences in form of events.
@@ -56,7 +74,7 @@ func (m *servicesDatastore) GetEvents(currServices map[string]*openapi.Service)
 	// Run difference
 	//----------------------------------
 
-	changes := getChanges(m.services, currServices)
+	changes := getChanges(m.services, currServices, m.trackedKeys)
 
 	//----------------------------------
 	// Update the services
@@ -71,10 +89,19 @@ func (m *servicesDatastore) GetEvents(currServices map[string]*openapi.Service)
 		}
 	}
 
+	if m.trackedKeys != nil {
+		// Changes to other metadata are not reported, but stored anyway
+		for key, serv := range currServices {
+			if _, changed := changes[key]; !changed {
+				m.services[key] = serv
+			}
+		}
+	}
+
 	return changes
 }
 
-func getChanges(storedState, currentState map[string]*openapi.Service) map[string]*openapi.Event {
+func getChanges(storedState, currentState map[string]*openapi.Service, trackedKeys []string) map[string]*openapi.Event {
 	changes := map[string]*openapi.Event{}
 
 	// Run the difference
@@ -90,7 +117,7 @@ func getChanges(storedState, currentState map[string]*openapi.Service) map[strin
 			continue
 		}
 
-		if !reflect.DeepEqual(storedVal, currVal) {
+		if !equalServices(storedVal, currVal, trackedKeys) {
 			// This is changed
 			changes[currKey] = &openapi.Event{
 				Event:   "update",
@@ -112,3 +139,57 @@ func getChanges(storedState, currentState map[string]*openapi.Service) map[strin
 
 	return changes
 }
+
+// equalServices returns true if the two services are the same, only
+// considering the provided metadata keys, or all metadata if nil.
+func equalServices(one, two *openapi.Service, trackedKeys []string) bool {
+	if trackedKeys == nil {
+		return reflect.DeepEqual(one, two)
+	}
+
+	_one, _two := *one, *two
+	_one.Metadata, _two.Metadata = nil, nil
+	if !reflect.DeepEqual(_one, _two) {
+		return false
+	}
+
+	oneMetadata, twoMetadata := map[string]string{}, map[string]string{}
+	for _, md := range one.Metadata {
+		oneMetadata[md.Key] = md.Value
+	}
+	for _, md := range two.Metadata {
+		twoMetadata[md.Key] = md.Value
+	}
+
+	for _, key := range trackedKeys {
+		oneVal, oneExists := oneMetadata[key]
+		twoVal, twoExists := twoMetadata[key]
+		if oneExists != twoExists || oneVal != twoVal {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ResyncEvents returns the events needed to send the whole current state to
+// an adaptor that may have missed some events, i.e. a create event for each
+// of the current services and the delete events included in changes.
+func ResyncEvents(currServices map[string]*openapi.Service, changes map[string]*openapi.Event) map[string]*openapi.Event {
+	events := map[string]*openapi.Event{}
+
+	for key, change := range changes {
+		if change.Event == "delete" {
+			events[key] = change
+		}
+	}
+
+	for key, serv := range currServices {
+		events[key] = &openapi.Event{
+			Event:   "create",
+			Service: *serv,
+		}
+	}
+
+	return events
+}
@@ -68,7 +68,7 @@ func TestGetChanges(t *testing.T) {
 	}
 
 	// Case 1: nothing is changed
-	res := getChanges(stored, pulled)
+	res := getChanges(stored, pulled, nil)
 	Empty(t, res)
 
 	// Case 2: something is new
@@ -104,7 +104,7 @@ func TestGetChanges(t *testing.T) {
 			},
 		},
 	}
-	res = getChanges(stored, pulled)
+	res = getChanges(stored, pulled, nil)
 	Equal(t, expectedRes, res)
 
 	// Case 3: something is changed
@@ -134,6 +134,50 @@ func TestGetChanges(t *testing.T) {
 			},
 		},
 	}
-	res = getChanges(stored, pulled)
+	res = getChanges(stored, pulled, nil)
 	Equal(t, expectedRes, res)
 }
+
+func TestResyncEvents(t *testing.T) {
+	curr := map[string]*openapi.Service{
+		"first":  {Name: "first-name", Address: "10.10.10.10", Port: 80},
+		"second": {Name: "second-name", Address: "11.11.11.11", Port: 8080},
+	}
+	changes := map[string]*openapi.Event{
+		"second": {Event: "update", Service: *curr["second"]},
+		"third":  {Event: "delete", Service: openapi.Service{Name: "third-name"}},
+	}
+
+	expectedRes := map[string]*openapi.Event{
+		"first":  {Event: "create", Service: *curr["first"]},
+		"second": {Event: "create", Service: *curr["second"]},
+		"third":  {Event: "delete", Service: openapi.Service{Name: "third-name"}},
+	}
+	Equal(t, expectedRes, ResyncEvents(curr, changes))
+}
+
+func TestSetTrackedKeys(t *testing.T) {
+	serv := func(profile, site string) map[string]*openapi.Service {
+		return map[string]*openapi.Service{
+			"first": {
+				Name:     "first-name",
+				Address:  "10.10.10.10",
+				Port:     80,
+				Metadata: []openapi.Metadata{{Key: "profile", Value: profile}, {Key: "site", Value: site}},
+			},
+		}
+	}
+	datastore := NewDatastore()
+	datastore.SetTrackedKeys([]string{"profile"})
+
+	res := datastore.GetEvents(serv("video", "eu-west"))
+	Equal(t, map[string]*openapi.Event{"first": {Event: "create", Service: *serv("video", "eu-west")["first"]}}, res)
+
+	// Changes to other metadata are not reported, but stored anyway
+	Empty(t, datastore.GetEvents(serv("video", "us-east")))
+	res = datastore.GetEvents(serv("voip", "us-east"))
+	Equal(t, map[string]*openapi.Event{"first": {Event: "update", Service: *serv("voip", "us-east")["first"]}}, res)
+
+	res = datastore.GetEvents(map[string]*openapi.Service{})
+	Equal(t, map[string]*openapi.Event{"first": {Event: "delete", Service: *serv("voip", "us-east")["first"]}}, res)
+}
@@ -0,0 +1,119 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package services
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
+)
+
+const (
+	// EventsV1 is the legacy format of the events, where only the event
+	// type and the service are sent to the adaptor.
+	EventsV1 string = "v1"
+	// EventsV2 is the format of the events where each event is wrapped
+	// in an envelope containing its ID, sequence number, detection time,
+	// source and revision.
+	EventsV2 string = "v2"
+	// DefaultEventsVersion is the events version used when none is
+	// provided.
+	DefaultEventsVersion string = EventsV2
+
+	// apiVersionHeader is the header that is sent along with v2 events.
+	apiVersionHeader string = "X-CNWAN-API-Version"
+)
+
+var (
+	// sequence is the last sequence number that was assigned to an event
+	// by this instance of the CN-WAN Reader.
+	sequence int64
+)
+
+// IsValidEventsVersion returns true if the provided version is one of the
+// supported events versions.
+func IsValidEventsVersion(version string) bool {
+	return version == EventsV1 || version == EventsV2
+}
+
+// StampEvents fills the envelope of the provided events, i.e. their ID,
+// sequence number, detection time and source.
+//
+// Events are stamped in the order of their keys, so that events detected
+// at the same time will always have the same relative order. The revision
+// is not touched, as it is up to the caller to set it if the service
+// registry supports it.
+func StampEvents(events map[string]*openapi.Event, source string) {
+	keys := make([]string, 0, len(events))
+	for key := range events {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	now := time.Now().UTC()
+	for _, key := range keys {
+		ev := events[key]
+		if ev == nil {
+			continue
+		}
+
+		ev.Id = newEventID()
+		ev.Sequence = atomic.AddInt64(&sequence, 1)
+		ev.Timestamp = &now
+		ev.Source = source
+	}
+}
+
+// toLegacyEvents returns a copy of the provided events without their
+// envelope, as expected by adaptors that only support v1 events.
+func toLegacyEvents(events []openapi.Event) []openapi.Event {
+	legacy := make([]openapi.Event, len(events))
+	for i, ev := range events {
+		legacy[i] = openapi.Event{
+			Event: ev.Event,
+			Service: openapi.Service{
+				Name:     ev.Service.Name,
+				Address:  ev.Service.Address,
+				Port:     ev.Service.Port,
+				Metadata: ev.Service.Metadata,
+			},
+		}
+	}
+
+	return legacy
+}
+
+// newEventID returns a random, version 4 UUID.
+func newEventID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// This is extremely unlikely to happen. In such case we fallback
+		// to a value that is still unique for this instance.
+		return fmt.Sprintf("%d-%d", time.Now().UnixNano(), atomic.LoadInt64(&sequence))
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,113 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package services
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStampEvents(t *testing.T) {
+	a := assert.New(t)
+	uuidRegex := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	events := map[string]*openapi.Event{
+		"b": {Event: "update", Revision: 10},
+		"a": {Event: "create"},
+		"c": {Event: "delete"},
+	}
+
+	StampEvents(events, "etcd")
+
+	ids := map[string]bool{}
+	for key, ev := range events {
+		a.Regexp(uuidRegex, ev.Id, key)
+		a.Equal("etcd", ev.Source, key)
+		a.NotNil(ev.Timestamp, key)
+		ids[ev.Id] = true
+	}
+	a.Len(ids, len(events))
+
+	// Sequence follows the order of the keys
+	a.Less(events["a"].Sequence, events["b"].Sequence)
+	a.Less(events["b"].Sequence, events["c"].Sequence)
+
+	// Revision is left untouched
+	a.Equal(int64(10), events["b"].Revision)
+	a.Zero(events["a"].Revision)
+
+	// Sequence keeps increasing across calls
+	last := events["c"].Sequence
+	next := map[string]*openapi.Event{"a": {Event: "delete"}}
+	StampEvents(next, "etcd")
+	a.Greater(next["a"].Sequence, last)
+}
+
+func TestToLegacyEvents(t *testing.T) {
+	a := assert.New(t)
+	now := time.Now()
+	serv := openapi.Service{
+		Name:     "name",
+		Address:  "10.10.10.10",
+		Port:     80,
+		Metadata: []openapi.Metadata{{Key: "key", Value: "val"}},
+	}
+	events := []openapi.Event{
+		{
+			Id:        "id",
+			Sequence:  1,
+			Timestamp: &now,
+			Source:    "etcd",
+			Revision:  2,
+			Event:     "create",
+			Service:   serv,
+		},
+		{
+			Event: "update",
+			Service: openapi.Service{
+				Name:        serv.Name,
+				Address:     serv.Address,
+				Port:        serv.Port,
+				Metadata:    serv.Metadata,
+				Namespace:   "namespace",
+				ServiceName: "service",
+			},
+		},
+	}
+
+	res := toLegacyEvents(events)
+	a.Equal([]openapi.Event{{Event: "create", Service: serv}, {Event: "update", Service: serv}}, res)
+
+	// Original events must not be modified
+	a.Equal("id", events[0].Id)
+	a.Equal("namespace", events[1].Service.Namespace)
+}
+
+func TestIsValidEventsVersion(t *testing.T) {
+	a := assert.New(t)
+
+	a.True(IsValidEventsVersion(EventsV1))
+	a.True(IsValidEventsVersion(EventsV2))
+	a.False(IsValidEventsVersion(""))
+	a.False(IsValidEventsVersion("v3"))
+}
@@ -38,27 +38,42 @@ type Handler interface {
 }
 
 type servicesHandler struct {
-	mainCtx context.Context
-	client  *openapi.APIClient
+	mainCtx       context.Context
+	client        *openapi.APIClient
+	eventsVersion string
 }
 
 // NewHandler returns a services handler that uses the endpoints defined in
 // the openAPI specification to send service events.
-func NewHandler(ctx context.Context, endpoint string) (Handler, error) {
+//
+// eventsVersion is the format of the events that will be sent, i.e.
+// EventsV1 or EventsV2. If empty, DefaultEventsVersion will be used.
+func NewHandler(ctx context.Context, endpoint, eventsVersion string) (Handler, error) {
 	if len(endpoint) == 0 {
 		return nil, errors.New("endpoint is empty")
 	}
 
+	if len(eventsVersion) == 0 {
+		eventsVersion = DefaultEventsVersion
+	}
+	if !IsValidEventsVersion(eventsVersion) {
+		return nil, fmt.Errorf("unsupported events version: %s", eventsVersion)
+	}
+
 	// Get the client
 	cfg := openapi.NewConfiguration()
+	if eventsVersion == EventsV2 {
+		cfg.AddDefaultHeader(apiVersionHeader, "2")
+	}
 	apiClient := openapi.NewAPIClient(cfg)
 	if endpoint != "localhost/cnwan" {
 		apiClient.ChangeBasePath(strings.Replace(cfg.BasePath, "localhost/cnwan", endpoint, 1))
 	}
 
 	return &servicesHandler{
-		client:  apiClient,
-		mainCtx: ctx,
+		client:        apiClient,
+		mainCtx:       ctx,
+		eventsVersion: eventsVersion,
 	}, nil
 }
 
@@ -69,6 +84,10 @@ func (s *servicesHandler) Send(events []openapi.Event) error {
 	ctx, canc := context.WithTimeout(s.mainCtx, timeOut)
 	defer canc()
 
+	if s.eventsVersion == EventsV1 {
+		events = toLegacyEvents(events)
+	}
+
 	l.Debug().Msg("sending events....")
 	resp, httpResp, err := s.client.EventsApi.SendEvents(ctx, events)
 	if ctx.Err() == context.DeadlineExceeded {
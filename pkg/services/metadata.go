@@ -0,0 +1,119 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package services
+
+import (
+	"sort"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
+)
+
+// ExtraMetadata contains which metadata of services are included in
+// their events in addition to the target metadata keys. A nil
+// ExtraMetadata includes only the target metadata keys.
+type ExtraMetadata struct {
+	// All includes all the metadata of services.
+	All bool
+	// Include are other keys to include, if services have them.
+	Include []string
+	// Exclude are keys not to include even if All is true or they are
+	// in Include. Target metadata keys are always included.
+	Exclude []string
+}
+
+// Includes returns true if the provided key, which is not among the
+// target ones, must be included.
+func (e *ExtraMetadata) Includes(key string) bool {
+	if e == nil {
+		return false
+	}
+
+	for _, excluded := range e.Exclude {
+		if key == excluded {
+			return false
+		}
+	}
+
+	if e.All {
+		return true
+	}
+
+	for _, included := range e.Include {
+		if key == included {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Select returns the metadata to include in events among the provided
+// ones. keys are the target metadata keys, which come first in the same
+// order, while the other metadata are sorted by key.
+func (e *ExtraMetadata) Select(metadata map[string]string, keys []string) []openapi.Metadata {
+	result := []openapi.Metadata{}
+	for _, key := range keys {
+		if val, exists := metadata[key]; exists {
+			result = append(result, openapi.Metadata{Key: key, Value: val})
+		}
+	}
+
+	others := []string{}
+	for key := range metadata {
+		if !contains(keys, key) && e.Includes(key) {
+			others = append(others, key)
+		}
+	}
+	sort.Strings(others)
+
+	for _, key := range others {
+		result = append(result, openapi.Metadata{Key: key, Value: metadata[key]})
+	}
+
+	return result
+}
+
+// TrackedKeys returns the keys whose changes must be reported, among the
+// metadata included in events: these are the target metadata keys and the
+// ones explicitly included, while changes to the keys that are included
+// only because of All are not reported.
+func (e *ExtraMetadata) TrackedKeys(keys []string) []string {
+	tracked := append([]string{}, keys...)
+	if e == nil {
+		return tracked
+	}
+
+	for _, key := range e.Include {
+		if !contains(tracked, key) && e.Includes(key) {
+			tracked = append(tracked, key)
+		}
+	}
+
+	return tracked
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
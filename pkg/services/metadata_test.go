@@ -0,0 +1,69 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package services
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelect(t *testing.T) {
+	a := assert.New(t)
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+	metadata := map[string]string{"profile": "video", "site": "eu-west", "env": "prod", "owner": "team"}
+
+	cases := []struct {
+		extra      *ExtraMetadata
+		expRes     []openapi.Metadata
+		expTracked []string
+	}{
+		{
+			expRes:     []openapi.Metadata{{Key: "profile", Value: "video"}},
+			expTracked: []string{"profile"},
+		},
+		{
+			extra:      &ExtraMetadata{Include: []string{"site", "region"}},
+			expRes:     []openapi.Metadata{{Key: "profile", Value: "video"}, {Key: "site", Value: "eu-west"}},
+			expTracked: []string{"profile", "site", "region"},
+		},
+		{
+			extra:      &ExtraMetadata{All: true, Exclude: []string{"owner", "profile"}},
+			expRes:     []openapi.Metadata{{Key: "profile", Value: "video"}, {Key: "env", Value: "prod"}, {Key: "site", Value: "eu-west"}},
+			expTracked: []string{"profile"},
+		},
+		{
+			extra:      &ExtraMetadata{All: true, Include: []string{"site", "env", "profile"}, Exclude: []string{"env"}},
+			expRes:     []openapi.Metadata{{Key: "profile", Value: "video"}, {Key: "owner", Value: "team"}, {Key: "site", Value: "eu-west"}},
+			expTracked: []string{"profile", "site"},
+		},
+	}
+
+	for i, currCase := range cases {
+		res := currCase.extra.Select(metadata, []string{"profile"})
+		tracked := currCase.extra.TrackedKeys([]string{"profile"})
+		if !a.Equal(currCase.expRes, res) || !a.Equal(currCase.expTracked, tracked) {
+			failed(i)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package shutdown
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/queue"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// ExitOK is the exit code used when all events have been sent.
+	ExitOK int = 0
+	// ExitSourceFailed is the exit code used when all events have been
+	// sent but a source of events stopped because of an error.
+	ExitSourceFailed int = 1
+	// ExitEventsPersisted is the exit code used when some events could
+	// not be sent but have been saved to be sent on next run.
+	ExitEventsPersisted int = 3
+	// ExitEventsLost is the exit code used when some events could not be
+	// sent and could not be saved either.
+	ExitEventsLost int = 4
+
+	// DefaultDrainTimeout is the default number of seconds to wait for the
+	// events in the queue to be sent.
+	DefaultDrainTimeout int = 20
+)
+
+// Options contains settings about how to shut down.
+type Options struct {
+	// DrainTimeout is how long to wait for the events in the queue to
+	// be sent.
+	DrainTimeout time.Duration
+	// PendingEventsFile is the path of the file where events that could
+	// not be sent are saved. If empty, they are discarded.
+	PendingEventsFile string
+}
+
+// WaitForSignal blocks until SIGINT or SIGTERM is received and returns it.
+func WaitForSignal() os.Signal {
+	sig, stop := NotifySignal()
+	defer stop()
+
+	return <-sig
+}
+
+// NotifySignal returns a channel that receives SIGINT or SIGTERM, for
+// when there are other reasons to stop besides a signal. The returned
+// function must be called to stop receiving them.
+func NotifySignal() (<-chan os.Signal, func()) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+
+	return sig, func() { signal.Stop(sig) }
+}
+
+// Drain closes the queue, waiting up to opts.DrainTimeout for the events
+// in it to be sent, and saves the ones that could not be sent to
+// opts.PendingEventsFile. Sources must be stopped before calling this, as
+// events enqueued after it are discarded.
+//
+// cancelSend is called when the timeout expires, to stop any request that
+// is still in progress: it should cancel the context used by the services
+// handler.
+//
+// It returns the exit code that the program should use.
+func Drain(q queue.Queue, cancelSend context.CancelFunc, opts Options) int {
+	l := log.With().Str("func", "shutdown.Drain").Logger()
+
+	ctx, canc := context.WithTimeout(context.Background(), opts.DrainTimeout)
+	defer canc()
+	go func() {
+		<-ctx.Done()
+		cancelSend()
+	}()
+
+	l.Info().Dur("timeout", opts.DrainTimeout).Msg("sending events still in the queue...")
+	notSent := q.Close(ctx)
+	if len(notSent) == 0 {
+		l.Info().Msg("all events have been sent")
+		return ExitOK
+	}
+
+	l = l.With().Int("events", len(notSent)).Logger()
+	if len(opts.PendingEventsFile) == 0 {
+		l.Error().Msg("some events could not be sent and have been discarded")
+		return ExitEventsLost
+	}
+
+	if err := queue.SavePending(opts.PendingEventsFile, notSent); err != nil {
+		l.Err(err).Str("file", opts.PendingEventsFile).Msg("some events could not be sent and could not be saved either")
+		return ExitEventsLost
+	}
+
+	l.Warn().Str("file", opts.PendingEventsFile).Msg("some events could not be sent: they have been saved and will be sent on next run")
+	return ExitEventsPersisted
+}
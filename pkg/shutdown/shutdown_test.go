@@ -0,0 +1,112 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package shutdown
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/queue"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeQueue struct {
+	notSent []queue.PendingEvent
+}
+
+func (f *fakeQueue) Enqueue(_ map[string]*openapi.Event) {}
+
+func (f *fakeQueue) Close(ctx context.Context) []queue.PendingEvent {
+	if len(f.notSent) > 0 {
+		<-ctx.Done()
+	}
+
+	return f.notSent
+}
+
+func TestDrain(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "cnwan-reader-shutdown")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+
+	notSent := []queue.PendingEvent{{Key: "one", Event: openapi.Event{Event: "create"}}}
+	cases := []struct {
+		notSent     []queue.PendingEvent
+		pendingFile string
+		expCode     int
+		expSaved    bool
+	}{
+		{
+			expCode: ExitOK,
+		},
+		{
+			notSent: notSent,
+			expCode: ExitEventsLost,
+		},
+		{
+			notSent:     notSent,
+			pendingFile: filepath.Join(dir, "pending.json"),
+			expCode:     ExitEventsPersisted,
+			expSaved:    true,
+		},
+		{
+			notSent:     notSent,
+			pendingFile: filepath.Join(dir, "not-existing", "pending.json"),
+			expCode:     ExitEventsLost,
+		},
+	}
+
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+
+	for i, currCase := range cases {
+		cancelled := make(chan bool)
+		code := Drain(&fakeQueue{notSent: currCase.notSent}, func() { close(cancelled) }, Options{
+			DrainTimeout:      10 * time.Millisecond,
+			PendingEventsFile: currCase.pendingFile,
+		})
+
+		if !a.Equal(currCase.expCode, code) {
+			failed(i)
+		}
+
+		if len(currCase.notSent) > 0 {
+			select {
+			case <-cancelled:
+			case <-time.After(time.Second):
+				failed(i)
+			}
+		}
+
+		if currCase.expSaved {
+			saved, err := queue.LoadPending(currCase.pendingFile)
+			if !a.NoError(err) || !a.Equal(currCase.notSent, saved) {
+				failed(i)
+			}
+		}
+	}
+}
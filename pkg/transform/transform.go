@@ -0,0 +1,178 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package transform
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
+)
+
+// Rule is a set of changes to apply to the metadata of services.
+type Rule struct {
+	// Source is the name of the source whose services are changed, i.e.
+	// etcd. If empty, the services of all sources are changed.
+	Source string
+	// RenameKeys maps the keys to rename to their new name.
+	RenameKeys map[string]string
+	// MapValues maps a key, after renaming it, to a lookup table of its
+	// values. Values that are not in the table are kept as they are.
+	MapValues map[string]map[string]string
+	// AddMetadata contains metadata to add to all services, replacing
+	// the values they already have for the same keys.
+	AddMetadata map[string]string
+}
+
+// Transformer changes the metadata of services according to its rules.
+// A nil Transformer doesn't change anything.
+type Transformer struct {
+	rules []Rule
+}
+
+// New returns a transformer that applies the provided rules in order, or
+// nil if no rule is provided.
+func New(rules []Rule) (*Transformer, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	for i, rule := range rules {
+		if err := check(rule); err != nil {
+			return nil, fmt.Errorf("invalid rule %d: %w", i, err)
+		}
+	}
+
+	return &Transformer{rules: rules}, nil
+}
+
+func check(rule Rule) error {
+	renamedFrom := map[string]string{}
+	for key, newKey := range rule.RenameKeys {
+		if len(key) == 0 || len(newKey) == 0 {
+			return fmt.Errorf("keys cannot be renamed from or to an empty key")
+		}
+
+		if other, exists := renamedFrom[newKey]; exists {
+			if other > key {
+				key, other = other, key
+			}
+			return fmt.Errorf("both %s and %s are renamed to %s", other, key, newKey)
+		}
+		renamedFrom[newKey] = key
+	}
+
+	for key := range rule.MapValues {
+		if len(key) == 0 {
+			return fmt.Errorf("values cannot be mapped for an empty key")
+		}
+	}
+
+	for key := range rule.AddMetadata {
+		if len(key) == 0 {
+			return fmt.Errorf("metadata with an empty key cannot be added")
+		}
+	}
+
+	return nil
+}
+
+// Apply returns the metadata of a service of the provided source after
+// applying all the rules to it. The provided metadata are not modified.
+func (t *Transformer) Apply(source string, metadata []openapi.Metadata) []openapi.Metadata {
+	if t == nil {
+		return metadata
+	}
+
+	result := append([]openapi.Metadata{}, metadata...)
+	for _, rule := range t.rules {
+		if len(rule.Source) > 0 && rule.Source != source {
+			continue
+		}
+
+		result = rule.apply(result)
+	}
+
+	return result
+}
+
+func (r *Rule) apply(metadata []openapi.Metadata) []openapi.Metadata {
+	result := make([]openapi.Metadata, 0, len(metadata)+len(r.AddMetadata))
+	positions := map[string]int{}
+	set := func(key, value string) {
+		if pos, exists := positions[key]; exists {
+			result[pos].Value = value
+			return
+		}
+
+		positions[key] = len(result)
+		result = append(result, openapi.Metadata{Key: key, Value: value})
+	}
+
+	for _, md := range metadata {
+		key, value := md.Key, md.Value
+		if newKey, exists := r.RenameKeys[key]; exists {
+			key = newKey
+		}
+		if newValue, exists := r.MapValues[key][value]; exists {
+			value = newValue
+		}
+
+		set(key, value)
+	}
+
+	keys := make([]string, 0, len(r.AddMetadata))
+	for key := range r.AddMetadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		set(key, r.AddMetadata[key])
+	}
+
+	return result
+}
+
+// SourceKeys returns the keys that the provided source must look for, so
+// that services have the provided keys after applying the rules, i.e.
+// the keys before being renamed.
+func (t *Transformer) SourceKeys(source string, keys []string) []string {
+	if t == nil {
+		return keys
+	}
+
+	sourceKeys := append([]string{}, keys...)
+	for i := len(t.rules) - 1; i >= 0; i-- {
+		rule := t.rules[i]
+		if len(rule.Source) > 0 && rule.Source != source {
+			continue
+		}
+
+		for j, key := range sourceKeys {
+			for oldKey, newKey := range rule.RenameKeys {
+				if newKey == key {
+					sourceKeys[j] = oldKey
+					break
+				}
+			}
+		}
+	}
+
+	return sourceKeys
+}
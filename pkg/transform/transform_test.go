@@ -0,0 +1,176 @@
+// Copyright © 2021 Cisco
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// All rights reserved.
+
+package transform
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/CloudNativeSDWAN/cnwan-reader/pkg/openapi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	a := assert.New(t)
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+
+	cases := []struct {
+		rules  []Rule
+		expRes *Transformer
+		expErr bool
+	}{
+		{},
+		{
+			rules: []Rule{
+				{Source: "etcd", RenameKeys: map[string]string{"cnwan.io/profile": "traffic-profile"}},
+				{MapValues: map[string]map[string]string{"traffic-profile": {"vid": "video"}}, AddMetadata: map[string]string{"site": "eu-west"}},
+			},
+			expRes: &Transformer{rules: []Rule{
+				{Source: "etcd", RenameKeys: map[string]string{"cnwan.io/profile": "traffic-profile"}},
+				{MapValues: map[string]map[string]string{"traffic-profile": {"vid": "video"}}, AddMetadata: map[string]string{"site": "eu-west"}},
+			}},
+		},
+		{
+			rules:  []Rule{{RenameKeys: map[string]string{"profile": ""}}},
+			expErr: true,
+		},
+		{
+			rules:  []Rule{{RenameKeys: map[string]string{"profile": "traffic-profile", "cnwan.io/profile": "traffic-profile"}}},
+			expErr: true,
+		},
+		{
+			rules:  []Rule{{MapValues: map[string]map[string]string{"": {"vid": "video"}}}},
+			expErr: true,
+		},
+		{
+			rules:  []Rule{{AddMetadata: map[string]string{"": "eu-west"}}},
+			expErr: true,
+		},
+	}
+
+	for i, currCase := range cases {
+		res, err := New(currCase.rules)
+		if currCase.expErr {
+			if !a.Error(err) {
+				failed(i)
+			}
+			continue
+		}
+
+		if !a.NoError(err) || !a.Equal(currCase.expRes, res) {
+			failed(i)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	a := assert.New(t)
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+	transformer, _ := New([]Rule{
+		{Source: "etcd", RenameKeys: map[string]string{"cnwan.io/profile": "traffic-profile"}},
+		{Source: "cloudmap", RenameKeys: map[string]string{"profile": "traffic-profile"}},
+		{MapValues: map[string]map[string]string{"traffic-profile": {"vid": "video"}}, AddMetadata: map[string]string{"site": "eu-west", "env": "prod"}},
+	})
+
+	cases := []struct {
+		transformer *Transformer
+		source      string
+		metadata    []openapi.Metadata
+		expRes      []openapi.Metadata
+	}{
+		{
+			source:   "etcd",
+			metadata: []openapi.Metadata{{Key: "cnwan.io/profile", Value: "vid"}},
+			expRes:   []openapi.Metadata{{Key: "cnwan.io/profile", Value: "vid"}},
+		},
+		{
+			transformer: transformer,
+			source:      "etcd",
+			metadata:    []openapi.Metadata{{Key: "cnwan.io/profile", Value: "vid"}, {Key: "site", Value: "us-east"}},
+			expRes:      []openapi.Metadata{{Key: "traffic-profile", Value: "video"}, {Key: "site", Value: "eu-west"}, {Key: "env", Value: "prod"}},
+		},
+		{
+			transformer: transformer,
+			source:      "cloudmap",
+			metadata:    []openapi.Metadata{{Key: "cnwan.io/profile", Value: "vid"}, {Key: "profile", Value: "voip"}},
+			expRes:      []openapi.Metadata{{Key: "cnwan.io/profile", Value: "vid"}, {Key: "traffic-profile", Value: "voip"}, {Key: "env", Value: "prod"}, {Key: "site", Value: "eu-west"}},
+		},
+		{
+			transformer: transformer,
+			source:      "servicedirectory",
+			metadata:    []openapi.Metadata{},
+			expRes:      []openapi.Metadata{{Key: "env", Value: "prod"}, {Key: "site", Value: "eu-west"}},
+		},
+	}
+
+	for i, currCase := range cases {
+		orig := append([]openapi.Metadata{}, currCase.metadata...)
+		res := currCase.transformer.Apply(currCase.source, currCase.metadata)
+		if !a.Equal(currCase.expRes, res) || !a.Equal(orig, currCase.metadata) {
+			failed(i)
+		}
+	}
+}
+
+func TestSourceKeys(t *testing.T) {
+	a := assert.New(t)
+	failed := func(i int) {
+		a.FailNow("case failed", fmt.Sprintf("case %d", i))
+	}
+	transformer, _ := New([]Rule{
+		{Source: "etcd", RenameKeys: map[string]string{"cnwan.io/profile": "profile"}},
+		{RenameKeys: map[string]string{"profile": "traffic-profile"}},
+	})
+
+	cases := []struct {
+		transformer *Transformer
+		source      string
+		keys        []string
+		expRes      []string
+	}{
+		{
+			source: "etcd",
+			keys:   []string{"traffic-profile"},
+			expRes: []string{"traffic-profile"},
+		},
+		{
+			transformer: transformer,
+			source:      "etcd",
+			keys:        []string{"traffic-profile", "site"},
+			expRes:      []string{"cnwan.io/profile", "site"},
+		},
+		{
+			transformer: transformer,
+			source:      "cloudmap",
+			keys:        []string{"traffic-profile"},
+			expRes:      []string{"profile"},
+		},
+	}
+
+	for i, currCase := range cases {
+		res := currCase.transformer.SourceKeys(currCase.source, currCase.keys)
+		if !a.Equal(currCase.expRes, res) {
+			failed(i)
+		}
+	}
+}